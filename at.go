@@ -36,6 +36,24 @@ func NewAtFunc(p image.Image) AtFunc {
 		return newAtFuncCMYK(p)
 	case *image.Uniform:
 		return newAtFuncUniform(p)
+	case *RGBA128F:
+		return newAtFuncRGBA128F(p)
+	case *FlipH:
+		return newAtFuncFlipH(p)
+	case *FlipV:
+		return newAtFuncFlipV(p)
+	case *Transpose:
+		return newAtFuncTranspose(p)
+	case *Rotate90:
+		return newAtFuncRotate90(p)
+	case *Rotate180:
+		return newAtFuncRotate180(p)
+	case *Rotate270:
+		return newAtFuncRotate270(p)
+	case *Crop:
+		return newAtFuncCrop(p)
+	case *Swizzle:
+		return newAtFuncSwizzle(p)
 	default:
 		return newAtFuncDefault(p)
 	}
@@ -284,6 +302,14 @@ func newAtFuncCMYK(p *image.CMYK) AtFunc {
 	}
 }
 
+func newAtFuncRGBA128F(p *RGBA128F) AtFunc {
+	return func(x, y int) (r, g, b, a uint32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		return ColorRGBA128F{s[0], s[1], s[2], s[3]}.RGBA()
+	}
+}
+
 func newAtFuncDefault(p image.Image) AtFunc {
 	return func(x, y int) (r, g, b, a uint32) {
 		return p.At(x, y).RGBA()
@@ -212,6 +212,24 @@ func BenchmarkNewAtFunc(b *testing.B) {
 				return image.NewCMYK(r)
 			},
 		},
+		{
+			"RGBA128F",
+			func(r image.Rectangle) image.Image {
+				return NewRGBA128F(r)
+			},
+		},
+		{
+			"Rotate90",
+			func(r image.Rectangle) image.Image {
+				return &Rotate90{image.NewRGBA(r)}
+			},
+		},
+		{
+			"Swizzle",
+			func(r image.Rectangle) image.Image {
+				return &Swizzle{image.NewRGBA(r), [4]int{2, 1, 0, 3}}
+			},
+		},
 		{
 			"Default",
 			func(r image.Rectangle) image.Image {
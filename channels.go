@@ -0,0 +1,249 @@
+package imageutil
+
+import (
+	"image"
+	"image/draw"
+)
+
+// ChannelOptions are the options of the channel functions.
+type ChannelOptions struct {
+	// Parallel is used to process the image concurrently.
+	Parallel *Parallel
+}
+
+// Split splits the channels of an image.
+//
+// The red, green and blue channels are returned as Gray images, with non-premultiplied values (see RGBAToNRGBA), and the alpha channel as an Alpha image.
+// The returned images have the same bounds as p.
+// There are fast paths for RGBA and NRGBA images, which read the Pix slice directly.
+// If opts is nil, the default options are used.
+func Split(p image.Image, opts *ChannelOptions) (r, g, b *image.Gray, a *image.Alpha) {
+	if opts == nil {
+		opts = new(ChannelOptions)
+	}
+	bd := p.Bounds()
+	r, g, b, a = image.NewGray(bd), image.NewGray(bd), image.NewGray(bd), image.NewAlpha(bd)
+	if bd.Empty() {
+		return r, g, b, a
+	}
+	var split func(x, y int) (uint8, uint8, uint8, uint8)
+	switch p := p.(type) {
+	case *image.NRGBA:
+		split = func(x, y int) (uint8, uint8, uint8, uint8) {
+			i := p.PixOffset(x, y)
+			s := p.Pix[i : i+4 : i+4]
+			return s[0], s[1], s[2], s[3]
+		}
+	case *image.RGBA:
+		split = func(x, y int) (uint8, uint8, uint8, uint8) {
+			i := p.PixOffset(x, y)
+			s := p.Pix[i : i+4 : i+4]
+			switch s[3] {
+			case 0xff:
+				return s[0], s[1], s[2], s[3]
+			case 0:
+				return 0, 0, 0, 0
+			}
+			cr, cg, cb, _ := RGBAToNRGBA(uint32(s[0])*0x101, uint32(s[1])*0x101, uint32(s[2])*0x101, uint32(s[3])*0x101)
+			return uint8(cr >> 8), uint8(cg >> 8), uint8(cb >> 8), s[3]
+		}
+	default:
+		at := NewAtFunc(p)
+		split = func(x, y int) (uint8, uint8, uint8, uint8) {
+			cr, cg, cb, ca := RGBAToNRGBA(at(x, y))
+			return uint8(cr >> 8), uint8(cg >> 8), uint8(cb >> 8), uint8(ca >> 8)
+		}
+	}
+	// The channel images have the same bounds and stride, so they share the offsets.
+	opts.Parallel.Run(bd, func(rr image.Rectangle) {
+		for y := rr.Min.Y; y < rr.Max.Y; y++ {
+			i := r.PixOffset(rr.Min.X, y)
+			for x := rr.Min.X; x < rr.Max.X; x++ {
+				r.Pix[i], g.Pix[i], b.Pix[i], a.Pix[i] = split(x, y)
+				i++
+			}
+		}
+	})
+	return r, g, b, a
+}
+
+// Merge merges channel images into dst.
+//
+// The value of a channel image is its red value read with NewAtFunc: it is the gray value of a Gray image, and the alpha value of an Alpha image.
+// The red, green and blue values are not premultiplied (see NRGBAToRGBA).
+// If r, g or b is nil, the channel is 0.
+// If a is nil, the pixels are opaque.
+// It processes the intersection of the bounds of dst and the channel images.
+// There is a fast path for RGBA and NRGBA destination images, with Gray red, green and blue images, and an Alpha (or nil) alpha image.
+// If opts is nil, the default options are used.
+func Merge(dst draw.Image, r, g, b, a image.Image, opts *ChannelOptions) {
+	if opts == nil {
+		opts = new(ChannelOptions)
+	}
+	bd := dst.Bounds()
+	for _, p := range []image.Image{r, g, b, a} {
+		if p != nil {
+			bd = bd.Intersect(p.Bounds())
+		}
+	}
+	if bd.Empty() {
+		return
+	}
+	if merge := newMergeFuncPix(dst, r, g, b, a); merge != nil {
+		opts.Parallel.Run(bd, merge)
+		return
+	}
+	var ats [4]AtFunc
+	for i, p := range []image.Image{r, g, b, a} {
+		if p != nil {
+			ats[i] = NewAtFunc(p)
+		}
+	}
+	set := NewSetFunc(dst)
+	opts.Parallel.Run(bd, func(rr image.Rectangle) {
+		for y := rr.Min.Y; y < rr.Max.Y; y++ {
+			for x := rr.Min.X; x < rr.Max.X; x++ {
+				vs := [4]uint32{0, 0, 0, 0xffff}
+				for i, at := range ats {
+					if at != nil {
+						vs[i], _, _, _ = at(x, y)
+					}
+				}
+				cr, cg, cb, ca := NRGBAToRGBA(vs[0], vs[1], vs[2], vs[3])
+				set(x, y, cr, cg, cb, ca)
+			}
+		}
+	})
+}
+
+// newMergeFuncPix returns a function that merges the channel images of a Rectangle directly in the Pix slice of dst.
+//
+// It returns nil if there is no fast path for the types.
+func newMergeFuncPix(dst draw.Image, r, g, b, a image.Image) func(image.Rectangle) {
+	var pix []uint8
+	var stride int
+	var rect image.Rectangle
+	premultiplied := false
+	switch dst := dst.(type) {
+	case *image.NRGBA:
+		pix, stride, rect = dst.Pix, dst.Stride, dst.Rect
+	case *image.RGBA:
+		pix, stride, rect = dst.Pix, dst.Stride, dst.Rect
+		premultiplied = true
+	default:
+		return nil
+	}
+	var grays [3]*image.Gray
+	for i, p := range []image.Image{r, g, b} {
+		gp, ok := p.(*image.Gray)
+		if !ok {
+			return nil
+		}
+		grays[i] = gp
+	}
+	ap, ok := a.(*image.Alpha)
+	if !ok && a != nil {
+		return nil
+	}
+	return func(rr image.Rectangle) {
+		for y := rr.Min.Y; y < rr.Max.Y; y++ {
+			di := (y-rect.Min.Y)*stride + (rr.Min.X-rect.Min.X)*4
+			d := pix[di : di+rr.Dx()*4]
+			var ss [3][]uint8
+			for i, gp := range grays {
+				si := gp.PixOffset(rr.Min.X, y)
+				ss[i] = gp.Pix[si : si+rr.Dx()]
+			}
+			var sa []uint8
+			if ap != nil {
+				si := ap.PixOffset(rr.Min.X, y)
+				sa = ap.Pix[si : si+rr.Dx()]
+			}
+			for j := 0; j < rr.Dx(); j++ {
+				cr, cg, cb, ca := ss[0][j], ss[1][j], ss[2][j], uint8(0xff)
+				if sa != nil {
+					ca = sa[j]
+				}
+				switch {
+				case ca == 0:
+					// Like the SetFunc of NRGBA, the transparent pixels are black.
+					cr, cg, cb = 0, 0, 0
+				case premultiplied && ca != 0xff:
+					r16, g16, b16, _ := NRGBAToRGBA(uint32(cr)*0x101, uint32(cg)*0x101, uint32(cb)*0x101, uint32(ca)*0x101)
+					cr, cg, cb = uint8(r16>>8), uint8(g16>>8), uint8(b16>>8)
+				}
+				k := j * 4
+				d[k], d[k+1], d[k+2], d[k+3] = cr, cg, cb, ca
+			}
+		}
+	}
+}
+
+// SwizzleChannels writes src to dst with reordered channels.
+//
+// The result is the same as Convert(dst, &Swizzle{Image: src, Channels: channels}), but it's faster.
+// The alpha-premultiplied values are reordered, except for the NRGBA fast path, which reorders the non-premultiplied values without losing precision.
+// It processes the intersection of the bounds of dst and src.
+// dst and src can be the same image.
+// There is a fast path for RGBA and NRGBA images, if dst and src have the same type and only the color channels are reordered: the bytes are reordered directly in the Pix slices.
+// It is useful to swap the red and blue channels (BGRA interop) with the channels {2, 1, 0, 3}.
+// It panics if a channel is not in [0, 3].
+// If opts is nil, the default options are used.
+func SwizzleChannels(dst draw.Image, src image.Image, channels [4]int, opts *ChannelOptions) {
+	checkSwizzleChannels(channels)
+	if opts == nil {
+		opts = new(ChannelOptions)
+	}
+	r := dst.Bounds().Intersect(src.Bounds())
+	if r.Empty() {
+		return
+	}
+	if channels[0] != 3 && channels[1] != 3 && channels[2] != 3 && channels[3] == 3 {
+		var dPix, sPix []uint8
+		var dStride, sStride int
+		var dRect, sRect image.Rectangle
+		ok := false
+		switch dst := dst.(type) {
+		case *image.RGBA:
+			if src, sok := src.(*image.RGBA); sok {
+				dPix, dStride, dRect, sPix, sStride, sRect, ok = dst.Pix, dst.Stride, dst.Rect, src.Pix, src.Stride, src.Rect, true
+			}
+		case *image.NRGBA:
+			if src, sok := src.(*image.NRGBA); sok {
+				dPix, dStride, dRect, sPix, sStride, sRect, ok = dst.Pix, dst.Stride, dst.Rect, src.Pix, src.Stride, src.Rect, true
+			}
+		}
+		if ok {
+			_, premultiplied := dst.(*image.RGBA)
+			opts.Parallel.Run(r, func(r image.Rectangle) {
+				for y := r.Min.Y; y < r.Max.Y; y++ {
+					di := (y-dRect.Min.Y)*dStride + (r.Min.X-dRect.Min.X)*4
+					si := (y-sRect.Min.Y)*sStride + (r.Min.X-sRect.Min.X)*4
+					d := dPix[di : di+r.Dx()*4]
+					s := sPix[si : si+r.Dx()*4]
+					for i := 0; i < len(d); i += 4 {
+						p := s[i : i+4 : i+4]
+						v0, v1, v2, a := p[channels[0]], p[channels[1]], p[channels[2]], p[3]
+						if !premultiplied && a == 0 {
+							// Like the SetFunc of NRGBA, the transparent pixels are black.
+							v0, v1, v2 = 0, 0, 0
+						}
+						d[i], d[i+1], d[i+2], d[i+3] = v0, v1, v2, a
+					}
+				}
+			})
+			return
+		}
+	}
+	at := NewAtFunc(src)
+	set := NewSetFunc(dst)
+	opts.Parallel.Run(r, func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				cr, cg, cb, ca := at(x, y)
+				cr, cg, cb, ca = swizzleRGBA(channels, cr, cg, cb, ca)
+				set(x, y, cr, cg, cb, ca)
+			}
+		}
+	})
+}
@@ -0,0 +1,235 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"testing"
+)
+
+func TestSplitMerge(t *testing.T) {
+	for _, newImage := range []func(image.Rectangle) draw.Image{
+		func(r image.Rectangle) draw.Image { return image.NewNRGBA(r) },
+		func(r image.Rectangle) draw.Image { return image.NewRGBA(r) },
+		func(r image.Rectangle) draw.Image { return image.NewRGBA64(r) },
+	} {
+		p := newImage(image.Rect(-3, 2, 20, 15))
+		draw.Draw(p, p.Bounds(), testNewRandomImage(p.Bounds()), p.Bounds().Min, draw.Src)
+		t.Run(fmt.Sprintf("%T", p), func(t *testing.T) {
+			opts := &ChannelOptions{Parallel: &Parallel{TileHeight: 3, MinTileSize: 1}}
+			r, g, b, a := Split(p, opts)
+			dst := newImage(p.Bounds())
+			Merge(dst, r, g, b, a, opts)
+			at, dstAt := NewAtFunc(p), NewAtFunc(dst)
+			bd := p.Bounds()
+			for y := bd.Min.Y; y < bd.Max.Y; y++ {
+				for x := bd.Min.X; x < bd.Max.X; x++ {
+					cr, cg, cb, ca := at(x, y)
+					dr, dg, db, da := dstAt(x, y)
+					// The values of the channel images are 8-bit and not premultiplied.
+					if testAbsDiff(cr, dr) > 0x202 || testAbsDiff(cg, dg) > 0x202 || testAbsDiff(cb, db) > 0x202 || testAbsDiff(ca, da) > 0x101 {
+						t.Fatalf("unexpected color at (%d, %d): got %v, want %v", x, y, []uint32{dr, dg, db, da}, []uint32{cr, cg, cb, ca})
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestSplitNRGBALossless(t *testing.T) {
+	p := testNewRandomImage(image.Rect(0, 0, 10, 10))
+	for i := 0; i < len(p.Pix); i += 4 {
+		if p.Pix[i+3] == 0 {
+			p.Pix[i+3] = 1
+		}
+	}
+	r, g, b, a := Split(p, nil)
+	dst := image.NewNRGBA(p.Bounds())
+	Merge(dst, r, g, b, a, nil)
+	for i, v := range dst.Pix {
+		if v != p.Pix[i] {
+			t.Fatalf("unexpected value at %d: got %d, want %d", i, v, p.Pix[i])
+		}
+	}
+}
+
+func TestSplitFastPath(t *testing.T) {
+	src := testNewOpaqueRandomImage(image.Rect(0, 0, 10, 10))
+	rgba := image.NewRGBA(src.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), testNewRandomImage(src.Bounds()), image.Point{}, draw.Src)
+	for _, p := range []image.Image{src, rgba} {
+		t.Run(fmt.Sprintf("%T", p), func(t *testing.T) {
+			r, g, b, a := Split(p, nil)
+			// The Crop view uses the generic path.
+			er, eg, eb, ea := Split(&Crop{Image: p, Rect: p.Bounds()}, nil)
+			for i, pair := range [][2][]uint8{{r.Pix, er.Pix}, {g.Pix, eg.Pix}, {b.Pix, eb.Pix}, {a.Pix, ea.Pix}} {
+				for j, v := range pair[0] {
+					if v != pair[1][j] {
+						t.Fatalf("unexpected value for channel %d at %d: got %d, want %d", i, j, v, pair[1][j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMergeFastPath(t *testing.T) {
+	crop := func(p image.Image) image.Image {
+		return &Crop{Image: p, Rect: p.Bounds()}
+	}
+	for _, tc := range []struct {
+		src      image.Image
+		newImage func(image.Rectangle) draw.Image
+	}{
+		// The generic path loses precision for the NRGBA pixels that are not opaque.
+		{testNewOpaqueRandomImage(image.Rect(0, 0, 10, 10)), func(r image.Rectangle) draw.Image { return image.NewNRGBA(r) }},
+		{testNewRandomImage(image.Rect(0, 0, 10, 10)), func(r image.Rectangle) draw.Image { return image.NewRGBA(r) }},
+	} {
+		src, newImage := tc.src, tc.newImage
+		r, g, b, a := Split(src, nil)
+		for _, alpha := range []image.Image{a, nil} {
+			dst := newImage(src.Bounds())
+			Merge(dst, r, g, b, alpha, nil)
+			expected := newImage(src.Bounds())
+			// The Crop views use the generic path.
+			var calpha image.Image
+			if alpha != nil {
+				calpha = crop(alpha)
+			}
+			Merge(expected, crop(r), crop(g), crop(b), calpha, nil)
+			testImageEqual(t, dst, expected)
+		}
+	}
+}
+
+func TestMergeFastPathTransparent(t *testing.T) {
+	bd := image.Rect(0, 0, 4, 4)
+	r, g, b, a := image.NewGray(bd), image.NewGray(bd), image.NewGray(bd), image.NewAlpha(bd)
+	for i := range r.Pix {
+		r.Pix[i], g.Pix[i], b.Pix[i] = 0x40, 0x80, 0xc0
+		if i%2 == 0 {
+			a.Pix[i] = 0xff
+		}
+	}
+	for _, newImage := range []func(image.Rectangle) draw.Image{
+		func(r image.Rectangle) draw.Image { return image.NewNRGBA(r) },
+		func(r image.Rectangle) draw.Image { return image.NewRGBA(r) },
+	} {
+		dst := newImage(bd)
+		Merge(dst, r, g, b, a, nil)
+		// The Crop views use the generic path.
+		expected := newImage(bd)
+		Merge(expected, &Crop{r, bd}, &Crop{g, bd}, &Crop{b, bd}, &Crop{a, bd}, nil)
+		var pix, expectedPix []uint8
+		switch dst := dst.(type) {
+		case *image.NRGBA:
+			pix, expectedPix = dst.Pix, expected.(*image.NRGBA).Pix
+		case *image.RGBA:
+			pix, expectedPix = dst.Pix, expected.(*image.RGBA).Pix
+		}
+		for i, v := range pix {
+			if v != expectedPix[i] {
+				t.Fatalf("%T: unexpected value at %d: got %#x, want %#x", dst, i, v, expectedPix[i])
+			}
+		}
+	}
+}
+
+func TestMergeNil(t *testing.T) {
+	p := image.NewGray(image.Rect(0, 0, 4, 4))
+	for i := range p.Pix {
+		p.Pix[i] = 0x80
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	Merge(dst, nil, p, nil, nil, nil)
+	if c := dst.RGBAAt(1, 1); c.R != 0 || c.G != 0x80 || c.B != 0 || c.A != 0xff {
+		t.Fatalf("unexpected color: %v", c)
+	}
+	// The pixels outside of the channel images are not changed.
+	if c := dst.RGBAAt(5, 5); c.A != 0 {
+		t.Fatalf("unexpected color: %v", c)
+	}
+}
+
+func TestSwizzleChannels(t *testing.T) {
+	src := testNewRandomImage(image.Rect(-3, 2, 20, 15))
+	opaque := testNewOpaqueRandomImage(src.Bounds())
+	for _, channels := range [][4]int{
+		{2, 1, 0, 3},
+		{1, 2, 0, 3},
+		{0, 0, 0, 3},
+		{3, 1, 2, 3},
+		{0, 1, 2, 0},
+		{3, 2, 1, 0},
+	} {
+		for _, tc := range []struct {
+			src      image.Image
+			newImage func(image.Rectangle) draw.Image
+		}{
+			{src, func(r image.Rectangle) draw.Image { return image.NewRGBA(r) }},
+			{src, func(r image.Rectangle) draw.Image { return image.NewRGBA64(r) }},
+			{opaque, func(r image.Rectangle) draw.Image { return image.NewNRGBA(r) }},
+		} {
+			p := tc.newImage(tc.src.Bounds())
+			draw.Draw(p, p.Bounds(), tc.src, tc.src.Bounds().Min, draw.Src)
+			t.Run(fmt.Sprintf("%v/%T", channels, p), func(t *testing.T) {
+				dst := tc.newImage(p.Bounds())
+				SwizzleChannels(dst, p, channels, &ChannelOptions{Parallel: &Parallel{TileHeight: 3, MinTileSize: 1}})
+				expected := tc.newImage(p.Bounds())
+				Convert(expected, &Swizzle{Image: p, Channels: channels})
+				testImageEqual(t, dst, expected)
+				// In place.
+				SwizzleChannels(p, p, channels, nil)
+				testImageEqual(t, p, expected)
+			})
+		}
+	}
+}
+
+func TestSwizzleChannelsInvalid(t *testing.T) {
+	p := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic")
+		}
+	}()
+	SwizzleChannels(p, p, [4]int{2, 1, 0, 7}, nil)
+}
+
+func testImageEqual(tb testing.TB, p1, p2 image.Image) {
+	tb.Helper()
+	at1, at2 := NewAtFunc(p1), NewAtFunc(p2)
+	bd := p1.Bounds()
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			r1, g1, b1, a1 := at1(x, y)
+			r2, g2, b2, a2 := at2(x, y)
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				tb.Fatalf("unexpected color at (%d, %d): got %v, want %v", x, y, []uint32{r1, g1, b1, a1}, []uint32{r2, g2, b2, a2})
+			}
+		}
+	}
+}
+
+func BenchmarkSplit(b *testing.B) {
+	p := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	for i := 0; i < b.N; i++ {
+		Split(p, nil)
+	}
+}
+
+func BenchmarkMerge(b *testing.B) {
+	p := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	r, g, bl, a := Split(p, nil)
+	dst := image.NewRGBA(p.Bounds())
+	for i := 0; i < b.N; i++ {
+		Merge(dst, r, g, bl, a, nil)
+	}
+}
+
+func BenchmarkSwizzleChannels(b *testing.B) {
+	p := image.NewRGBA(image.Rect(0, 0, 512, 512))
+	for i := 0; i < b.N; i++ {
+		SwizzleChannels(p, p, [4]int{2, 1, 0, 3}, nil)
+	}
+}
@@ -0,0 +1,309 @@
+package imageutil
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// The conversion functions of this file take and return alpha-premultiplied 16 bits RGBA values, like AtFunc and SetFunc.
+// The color is unpremultiplied before the conversion, and the alpha value is returned unchanged.
+// The components of the color spaces are float64 values.
+//
+// The round trip error (RGBA -> color space -> RGBA) is at most 1 per 16 bits channel.
+// For transparent and translucent colors, it is the error of RGBAToNRGBA followed by NRGBAToRGBA.
+
+// RGBAToHSL converts RGBA to HSL.
+//
+// h is in [0, 360[, s and l are in [0, 1].
+func RGBAToHSL(r, g, b, a uint32) (h, s, l float64, alpha uint32) {
+	rf, gf, bf, alpha := rgbaToFloat(r, g, b, a)
+	max, min := math.Max(rf, math.Max(gf, bf)), math.Min(rf, math.Min(gf, bf))
+	l = (max + min) / 2
+	d := max - min
+	if d == 0 {
+		return 0, 0, l, alpha
+	}
+	s = d / (1 - math.Abs(2*l-1))
+	h = hue(rf, gf, bf, max, d)
+	return h, s, l, alpha
+}
+
+// HSLToRGBA converts HSL to RGBA.
+func HSLToRGBA(h, s, l float64, alpha uint32) (r, g, b, a uint32) {
+	c := (1 - math.Abs(2*l-1)) * s
+	rf, gf, bf := hueToRGB(h, c)
+	m := l - c/2
+	return floatToRGBA(rf+m, gf+m, bf+m, alpha)
+}
+
+// RGBAToHSV converts RGBA to HSV.
+//
+// h is in [0, 360[, s and v are in [0, 1].
+func RGBAToHSV(r, g, b, a uint32) (h, s, v float64, alpha uint32) {
+	rf, gf, bf, alpha := rgbaToFloat(r, g, b, a)
+	max, min := math.Max(rf, math.Max(gf, bf)), math.Min(rf, math.Min(gf, bf))
+	v = max
+	d := max - min
+	if d == 0 {
+		return 0, 0, v, alpha
+	}
+	s = d / max
+	h = hue(rf, gf, bf, max, d)
+	return h, s, v, alpha
+}
+
+// HSVToRGBA converts HSV to RGBA.
+func HSVToRGBA(h, s, v float64, alpha uint32) (r, g, b, a uint32) {
+	c := v * s
+	rf, gf, bf := hueToRGB(h, c)
+	m := v - c
+	return floatToRGBA(rf+m, gf+m, bf+m, alpha)
+}
+
+// hue returns the hue in degrees, in [0, 360[.
+func hue(r, g, b, max, d float64) float64 {
+	var h float64
+	switch max {
+	case r:
+		h = (g - b) / d
+	case g:
+		h = (b-r)/d + 2
+	default:
+		h = (r-g)/d + 4
+	}
+	h *= 60
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// hueToRGB returns the RGB values of a hue (in degrees) with the given chroma, without the lightness offset.
+func hueToRGB(h, c float64) (r, g, b float64) {
+	h = math.Mod(h, 360)
+	if h < 0 {
+		h += 360
+	}
+	hh := h / 60
+	x := c * (1 - math.Abs(math.Mod(hh, 2)-1))
+	switch {
+	case hh < 1:
+		return c, x, 0
+	case hh < 2:
+		return x, c, 0
+	case hh < 3:
+		return 0, c, x
+	case hh < 4:
+		return 0, x, c
+	case hh < 5:
+		return x, 0, c
+	default:
+		return c, 0, x
+	}
+}
+
+// D65 reference white, used by CIE Lab.
+const (
+	labWhiteX = 0.95047
+	labWhiteY = 1.0
+	labWhiteZ = 1.08883
+)
+
+// RGBAToLab converts RGBA (sRGB D65) to CIE L*a*b* (D65).
+//
+// l is in [0, 100], a and b are approximately in [-128, 128].
+func RGBAToLab(r, g, b, a uint32) (l, aa, bb float64, alpha uint32) {
+	rf, gf, bf, alpha := rgbaToLinearFloat(r, g, b, a)
+	x := (0.4124564*rf + 0.3575761*gf + 0.1804375*bf) / labWhiteX
+	y := (0.2126729*rf + 0.7151522*gf + 0.0721750*bf) / labWhiteY
+	z := (0.0193339*rf + 0.1191920*gf + 0.9503041*bf) / labWhiteZ
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz), alpha
+}
+
+// LabToRGBA converts CIE L*a*b* (D65) to RGBA (sRGB D65).
+//
+// The colors outside of the sRGB gamut are clipped.
+func LabToRGBA(l, aa, bb float64, alpha uint32) (r, g, b, a uint32) {
+	fy := (l + 16) / 116
+	fx := fy + aa/500
+	fz := fy - bb/200
+	x := labFInv(fx) * labWhiteX
+	y := labFInv(fy) * labWhiteY
+	z := labFInv(fz) * labWhiteZ
+	rf := 3.2404542*x - 1.5371385*y - 0.4985314*z
+	gf := -0.9692660*x + 1.8760108*y + 0.0415560*z
+	bf := 0.0556434*x - 0.2040259*y + 1.0572252*z
+	return linearFloatToRGBA(rf, gf, bf, alpha)
+}
+
+const labDelta = 6.0 / 29
+
+func labF(t float64) float64 {
+	if t > labDelta*labDelta*labDelta {
+		return math.Cbrt(t)
+	}
+	return t/(3*labDelta*labDelta) + 4.0/29
+}
+
+func labFInv(t float64) float64 {
+	if t > labDelta {
+		return t * t * t
+	}
+	return 3 * labDelta * labDelta * (t - 4.0/29)
+}
+
+// RGBAToLCh converts RGBA to CIE LCh(ab), the polar form of CIE L*a*b*.
+//
+// l is in [0, 100], c is >= 0, h is in [0, 360[.
+func RGBAToLCh(r, g, b, a uint32) (l, c, h float64, alpha uint32) {
+	l, aa, bb, alpha := RGBAToLab(r, g, b, a)
+	c, h = toPolar(aa, bb)
+	return l, c, h, alpha
+}
+
+// LChToRGBA converts CIE LCh(ab) to RGBA.
+func LChToRGBA(l, c, h float64, alpha uint32) (r, g, b, a uint32) {
+	aa, bb := fromPolar(c, h)
+	return LabToRGBA(l, aa, bb, alpha)
+}
+
+func toPolar(a, b float64) (c, h float64) {
+	c = math.Hypot(a, b)
+	h = math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return c, h
+}
+
+func fromPolar(c, h float64) (a, b float64) {
+	hr := h * math.Pi / 180
+	return c * math.Cos(hr), c * math.Sin(hr)
+}
+
+// RGBAToOKLab converts RGBA (sRGB) to OKLab.
+//
+// l is in [0, 1], a and b are approximately in [-0.5, 0.5].
+func RGBAToOKLab(r, g, b, a uint32) (l, aa, bb float64, alpha uint32) {
+	rf, gf, bf, alpha := rgbaToLinearFloat(r, g, b, a)
+	lc := math.Cbrt(0.4122214708*rf + 0.5363325363*gf + 0.0514459929*bf)
+	mc := math.Cbrt(0.2119034982*rf + 0.6806995451*gf + 0.1073969566*bf)
+	sc := math.Cbrt(0.0883024619*rf + 0.2817188376*gf + 0.6299787005*bf)
+	l = 0.2104542553*lc + 0.7936177850*mc - 0.0040720468*sc
+	aa = 1.9779984951*lc - 2.4285922050*mc + 0.4505937099*sc
+	bb = 0.0259040371*lc + 0.7827717662*mc - 0.8086757660*sc
+	return l, aa, bb, alpha
+}
+
+// OKLabToRGBA converts OKLab to RGBA (sRGB).
+//
+// The colors outside of the sRGB gamut are clipped.
+func OKLabToRGBA(l, aa, bb float64, alpha uint32) (r, g, b, a uint32) {
+	lc := l + 0.3963377774*aa + 0.2158037573*bb
+	mc := l - 0.1055613458*aa - 0.0638541728*bb
+	sc := l - 0.0894841775*aa - 1.2914855480*bb
+	lc, mc, sc = lc*lc*lc, mc*mc*mc, sc*sc*sc
+	rf := 4.0767416621*lc - 3.3077115913*mc + 0.2309699292*sc
+	gf := -1.2684380046*lc + 2.6097574011*mc - 0.3413193965*sc
+	bf := -0.0041960863*lc - 0.7034186147*mc + 1.7076147010*sc
+	return linearFloatToRGBA(rf, gf, bf, alpha)
+}
+
+// rgbaToFloat unpremultiplies RGBA and returns the color components in [0, 1].
+func rgbaToFloat(r, g, b, a uint32) (rf, gf, bf float64, alpha uint32) {
+	r, g, b, a = RGBAToNRGBA(r, g, b, a)
+	return float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff, a
+}
+
+// floatToRGBA converts color components in [0, 1] to premultiplied RGBA.
+//
+// The components are clipped.
+func floatToRGBA(rf, gf, bf float64, alpha uint32) (r, g, b, a uint32) {
+	return NRGBAToRGBA(floatToUint16(rf), floatToUint16(gf), floatToUint16(bf), alpha)
+}
+
+func floatToUint16(v float64) uint32 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 0xffff
+	}
+	return uint32(v*0xffff + 0.5)
+}
+
+// rgbaToLinearFloat unpremultiplies RGBA and returns the linear light components in [0, 1].
+func rgbaToLinearFloat(r, g, b, a uint32) (rf, gf, bf float64, alpha uint32) {
+	rf, gf, bf, alpha = rgbaToFloat(r, g, b, a)
+	return srgbToLinear(rf), srgbToLinear(gf), srgbToLinear(bf), alpha
+}
+
+// linearFloatToRGBA converts linear light components in [0, 1] to premultiplied sRGB encoded RGBA.
+func linearFloatToRGBA(rf, gf, bf float64, alpha uint32) (r, g, b, a uint32) {
+	return floatToRGBA(linearToSRGB(clampFloat(rf)), linearToSRGB(clampFloat(gf)), linearToSRGB(clampFloat(bf)), alpha)
+}
+
+func clampFloat(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+// ColorSpace is a color space with 3 components.
+type ColorSpace interface {
+	// FromRGBA converts premultiplied RGBA to the color space.
+	FromRGBA(r, g, b, a uint32) (c0, c1, c2 float64, alpha uint32)
+	// ToRGBA converts the color space to premultiplied RGBA.
+	ToRGBA(c0, c1, c2 float64, alpha uint32) (r, g, b, a uint32)
+}
+
+type colorSpaceFuncs struct {
+	from func(r, g, b, a uint32) (c0, c1, c2 float64, alpha uint32)
+	to   func(c0, c1, c2 float64, alpha uint32) (r, g, b, a uint32)
+}
+
+func (cs colorSpaceFuncs) FromRGBA(r, g, b, a uint32) (c0, c1, c2 float64, alpha uint32) {
+	return cs.from(r, g, b, a)
+}
+
+func (cs colorSpaceFuncs) ToRGBA(c0, c1, c2 float64, alpha uint32) (r, g, b, a uint32) {
+	return cs.to(c0, c1, c2, alpha)
+}
+
+// Color spaces.
+var (
+	ColorSpaceHSL   ColorSpace = colorSpaceFuncs{RGBAToHSL, HSLToRGBA}
+	ColorSpaceHSV   ColorSpace = colorSpaceFuncs{RGBAToHSV, HSVToRGBA}
+	ColorSpaceLab   ColorSpace = colorSpaceFuncs{RGBAToLab, LabToRGBA}
+	ColorSpaceLCh   ColorSpace = colorSpaceFuncs{RGBAToLCh, LChToRGBA}
+	ColorSpaceOKLab ColorSpace = colorSpaceFuncs{RGBAToOKLab, OKLabToRGBA}
+)
+
+// ColorSpaceAtFunc returns the color of the pixel at (x, y) in a color space.
+type ColorSpaceAtFunc func(x, y int) (c0, c1, c2 float64, a uint32)
+
+// NewColorSpaceAtFunc returns a ColorSpaceAtFunc for an Image.
+func NewColorSpaceAtFunc(p image.Image, cs ColorSpace) ColorSpaceAtFunc {
+	at := NewAtFunc(p)
+	return func(x, y int) (c0, c1, c2 float64, a uint32) {
+		return cs.FromRGBA(at(x, y))
+	}
+}
+
+// ColorSpaceSetFunc sets the color of the pixel at (x, y) in a color space.
+type ColorSpaceSetFunc func(x, y int, c0, c1, c2 float64, a uint32)
+
+// NewColorSpaceSetFunc returns a ColorSpaceSetFunc for an Image.
+func NewColorSpaceSetFunc(p draw.Image, cs ColorSpace) ColorSpaceSetFunc {
+	set := NewSetFunc(p)
+	return func(x, y int, c0, c1, c2 float64, a uint32) {
+		r, g, b, a := cs.ToRGBA(c0, c1, c2, a)
+		set(x, y, r, g, b, a)
+	}
+}
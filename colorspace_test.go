@@ -0,0 +1,93 @@
+package imageutil
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+var testColorSpaces = map[string]ColorSpace{
+	"HSL":   ColorSpaceHSL,
+	"HSV":   ColorSpaceHSV,
+	"Lab":   ColorSpaceLab,
+	"LCh":   ColorSpaceLCh,
+	"OKLab": ColorSpaceOKLab,
+}
+
+func TestColorSpaceRoundTrip(t *testing.T) {
+	for name, cs := range testColorSpaces {
+		t.Run(name, func(t *testing.T) {
+			for _, c := range testColors {
+				r, g, b, a := c.RGBA()
+				c0, c1, c2, alpha := cs.FromRGBA(r, g, b, a)
+				if alpha != a {
+					t.Fatalf("different alpha: %v: got %d, want %d", c, alpha, a)
+				}
+				r2, g2, b2, a2 := cs.ToRGBA(c0, c1, c2, alpha)
+				if testAbsDiff(r, r2) > 1 || testAbsDiff(g, g2) > 1 || testAbsDiff(b, b2) > 1 || a != a2 {
+					t.Fatalf("round trip error: %v: got {%d %d %d %d}, want {%d %d %d %d}", c, r2, g2, b2, a2, r, g, b, a)
+				}
+			}
+		})
+	}
+}
+
+func TestColorSpaceValues(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		f          func(r, g, b, a uint32) (float64, float64, float64, uint32)
+		r, g, b    uint32
+		c0, c1, c2 float64
+	}{
+		{"HSLRed", RGBAToHSL, 0xffff, 0, 0, 0, 1, 0.5},
+		{"HSLCyan", RGBAToHSL, 0, 0xffff, 0xffff, 180, 1, 0.5},
+		{"HSVBlue", RGBAToHSV, 0, 0, 0xffff, 240, 1, 1},
+		{"HSVGray", RGBAToHSV, 0x8000, 0x8000, 0x8000, 0, 0, float64(0x8000) / 0xffff},
+		{"LabWhite", RGBAToLab, 0xffff, 0xffff, 0xffff, 100, 0, 0},
+		{"LabRed", RGBAToLab, 0xffff, 0, 0, 53.2408, 80.0925, 67.2032},
+		{"LChBlack", RGBAToLCh, 0, 0, 0, 0, 0, 0},
+		{"OKLabWhite", RGBAToOKLab, 0xffff, 0xffff, 0xffff, 1, 0, 0},
+		{"OKLabRed", RGBAToOKLab, 0xffff, 0, 0, 0.627955, 0.224863, 0.125846},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c0, c1, c2, _ := tc.f(tc.r, tc.g, tc.b, 0xffff)
+			if math.Abs(c0-tc.c0) > 1e-3 || math.Abs(c1-tc.c1) > 1e-3 || math.Abs(c2-tc.c2) > 1e-3 {
+				t.Fatalf("different values: got {%f %f %f}, want {%f %f %f}", c0, c1, c2, tc.c0, tc.c1, tc.c2)
+			}
+		})
+	}
+}
+
+func TestNewColorSpaceAtFuncSetFunc(t *testing.T) {
+	bd := image.Rect(0, 0, 3, 3)
+	for name, cs := range testColorSpaces {
+		t.Run(name, func(t *testing.T) {
+			src := image.NewRGBA64(bd)
+			dst := image.NewRGBA64(bd)
+			at := NewColorSpaceAtFunc(src, cs)
+			set := NewColorSpaceSetFunc(dst, cs)
+			for _, c := range testColors {
+				src.Set(1, 1, c)
+				c0, c1, c2, a := at(1, 1)
+				set(1, 1, c0, c1, c2, a)
+				r1, g1, b1, a1 := src.At(1, 1).RGBA()
+				r2, g2, b2, a2 := dst.At(1, 1).RGBA()
+				if testAbsDiff(r1, r2) > 1 || testAbsDiff(g1, g2) > 1 || testAbsDiff(b1, b2) > 1 || a1 != a2 {
+					t.Fatalf("%s: round trip error: %v: got {%d %d %d %d}, want {%d %d %d %d}", name, c, r2, g2, b2, a2, r1, g1, b1, a1)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkColorSpace(b *testing.B) {
+	for name, cs := range testColorSpaces {
+		b.Run(name, func(b *testing.B) {
+			var resR, resG, resB, resA uint32
+			for i := 0; i < b.N; i++ {
+				resR, resG, resB, resA = cs.ToRGBA(cs.FromRGBA(0x8000, 0x4000, 0x0000, 0xffff))
+			}
+			benchResR, benchResG, benchResB, benchResA = resR, resG, resB, resA
+		})
+	}
+}
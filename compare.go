@@ -0,0 +1,321 @@
+package imageutil
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// ErrBoundsMismatch is returned when images don't have the same size.
+var ErrBoundsMismatch = errors.New("bounds mismatch")
+
+// CompareOptions are the options of Compare.
+type CompareOptions struct {
+	// Threshold is the maximum absolute difference (in 16-bit) of the channels of similar pixels.
+	// The pixels with a channel difference greater than it are counted in CompareResult.DiffPixels.
+	Threshold uint32
+	// SSIM computes CompareResult.SSIM.
+	SSIM bool
+	// MSSSIM computes CompareResult.MSSSIM.
+	MSSSIM bool
+	// Diff is an optional image where a visual diff is written.
+	// It must have the same size as the compared images.
+	// The different pixels are red, and the similar pixels are a faded grayscale version of the first image.
+	Diff draw.Image
+	// Parallel is used to process the images concurrently.
+	Parallel *Parallel
+}
+
+// CompareResult is the result of Compare.
+//
+// The channels are red, green, blue and alpha, and the values are alpha-premultiplied.
+type CompareResult struct {
+	// MSE is the mean squared error of each channel, with values normalized to [0, 1].
+	MSE [4]float64
+	// PSNR is the peak signal-to-noise ratio of each channel, in dB.
+	// It is +Inf for identical channels.
+	PSNR [4]float64
+	// MaxDiff is the maximum absolute difference (in 16-bit) of each channel.
+	MaxDiff [4]uint32
+	// DiffPixels is the number of pixels with a channel difference greater than CompareOptions.Threshold.
+	DiffPixels int
+	// SSIM is the mean structural similarity index of each channel.
+	// It is only computed if CompareOptions.SSIM is true.
+	SSIM [4]float64
+	// MSSSIM is the multi-scale structural similarity index of each channel.
+	// It is only computed if CompareOptions.MSSSIM is true.
+	MSSSIM [4]float64
+}
+
+// Compare compares 2 images.
+//
+// The images must have the same size, but they can have different bounds origins: the pixels are aligned on the minimum point of the bounds.
+// If they don't have the same size, it returns an error wrapping ErrBoundsMismatch.
+// The values are read with NewAtFunc.
+// SSIM uses a 11x11 Gaussian window with a standard deviation of 1.5 (smaller for smaller images), and only the pixels where the window is fully inside the images.
+// MS-SSIM uses up to 5 scales, with the weights of Wang et al.
+// If opts is nil, the default options are used.
+func Compare(p1, p2 image.Image, opts *CompareOptions) (*CompareResult, error) {
+	if opts == nil {
+		opts = new(CompareOptions)
+	}
+	r1, r2 := p1.Bounds(), p2.Bounds()
+	if r1.Size() != r2.Size() {
+		return nil, fmt.Errorf("%w: %v and %v", ErrBoundsMismatch, r1, r2)
+	}
+	if opts.Diff != nil && opts.Diff.Bounds().Size() != r1.Size() {
+		return nil, fmt.Errorf("%w: diff %v and %v", ErrBoundsMismatch, opts.Diff.Bounds(), r1)
+	}
+	pl := opts.Parallel
+	res := new(CompareResult)
+	compareDiff(res, p1, p2, opts, pl)
+	if opts.SSIM || opts.MSSSIM {
+		ps1 := newComparePlanes(p1, pl)
+		ps2 := newComparePlanes(p2, pl)
+		w, h := r1.Dx(), r1.Dy()
+		for ch := 0; ch < 4; ch++ {
+			if opts.SSIM {
+				res.SSIM[ch], _ = ssim(ps1[ch], ps2[ch], w, h, pl)
+			}
+			if opts.MSSSIM {
+				res.MSSSIM[ch] = msssim(ps1[ch], ps2[ch], w, h, pl)
+			}
+		}
+	}
+	return res, nil
+}
+
+type compareStats struct {
+	sums    [4]float64
+	maxDiff [4]uint32
+	pixels  int
+}
+
+// compareDiff computes the MSE, PSNR, max difference and different pixels count, and writes the diff image.
+func compareDiff(res *CompareResult, p1, p2 image.Image, opts *CompareOptions, pl *Parallel) {
+	r1, r2 := p1.Bounds(), p2.Bounds()
+	if r1.Empty() {
+		// Empty images are identical.
+		for ch := range res.PSNR {
+			res.PSNR[ch] = math.Inf(1)
+		}
+		return
+	}
+	at1, at2 := NewAtFunc(p1), NewAtFunc(p2)
+	var set SetFunc
+	var dp image.Point
+	if opts.Diff != nil {
+		set = NewSetFunc(opts.Diff)
+		dp = opts.Diff.Bounds().Min.Sub(r1.Min)
+	}
+	o := r2.Min.Sub(r1.Min)
+	st := ParallelReduce(pl, r1, func(r image.Rectangle) compareStats {
+		var st compareStats
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				v1r, v1g, v1b, v1a := at1(x, y)
+				v2r, v2g, v2b, v2a := at2(x+o.X, y+o.Y)
+				vs1 := [4]uint32{v1r, v1g, v1b, v1a}
+				vs2 := [4]uint32{v2r, v2g, v2b, v2a}
+				diff := false
+				for ch := range vs1 {
+					d := vs1[ch] - vs2[ch]
+					if vs2[ch] > vs1[ch] {
+						d = vs2[ch] - vs1[ch]
+					}
+					fd := float64(d) / 0xffff
+					st.sums[ch] += fd * fd
+					if d > st.maxDiff[ch] {
+						st.maxDiff[ch] = d
+					}
+					if d > opts.Threshold {
+						diff = true
+					}
+				}
+				if diff {
+					st.pixels++
+				}
+				if set != nil {
+					if diff {
+						set(x+dp.X, y+dp.Y, 0xffff, 0, 0, 0xffff)
+					} else {
+						// The luminance of the first image, over white, faded to 10%.
+						l := (19595*v1r + 38470*v1g + 7471*v1b + 1<<15) >> 16
+						l += 0xffff - v1a
+						l = 0xffff - (0xffff-l)/10
+						set(x+dp.X, y+dp.Y, l, l, l, 0xffff)
+					}
+				}
+			}
+		}
+		return st
+	}, func(a, b compareStats) compareStats {
+		for ch := range a.sums {
+			a.sums[ch] += b.sums[ch]
+			if b.maxDiff[ch] > a.maxDiff[ch] {
+				a.maxDiff[ch] = b.maxDiff[ch]
+			}
+		}
+		a.pixels += b.pixels
+		return a
+	})
+	n := float64(r1.Dx() * r1.Dy())
+	for ch := range res.MSE {
+		res.MSE[ch] = st.sums[ch] / n
+		res.PSNR[ch] = 10 * math.Log10(1/res.MSE[ch])
+	}
+	res.MaxDiff = st.maxDiff
+	res.DiffPixels = st.pixels
+}
+
+// newComparePlanes returns the 4 channel planes of an image, with values in [0, 1].
+func newComparePlanes(p image.Image, pl *Parallel) [4][]float64 {
+	bd := p.Bounds()
+	w := bd.Dx()
+	var ps [4][]float64
+	for ch := range ps {
+		ps[ch] = make([]float64, w*bd.Dy())
+	}
+	at := NewAtFunc(p)
+	pl.Run(bd, func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				i := (y-bd.Min.Y)*w + x - bd.Min.X
+				cr, cg, cb, ca := at(x, y)
+				ps[0][i] = float64(cr) / 0xffff
+				ps[1][i] = float64(cg) / 0xffff
+				ps[2][i] = float64(cb) / 0xffff
+				ps[3][i] = float64(ca) / 0xffff
+			}
+		}
+	})
+	return ps
+}
+
+const (
+	ssimC1 = 0.01 * 0.01
+	ssimC2 = 0.03 * 0.03
+)
+
+// ssim returns the mean SSIM and the mean contrast-structure term of 2 planes of size w x h.
+func ssim(p1, p2 []float64, w, h int, pl *Parallel) (ssim float64, cs float64) {
+	n := 11
+	if w < n {
+		n = w
+	}
+	if h < n {
+		n = h
+	}
+	if n <= 0 {
+		return 1, 1
+	}
+	k := ssimKernel(n)
+	ow, oh := w-n+1, h-n+1
+	// The horizontal pass computes the local sums of x, y, x², y² and xy.
+	tmp := make([][5]float64, ow*h)
+	pl.Run(image.Rect(0, 0, ow, h), func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				var s [5]float64
+				i := y*w + x
+				for j, kv := range k {
+					v1, v2 := p1[i+j], p2[i+j]
+					s[0] += kv * v1
+					s[1] += kv * v2
+					s[2] += kv * v1 * v1
+					s[3] += kv * v2 * v2
+					s[4] += kv * v1 * v2
+				}
+				tmp[y*ow+x] = s
+			}
+		}
+	})
+	// The vertical pass computes the SSIM of each window.
+	sums := ParallelReduce(pl, image.Rect(0, 0, ow, oh), func(r image.Rectangle) [2]float64 {
+		var sums [2]float64
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				var s [5]float64
+				for j, kv := range k {
+					t := &tmp[(y+j)*ow+x]
+					for c := range s {
+						s[c] += kv * t[c]
+					}
+				}
+				mu1, mu2 := s[0], s[1]
+				v1 := s[2] - mu1*mu1
+				v2 := s[3] - mu2*mu2
+				cov := s[4] - mu1*mu2
+				l := (2*mu1*mu2 + ssimC1) / (mu1*mu1 + mu2*mu2 + ssimC1)
+				c := (2*cov + ssimC2) / (v1 + v2 + ssimC2)
+				sums[0] += l * c
+				sums[1] += c
+			}
+		}
+		return sums
+	}, func(a, b [2]float64) [2]float64 {
+		return [2]float64{a[0] + b[0], a[1] + b[1]}
+	})
+	count := float64(ow * oh)
+	return sums[0] / count, sums[1] / count
+}
+
+// ssimKernel returns the normalized 1D Gaussian kernel of size n used by SSIM.
+func ssimKernel(n int) []float64 {
+	k := make([]float64, n)
+	c := float64(n-1) / 2
+	for i := range k {
+		x := float64(i) - c
+		k[i] = math.Exp(-x * x / (2 * 1.5 * 1.5))
+	}
+	return normalizeKernel(k)
+}
+
+var msssimWeights = []float64{0.0448, 0.2856, 0.3001, 0.2363, 0.1333}
+
+// msssim returns the MS-SSIM of 2 planes of size w x h.
+//
+// The planes are downsampled by 2 between the scales.
+// The number of scales is reduced for small images, and the weights are normalized.
+func msssim(p1, p2 []float64, w, h int, pl *Parallel) float64 {
+	scales := 1
+	for scales < len(msssimWeights) && w>>scales >= 11 && h>>scales >= 11 {
+		scales++
+	}
+	ws := msssimWeights[:scales]
+	var wsum float64
+	for _, v := range ws {
+		wsum += v
+	}
+	res := 1.0
+	for i, wv := range ws {
+		s, cs := ssim(p1, p2, w, h, pl)
+		v := cs
+		if i == scales-1 {
+			v = s
+		}
+		// The negative values are clipped, because they can't be raised to a fractional power.
+		res *= math.Pow(math.Max(v, 0), wv/wsum)
+		if i < scales-1 {
+			p1 = downsamplePlane(p1, w, h)
+			p2 = downsamplePlane(p2, w, h)
+			w, h = w/2, h/2
+		}
+	}
+	return res
+}
+
+// downsamplePlane returns a plane of size (w/2) x (h/2), averaging 2x2 pixels.
+func downsamplePlane(p []float64, w, h int) []float64 {
+	dw, dh := w/2, h/2
+	d := make([]float64, dw*dh)
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			i := 2*y*w + 2*x
+			d[y*dw+x] = (p[i] + p[i+1] + p[i+w] + p[i+w+1]) / 4
+		}
+	}
+	return d
+}
@@ -0,0 +1,206 @@
+package imageutil
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestCompareIdentical(t *testing.T) {
+	p1 := testNewRandomImage(image.Rect(0, 0, 40, 30))
+	// The same pixels, with another type and another origin.
+	p2 := image.NewRGBA64(image.Rect(-5, 7, 35, 37))
+	draw.Draw(p2, p2.Bounds(), p1, p1.Bounds().Min, draw.Src)
+	res, err := Compare(p1, p2, &CompareOptions{
+		SSIM:   true,
+		MSSSIM: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ch := 0; ch < 4; ch++ {
+		if res.MSE[ch] != 0 {
+			t.Fatalf("unexpected MSE for channel %d: got %f, want 0", ch, res.MSE[ch])
+		}
+		if !math.IsInf(res.PSNR[ch], 1) {
+			t.Fatalf("unexpected PSNR for channel %d: got %f, want +Inf", ch, res.PSNR[ch])
+		}
+		if res.MaxDiff[ch] != 0 {
+			t.Fatalf("unexpected max diff for channel %d: got %d, want 0", ch, res.MaxDiff[ch])
+		}
+		if math.Abs(res.SSIM[ch]-1) > 1e-9 {
+			t.Fatalf("unexpected SSIM for channel %d: got %f, want 1", ch, res.SSIM[ch])
+		}
+		if math.Abs(res.MSSSIM[ch]-1) > 1e-9 {
+			t.Fatalf("unexpected MS-SSIM for channel %d: got %f, want 1", ch, res.MSSSIM[ch])
+		}
+	}
+	if res.DiffPixels != 0 {
+		t.Fatalf("unexpected diff pixels: got %d, want 0", res.DiffPixels)
+	}
+}
+
+func TestCompareDifferent(t *testing.T) {
+	r := image.Rect(0, 0, 20, 10)
+	p1 := image.NewRGBA64(r)
+	p2 := image.NewRGBA64(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p1.SetRGBA64(x, y, color.RGBA64{0x1000, 0x2000, 0x3000, 0xffff})
+			c := color.RGBA64{0x1000, 0x2000, 0x3000, 0xffff}
+			if x < 5 {
+				c.R += 0x100
+				c.B -= 0x20
+			}
+			p2.SetRGBA64(x, y, c)
+		}
+	}
+	diff := image.NewRGBA(image.Rect(3, 3, 23, 13))
+	res, err := Compare(p1, p2, &CompareOptions{
+		Threshold: 0x40,
+		Diff:      diff,
+		Parallel:  &Parallel{TileHeight: 3, MinTileSize: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedMSE := [4]float64{
+		math.Pow(float64(0x100)/0xffff, 2) / 4,
+		0,
+		math.Pow(float64(0x20)/0xffff, 2) / 4,
+		0,
+	}
+	for ch := range expectedMSE {
+		if math.Abs(res.MSE[ch]-expectedMSE[ch]) > 1e-15 {
+			t.Fatalf("unexpected MSE for channel %d: got %g, want %g", ch, res.MSE[ch], expectedMSE[ch])
+		}
+	}
+	if expected := 10 * math.Log10(1/expectedMSE[0]); math.Abs(res.PSNR[0]-expected) > 1e-9 {
+		t.Fatalf("unexpected PSNR: got %f, want %f", res.PSNR[0], expected)
+	}
+	if expected := [4]uint32{0x100, 0, 0x20, 0}; res.MaxDiff != expected {
+		t.Fatalf("unexpected max diff: got %v, want %v", res.MaxDiff, expected)
+	}
+	if res.DiffPixels != 50 {
+		t.Fatalf("unexpected diff pixels: got %d, want %d", res.DiffPixels, 50)
+	}
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	if c := diff.RGBAAt(3, 3); c != red {
+		t.Fatalf("unexpected diff color: got %v, want %v", c, red)
+	}
+	if c := diff.RGBAAt(8, 3); c == red || c.A != 0xff || c.R != c.G || c.R != c.B || c.R < 0xe0 {
+		t.Fatalf("unexpected diff color: got %v, want light gray", c)
+	}
+}
+
+func TestCompareSSIM(t *testing.T) {
+	p1 := testNewRandomImage(image.Rect(0, 0, 64, 64))
+	blurred := image.NewRGBA64(p1.Bounds())
+	GaussianBlur(blurred, p1, 1, nil)
+	blurredMore := image.NewRGBA64(p1.Bounds())
+	GaussianBlur(blurredMore, p1, 3, nil)
+	opts := &CompareOptions{
+		SSIM:   true,
+		MSSSIM: true,
+	}
+	res1, err := Compare(p1, blurred, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2, err := Compare(p1, blurredMore, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res3, err := Compare(blurred, p1, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ch := 0; ch < 3; ch++ {
+		if !(res1.SSIM[ch] < 1 && res2.SSIM[ch] < res1.SSIM[ch]) {
+			t.Fatalf("unexpected SSIM for channel %d: got %f and %f", ch, res1.SSIM[ch], res2.SSIM[ch])
+		}
+		if !(res1.MSSSIM[ch] < 1 && res2.MSSSIM[ch] < res1.MSSSIM[ch]) {
+			t.Fatalf("unexpected MS-SSIM for channel %d: got %f and %f", ch, res1.MSSSIM[ch], res2.MSSSIM[ch])
+		}
+		if math.Abs(res1.SSIM[ch]-res3.SSIM[ch]) > 1e-9 {
+			t.Fatalf("SSIM is not symmetric for channel %d: got %f and %f", ch, res1.SSIM[ch], res3.SSIM[ch])
+		}
+	}
+}
+
+func TestCompareSSIMParallel(t *testing.T) {
+	p1 := testNewRandomImage(image.Rect(0, 0, 50, 40))
+	p2 := testNewRandomImage(image.Rect(0, 0, 50, 40))
+	res1, err := Compare(p1, p2, &CompareOptions{SSIM: true, MSSSIM: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2, err := Compare(p1, p2, &CompareOptions{SSIM: true, MSSSIM: true, Parallel: &Parallel{TileHeight: 3, MinTileSize: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ch := 0; ch < 4; ch++ {
+		if math.Abs(res1.SSIM[ch]-res2.SSIM[ch]) > 1e-9 || math.Abs(res1.MSSSIM[ch]-res2.MSSSIM[ch]) > 1e-9 {
+			t.Fatalf("unexpected result for channel %d: got %v, want %v", ch, res2, res1)
+		}
+	}
+}
+
+func TestCompareSmall(t *testing.T) {
+	p1 := testNewRandomImage(image.Rect(0, 0, 3, 2))
+	res, err := Compare(p1, p1, &CompareOptions{SSIM: true, MSSSIM: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(res.SSIM[0]-1) > 1e-9 || math.Abs(res.MSSSIM[0]-1) > 1e-9 {
+		t.Fatalf("unexpected result: %v", res)
+	}
+	p2 := image.NewRGBA(image.Rectangle{})
+	res, err = Compare(p2, p2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ch, v := range res.PSNR {
+		if !math.IsInf(v, 1) {
+			t.Fatalf("unexpected PSNR for channel %d: got %v, want +Inf", ch, v)
+		}
+	}
+}
+
+func TestCompareErrorBoundsMismatch(t *testing.T) {
+	p1 := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	p2 := image.NewRGBA(image.Rect(0, 0, 10, 11))
+	_, err := Compare(p1, p2, nil)
+	if !errors.Is(err, ErrBoundsMismatch) {
+		t.Fatalf("unexpected error: got %v, want %v", err, ErrBoundsMismatch)
+	}
+	_, err = Compare(p1, p1, &CompareOptions{Diff: p2})
+	if !errors.Is(err, ErrBoundsMismatch) {
+		t.Fatalf("unexpected error: got %v, want %v", err, ErrBoundsMismatch)
+	}
+}
+
+func BenchmarkCompare(b *testing.B) {
+	p1 := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	p2 := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	for _, tc := range []struct {
+		name string
+		opts *CompareOptions
+	}{
+		{"Default", nil},
+		{"SSIM", &CompareOptions{SSIM: true}},
+		{"MSSSIM", &CompareOptions{MSSSIM: true}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := Compare(p1, p2, tc.opts)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
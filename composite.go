@@ -0,0 +1,318 @@
+package imageutil
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// CompositeOp is a Porter-Duff compositing operator.
+//
+// The source is composited with the destination (the backdrop).
+type CompositeOp int
+
+const (
+	// CompositeOver places the source over the destination.
+	CompositeOver CompositeOp = iota
+	// CompositeClear clears the destination.
+	CompositeClear
+	// CompositeSrc replaces the destination with the source.
+	CompositeSrc
+	// CompositeDst keeps the destination.
+	CompositeDst
+	// CompositeDstOver places the destination over the source.
+	CompositeDstOver
+	// CompositeIn keeps the source where the destination is.
+	CompositeIn
+	// CompositeDstIn keeps the destination where the source is.
+	CompositeDstIn
+	// CompositeOut keeps the source where the destination isn't.
+	CompositeOut
+	// CompositeDstOut keeps the destination where the source isn't.
+	CompositeDstOut
+	// CompositeAtop places the source over the destination, only where the destination is.
+	CompositeAtop
+	// CompositeDstAtop places the destination over the source, only where the source is.
+	CompositeDstAtop
+	// CompositeXor keeps the source and the destination where they don't overlap.
+	CompositeXor
+	// CompositePlus adds the source and the destination.
+	CompositePlus
+)
+
+// BlendMode is a blend mode.
+//
+// It defines the color where the source and the destination overlap, before compositing.
+// The formulas are defined by the W3C Compositing and Blending specification.
+type BlendMode int
+
+const (
+	// BlendNormal uses the source color.
+	BlendNormal BlendMode = iota
+	// BlendMultiply multiplies the colors.
+	BlendMultiply
+	// BlendScreen multiplies the complements of the colors.
+	BlendScreen
+	// BlendOverlay multiplies or screens the colors, depending on the destination color.
+	BlendOverlay
+	// BlendDarken selects the darker color.
+	BlendDarken
+	// BlendLighten selects the lighter color.
+	BlendLighten
+	// BlendColorDodge brightens the destination color to reflect the source color.
+	BlendColorDodge
+	// BlendColorBurn darkens the destination color to reflect the source color.
+	BlendColorBurn
+	// BlendHardLight multiplies or screens the colors, depending on the source color.
+	BlendHardLight
+	// BlendSoftLight darkens or lightens the colors, depending on the source color.
+	BlendSoftLight
+	// BlendDifference subtracts the darker color from the lighter color.
+	BlendDifference
+	// BlendExclusion is like BlendDifference, but with a lower contrast.
+	BlendExclusion
+	// BlendHue uses the hue of the source color, and the saturation and luminosity of the destination color.
+	BlendHue
+	// BlendSaturation uses the saturation of the source color, and the hue and luminosity of the destination color.
+	BlendSaturation
+	// BlendColor uses the hue and saturation of the source color, and the luminosity of the destination color.
+	BlendColor
+	// BlendLuminosity uses the luminosity of the source color, and the hue and saturation of the destination color.
+	BlendLuminosity
+)
+
+// CompositeOptions are the options of Composite.
+type CompositeOptions struct {
+	// Op is the compositing operator.
+	Op CompositeOp
+	// Blend is the blend mode.
+	Blend BlendMode
+	// Mask is an optional mask: the source alpha is multiplied by the mask alpha.
+	Mask image.Image
+	// MaskPoint is the point of the mask aligned with r.Min.
+	MaskPoint image.Point
+	// Parallel is used to process the image concurrently.
+	Parallel *Parallel
+}
+
+// Composite composites src with dst, in the Rectangle r of dst.
+//
+// sp is the point of src aligned with r.Min.
+// The rectangle is clipped to the bounds of dst, src and the mask, like draw.DrawMask.
+// The source alpha is multiplied by opacity (in [0, 1]) and by the mask alpha.
+// The values are read with NewAtFunc and written with NewSetFunc.
+// The tiles are processed concurrently, and each pixel of dst is read then written in place,
+// so src and the mask must not share pixels with dst (e.g. the same image or overlapping SubImages),
+// unless each pixel is read at its own position (src is dst and sp is r.Min).
+// If opts is nil, the default options are used (CompositeOver and BlendNormal).
+func Composite(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point, opacity float64, opts *CompositeOptions) {
+	if opts == nil {
+		opts = new(CompositeOptions)
+	}
+	mp := opts.MaskPoint
+	orig := r.Min
+	r = r.Intersect(dst.Bounds())
+	r = r.Intersect(src.Bounds().Add(orig.Sub(sp)))
+	if opts.Mask != nil {
+		r = r.Intersect(opts.Mask.Bounds().Add(orig.Sub(mp)))
+	}
+	if r.Empty() {
+		return
+	}
+	sp = sp.Add(r.Min.Sub(orig))
+	mp = mp.Add(r.Min.Sub(orig))
+	dstAt := NewAtFunc(dst)
+	set := NewSetFunc(dst)
+	srcAt := NewAtFunc(src)
+	var maskAt AtFunc
+	if opts.Mask != nil {
+		maskAt = NewAtFunc(opts.Mask)
+	}
+	blend := compositeBlendFuncs[opts.Blend]
+	factors, ok := compositeFactors[opts.Op]
+	if !ok {
+		factors = compositeFactors[CompositeOver]
+	}
+	opacity = clampFloat(opacity)
+	opts.Parallel.Run(r, func(rr image.Rectangle) {
+		for y := rr.Min.Y; y < rr.Max.Y; y++ {
+			for x := rr.Min.X; x < rr.Max.X; x++ {
+				m := opacity
+				if maskAt != nil {
+					_, _, _, ma := maskAt(mp.X+x-r.Min.X, mp.Y+y-r.Min.Y)
+					m *= float64(ma) / 0xffff
+				}
+				sr, sg, sb, sa := srcAt(sp.X+x-r.Min.X, sp.Y+y-r.Min.Y)
+				s := [4]float64{float64(sr) * m / 0xffff, float64(sg) * m / 0xffff, float64(sb) * m / 0xffff, float64(sa) * m / 0xffff}
+				dr, dg, db, da := dstAt(x, y)
+				d := [4]float64{float64(dr) / 0xffff, float64(dg) / 0xffff, float64(db) / 0xffff, float64(da) / 0xffff}
+				c := compositePixel(s, d, factors, blend)
+				a := floatToUint16(c[3])
+				set(x, y, minUint32(floatToUint16(c[0]), a), minUint32(floatToUint16(c[1]), a), minUint32(floatToUint16(c[2]), a), a)
+			}
+		}
+	})
+}
+
+// compositePixel composites the premultiplied colors s (source) and d (destination).
+func compositePixel(s, d [4]float64, factors func(as, ab float64) (fa, fb float64), blend func(cs, cb [3]float64) [3]float64) [4]float64 {
+	as, ab := s[3], d[3]
+	if blend != nil && as > 0 && ab > 0 {
+		// The source color is replaced by the blended color where the source and the destination overlap:
+		// cs' = as * ((1 - ab) * Cs + ab * B(Cb, Cs)).
+		cs := [3]float64{s[0] / as, s[1] / as, s[2] / as}
+		cb := [3]float64{d[0] / ab, d[1] / ab, d[2] / ab}
+		b := blend(cs, cb)
+		for ch := 0; ch < 3; ch++ {
+			s[ch] = (1-ab)*s[ch] + as*ab*clampFloat(b[ch])
+		}
+	}
+	fa, fb := factors(as, ab)
+	var c [4]float64
+	for ch := range c {
+		c[ch] = fa*s[ch] + fb*d[ch]
+	}
+	return c
+}
+
+var compositeFactors = map[CompositeOp]func(as, ab float64) (fa, fb float64){
+	CompositeClear:   func(as, ab float64) (float64, float64) { return 0, 0 },
+	CompositeSrc:     func(as, ab float64) (float64, float64) { return 1, 0 },
+	CompositeDst:     func(as, ab float64) (float64, float64) { return 0, 1 },
+	CompositeOver:    func(as, ab float64) (float64, float64) { return 1, 1 - as },
+	CompositeDstOver: func(as, ab float64) (float64, float64) { return 1 - ab, 1 },
+	CompositeIn:      func(as, ab float64) (float64, float64) { return ab, 0 },
+	CompositeDstIn:   func(as, ab float64) (float64, float64) { return 0, as },
+	CompositeOut:     func(as, ab float64) (float64, float64) { return 1 - ab, 0 },
+	CompositeDstOut:  func(as, ab float64) (float64, float64) { return 0, 1 - as },
+	CompositeAtop:    func(as, ab float64) (float64, float64) { return ab, 1 - as },
+	CompositeDstAtop: func(as, ab float64) (float64, float64) { return 1 - ab, as },
+	CompositeXor:     func(as, ab float64) (float64, float64) { return 1 - ab, 1 - as },
+	CompositePlus:    func(as, ab float64) (float64, float64) { return 1, 1 },
+}
+
+// compositeBlendFuncs contains the blend functions, for non-premultiplied source (cs) and destination (cb) colors.
+// BlendNormal doesn't have a function.
+var compositeBlendFuncs = map[BlendMode]func(cs, cb [3]float64) [3]float64{
+	BlendMultiply:   separableBlend(func(cs, cb float64) float64 { return cs * cb }),
+	BlendScreen:     separableBlend(blendScreen),
+	BlendOverlay:    separableBlend(func(cs, cb float64) float64 { return blendHardLight(cb, cs) }),
+	BlendDarken:     separableBlend(math.Min),
+	BlendLighten:    separableBlend(math.Max),
+	BlendColorDodge: separableBlend(blendColorDodge),
+	BlendColorBurn:  separableBlend(blendColorBurn),
+	BlendHardLight:  separableBlend(blendHardLight),
+	BlendSoftLight:  separableBlend(blendSoftLight),
+	BlendDifference: separableBlend(func(cs, cb float64) float64 { return math.Abs(cs - cb) }),
+	BlendExclusion:  separableBlend(func(cs, cb float64) float64 { return cs + cb - 2*cs*cb }),
+	BlendHue: func(cs, cb [3]float64) [3]float64 {
+		return blendSetLum(blendSetSat(cs, blendSat(cb)), blendLum(cb))
+	},
+	BlendSaturation: func(cs, cb [3]float64) [3]float64 {
+		return blendSetLum(blendSetSat(cb, blendSat(cs)), blendLum(cb))
+	},
+	BlendColor: func(cs, cb [3]float64) [3]float64 {
+		return blendSetLum(cs, blendLum(cb))
+	},
+	BlendLuminosity: func(cs, cb [3]float64) [3]float64 {
+		return blendSetLum(cb, blendLum(cs))
+	},
+}
+
+func separableBlend(f func(cs, cb float64) float64) func(cs, cb [3]float64) [3]float64 {
+	return func(cs, cb [3]float64) [3]float64 {
+		return [3]float64{f(cs[0], cb[0]), f(cs[1], cb[1]), f(cs[2], cb[2])}
+	}
+}
+
+func blendScreen(cs, cb float64) float64 {
+	return cs + cb - cs*cb
+}
+
+func blendHardLight(cs, cb float64) float64 {
+	if cs <= 0.5 {
+		return cb * 2 * cs
+	}
+	return blendScreen(2*cs-1, cb)
+}
+
+func blendColorDodge(cs, cb float64) float64 {
+	if cb == 0 {
+		return 0
+	}
+	if cs >= 1 {
+		return 1
+	}
+	return math.Min(1, cb/(1-cs))
+}
+
+func blendColorBurn(cs, cb float64) float64 {
+	if cb >= 1 {
+		return 1
+	}
+	if cs <= 0 {
+		return 0
+	}
+	return 1 - math.Min(1, (1-cb)/cs)
+}
+
+func blendSoftLight(cs, cb float64) float64 {
+	if cs <= 0.5 {
+		return cb - (1-2*cs)*cb*(1-cb)
+	}
+	var d float64
+	if cb <= 0.25 {
+		d = ((16*cb-12)*cb + 4) * cb
+	} else {
+		d = math.Sqrt(cb)
+	}
+	return cb + (2*cs-1)*(d-cb)
+}
+
+func blendLum(c [3]float64) float64 {
+	return 0.3*c[0] + 0.59*c[1] + 0.11*c[2]
+}
+
+func blendClipColor(c [3]float64) [3]float64 {
+	l := blendLum(c)
+	n := math.Min(c[0], math.Min(c[1], c[2]))
+	x := math.Max(c[0], math.Max(c[1], c[2]))
+	for ch := range c {
+		if n < 0 {
+			c[ch] = l + (c[ch]-l)*l/(l-n)
+		}
+		if x > 1 {
+			c[ch] = l + (c[ch]-l)*(1-l)/(x-l)
+		}
+	}
+	return c
+}
+
+func blendSetLum(c [3]float64, l float64) [3]float64 {
+	d := l - blendLum(c)
+	return blendClipColor([3]float64{c[0] + d, c[1] + d, c[2] + d})
+}
+
+func blendSat(c [3]float64) float64 {
+	return math.Max(c[0], math.Max(c[1], c[2])) - math.Min(c[0], math.Min(c[1], c[2]))
+}
+
+func blendSetSat(c [3]float64, s float64) [3]float64 {
+	// The indices of the minimum, middle and maximum components.
+	imin, imid, imax := 0, 1, 2
+	if c[imin] > c[imid] {
+		imin, imid = imid, imin
+	}
+	if c[imid] > c[imax] {
+		imid, imax = imax, imid
+	}
+	if c[imin] > c[imid] {
+		imin, imid = imid, imin
+	}
+	var r [3]float64
+	if c[imax] > c[imin] {
+		r[imid] = (c[imid] - c[imin]) * s / (c[imax] - c[imin])
+		r[imax] = s
+	}
+	return r
+}
@@ -0,0 +1,218 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestCompositeDraw(t *testing.T) {
+	src := testNewRandomImage(image.Rect(-3, 2, 12, 15))
+	mask := testNewRandomImage(image.Rect(0, 0, 20, 20))
+	for _, tc := range []struct {
+		name string
+		op   CompositeOp
+		dop  draw.Op
+		mask image.Image
+	}{
+		{"Over", CompositeOver, draw.Over, nil},
+		{"Src", CompositeSrc, draw.Src, nil},
+		{"OverMask", CompositeOver, draw.Over, mask},
+		{"SrcMask", CompositeSrc, draw.Src, mask},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := image.NewRGBA64(image.Rect(0, 0, 10, 10))
+			draw.Draw(dst, dst.Bounds(), testNewRandomImage(dst.Bounds()), image.Point{}, draw.Src)
+			expected := image.NewRGBA64(dst.Bounds())
+			copy(expected.Pix, dst.Pix)
+			r := image.Rect(2, 1, 20, 9)
+			sp := image.Pt(-1, 4)
+			mp := image.Pt(3, 5)
+			draw.DrawMask(expected, r, src, sp, tc.mask, mp, tc.dop)
+			Composite(dst, r, src, sp, 1, &CompositeOptions{
+				Op:        tc.op,
+				Mask:      tc.mask,
+				MaskPoint: mp,
+				Parallel:  &Parallel{TileHeight: 3, MinTileSize: 1},
+			})
+			testCompositeEqual(t, dst, expected, 2)
+		})
+	}
+}
+
+func TestCompositeOpacity(t *testing.T) {
+	src := testNewRandomImage(image.Rect(0, 0, 8, 8))
+	dst := image.NewRGBA64(src.Bounds())
+	draw.Draw(dst, dst.Bounds(), testNewRandomImage(dst.Bounds()), image.Point{}, draw.Src)
+	expected := image.NewRGBA64(dst.Bounds())
+	copy(expected.Pix, dst.Pix)
+	draw.DrawMask(expected, expected.Bounds(), src, image.Point{}, image.NewUniform(color.Alpha16{0x8000}), image.Point{}, draw.Over)
+	Composite(dst, dst.Bounds(), src, image.Point{}, float64(0x8000)/0xffff, nil)
+	testCompositeEqual(t, dst, expected, 2)
+}
+
+func TestCompositeOps(t *testing.T) {
+	// The source is half transparent red, the destination is a quarter transparent blue.
+	s := color.RGBA64{0x8000, 0, 0, 0x8000}
+	d := color.RGBA64{0, 0, 0xc000, 0xc000}
+	as, ab := float64(0x8000)/0xffff, float64(0xc000)/0xffff
+	for _, tc := range []struct {
+		op     CompositeOp
+		fa, fb float64
+	}{
+		{CompositeClear, 0, 0},
+		{CompositeSrc, 1, 0},
+		{CompositeDst, 0, 1},
+		{CompositeOver, 1, 1 - as},
+		{CompositeDstOver, 1 - ab, 1},
+		{CompositeIn, ab, 0},
+		{CompositeDstIn, 0, as},
+		{CompositeOut, 1 - ab, 0},
+		{CompositeDstOut, 0, 1 - as},
+		{CompositeAtop, ab, 1 - as},
+		{CompositeDstAtop, 1 - ab, as},
+		{CompositeXor, 1 - ab, 1 - as},
+		{CompositePlus, 1, 1},
+	} {
+		t.Run(fmt.Sprint(tc.op), func(t *testing.T) {
+			dst := image.NewRGBA64(image.Rect(0, 0, 1, 1))
+			dst.SetRGBA64(0, 0, d)
+			Composite(dst, dst.Bounds(), image.NewUniform(s), image.Point{}, 1, &CompositeOptions{Op: tc.op})
+			expected := [4]float64{
+				tc.fa * float64(s.R),
+				0,
+				tc.fb * float64(d.B),
+				math.Min(tc.fa*float64(s.A)+tc.fb*float64(d.A), 0xffff),
+			}
+			c := dst.RGBA64At(0, 0)
+			for i, v := range [4]uint16{c.R, c.G, c.B, c.A} {
+				if math.Abs(float64(v)-expected[i]) > 1 {
+					t.Fatalf("unexpected value for channel %d: got %d, want %f", i, v, expected[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompositeBlend(t *testing.T) {
+	gray := func(v float64) color.Color {
+		return color.NRGBA64{uint16(v * 0xffff), uint16(v * 0xffff), uint16(v * 0xffff), 0xffff}
+	}
+	for _, tc := range []struct {
+		name     string
+		mode     BlendMode
+		src, dst color.Color
+		expected float64
+	}{
+		{"Normal", BlendNormal, gray(0.2), gray(0.6), 0.2},
+		{"Multiply", BlendMultiply, gray(0.5), gray(0.6), 0.3},
+		{"Screen", BlendScreen, gray(0.5), gray(0.6), 0.8},
+		{"OverlayDark", BlendOverlay, gray(0.5), gray(0.25), 0.25},
+		{"OverlayLight", BlendOverlay, gray(0.5), gray(0.75), 0.75},
+		{"Darken", BlendDarken, gray(0.2), gray(0.6), 0.2},
+		{"Lighten", BlendLighten, gray(0.2), gray(0.6), 0.6},
+		{"ColorDodge", BlendColorDodge, gray(0.5), gray(0.25), 0.5},
+		{"ColorBurn", BlendColorBurn, gray(0.5), gray(0.75), 0.5},
+		{"HardLight", BlendHardLight, gray(0.25), gray(0.5), 0.25},
+		{"SoftLightDark", BlendSoftLight, gray(0.5), gray(0.3), 0.3},
+		{"SoftLightLight", BlendSoftLight, gray(1), gray(0.25), 0.5},
+		{"Difference", BlendDifference, gray(0.2), gray(0.6), 0.4},
+		{"Exclusion", BlendExclusion, gray(0.5), gray(0.6), 0.5},
+		{"Luminosity", BlendLuminosity, gray(0.4), gray(0.8), 0.4},
+		{"Color", BlendColor, gray(0.4), gray(0.8), 0.8},
+		{"Hue", BlendHue, gray(0.4), gray(0.8), 0.8},
+		{"Saturation", BlendSaturation, gray(0.4), gray(0.8), 0.8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dst := image.NewRGBA64(image.Rect(0, 0, 1, 1))
+			dst.Set(0, 0, tc.dst)
+			Composite(dst, dst.Bounds(), image.NewUniform(tc.src), image.Point{}, 1, &CompositeOptions{Blend: tc.mode})
+			c := dst.RGBA64At(0, 0)
+			for i, v := range [3]uint16{c.R, c.G, c.B} {
+				if math.Abs(float64(v)/0xffff-tc.expected) > 0.001 {
+					t.Fatalf("unexpected value for channel %d: got %f, want %f", i, float64(v)/0xffff, tc.expected)
+				}
+			}
+			if c.A != 0xffff {
+				t.Fatalf("unexpected alpha: got %d, want %d", c.A, 0xffff)
+			}
+		})
+	}
+}
+
+func TestCompositeBlendNonSeparable(t *testing.T) {
+	src := color.NRGBA{0xff, 0x20, 0x20, 0xff}
+	dst := color.NRGBA{0x40, 0x80, 0xc0, 0xff}
+	cs := [3]float64{float64(src.R) / 0xff, float64(src.G) / 0xff, float64(src.B) / 0xff}
+	cb := [3]float64{float64(dst.R) / 0xff, float64(dst.G) / 0xff, float64(dst.B) / 0xff}
+	for _, tc := range []struct {
+		mode  BlendMode
+		check func(c [3]float64) bool
+	}{
+		{BlendLuminosity, func(c [3]float64) bool {
+			return math.Abs(blendLum(c)-blendLum(cs)) < 0.002
+		}},
+		{BlendColor, func(c [3]float64) bool {
+			return math.Abs(blendLum(c)-blendLum(cb)) < 0.002
+		}},
+		{BlendSaturation, func(c [3]float64) bool {
+			return math.Abs(blendLum(c)-blendLum(cb)) < 0.002 && math.Abs(blendSat(c)-blendSat(cs)) < 0.002
+		}},
+		{BlendHue, func(c [3]float64) bool {
+			// The red source has the highest red component.
+			return math.Abs(blendLum(c)-blendLum(cb)) < 0.002 && c[0] > c[1] && c[0] > c[2]
+		}},
+	} {
+		t.Run(fmt.Sprint(tc.mode), func(t *testing.T) {
+			p := image.NewRGBA64(image.Rect(0, 0, 1, 1))
+			p.Set(0, 0, dst)
+			Composite(p, p.Bounds(), image.NewUniform(src), image.Point{}, 1, &CompositeOptions{Blend: tc.mode})
+			v := p.RGBA64At(0, 0)
+			c := [3]float64{float64(v.R) / 0xffff, float64(v.G) / 0xffff, float64(v.B) / 0xffff}
+			if !tc.check(c) {
+				t.Fatalf("unexpected color: %v", c)
+			}
+		})
+	}
+}
+
+func TestCompositeEmpty(t *testing.T) {
+	dst := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	src := testNewRandomImage(image.Rect(0, 0, 10, 10))
+	Composite(dst, image.Rect(20, 20, 30, 30), src, image.Point{}, 1, nil)
+	for _, v := range dst.Pix {
+		if v != 0 {
+			t.Fatal("dst was modified")
+		}
+	}
+}
+
+func testCompositeEqual(tb testing.TB, p1, p2 *image.RGBA64, delta uint32) {
+	tb.Helper()
+	at1, at2 := NewAtFunc(p1), NewAtFunc(p2)
+	bd := p1.Bounds()
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			r1, g1, b1, a1 := at1(x, y)
+			r2, g2, b2, a2 := at2(x, y)
+			if testAbsDiff(r1, r2) > delta || testAbsDiff(g1, g2) > delta || testAbsDiff(b1, b2) > delta || testAbsDiff(a1, a2) > delta {
+				tb.Fatalf("unexpected color at (%d, %d): got %v, want %v", x, y, []uint32{r1, g1, b1, a1}, []uint32{r2, g2, b2, a2})
+			}
+		}
+	}
+}
+
+func BenchmarkComposite(b *testing.B) {
+	src := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	for _, mode := range []BlendMode{BlendNormal, BlendMultiply, BlendHue} {
+		b.Run(fmt.Sprint(mode), func(b *testing.B) {
+			dst := image.NewRGBA(src.Bounds())
+			for i := 0; i < b.N; i++ {
+				Composite(dst, dst.Bounds(), src, image.Point{}, 1, &CompositeOptions{Blend: mode})
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package imageutil
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Convert converts src to dst.
+//
+// It processes the intersection of the bounds of dst and src, concurrently with Parallel1D.
+// The result is identical to calling the SetFunc of dst with the values returned by the AtFunc of src for each pixel.
+// It uses a specialized implementation for the most common pairs of types, or RowReader/RowWriter otherwise.
+func Convert(dst draw.Image, src image.Image) {
+	r := dst.Bounds().Intersect(src.Bounds())
+	if r.Empty() {
+		return
+	}
+	Parallel1D(r, newConvertFunc(dst, src))
+}
+
+// newConvertFunc returns a function that converts the pixels of a Rectangle.
+//
+// nolint: gocyclo
+func newConvertFunc(dst draw.Image, src image.Image) func(image.Rectangle) {
+	switch dst := dst.(type) {
+	case *image.RGBA:
+		switch src := src.(type) {
+		case *image.RGBA:
+			return newConvertFuncCopy(dst.Pix, dst.Stride, dst.Rect.Min, src.Pix, src.Stride, src.Rect.Min, 4)
+		case *image.NRGBA:
+			return newConvertFuncNRGBAToRGBA(dst, src)
+		case *image.Gray:
+			return newConvertFuncGrayToRGBA(dst.Pix, dst.Stride, dst.Rect.Min, src)
+		case *image.YCbCr:
+			return newConvertFuncYCbCrToRGBA(dst.Pix, dst.Stride, dst.Rect.Min, src)
+		case *image.Paletted:
+			return newConvertFuncPalettedToRGBA(dst.Pix, dst.Stride, dst.Rect.Min, src, false)
+		}
+	case *image.NRGBA:
+		switch src := src.(type) {
+		case *image.RGBA:
+			return newConvertFuncRGBAToNRGBA(dst, src)
+		case *image.Gray:
+			return newConvertFuncGrayToRGBA(dst.Pix, dst.Stride, dst.Rect.Min, src)
+		case *image.YCbCr:
+			return newConvertFuncYCbCrToRGBA(dst.Pix, dst.Stride, dst.Rect.Min, src)
+		case *image.Paletted:
+			return newConvertFuncPalettedToRGBA(dst.Pix, dst.Stride, dst.Rect.Min, src, true)
+		}
+	case *image.RGBA64:
+		if src, ok := src.(*image.RGBA64); ok {
+			return newConvertFuncCopy(dst.Pix, dst.Stride, dst.Rect.Min, src.Pix, src.Stride, src.Rect.Min, 8)
+		}
+	case *image.Gray:
+		if src, ok := src.(*image.Gray); ok {
+			return newConvertFuncCopy(dst.Pix, dst.Stride, dst.Rect.Min, src.Pix, src.Stride, src.Rect.Min, 1)
+		}
+	case *image.Gray16:
+		if src, ok := src.(*image.Gray16); ok {
+			return newConvertFuncCopy(dst.Pix, dst.Stride, dst.Rect.Min, src.Pix, src.Stride, src.Rect.Min, 2)
+		}
+	case *image.Alpha:
+		if src, ok := src.(*image.Alpha); ok {
+			return newConvertFuncCopy(dst.Pix, dst.Stride, dst.Rect.Min, src.Pix, src.Stride, src.Rect.Min, 1)
+		}
+	case *image.Alpha16:
+		if src, ok := src.(*image.Alpha16); ok {
+			return newConvertFuncCopy(dst.Pix, dst.Stride, dst.Rect.Min, src.Pix, src.Stride, src.Rect.Min, 2)
+		}
+	}
+	return newConvertFuncRow(dst, src)
+}
+
+// newConvertFuncCopy returns a function that copies the pixels between 2 images of the same lossless type.
+func newConvertFuncCopy(dstPix []uint8, dstStride int, dstMin image.Point, srcPix []uint8, srcStride int, srcMin image.Point, bpp int) func(image.Rectangle) {
+	return func(r image.Rectangle) {
+		n := r.Dx() * bpp
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			di := (y-dstMin.Y)*dstStride + (r.Min.X-dstMin.X)*bpp
+			si := (y-srcMin.Y)*srcStride + (r.Min.X-srcMin.X)*bpp
+			copy(dstPix[di:di+n], srcPix[si:si+n])
+		}
+	}
+}
+
+func newConvertFuncNRGBAToRGBA(dst *image.RGBA, src *image.NRGBA) func(image.Rectangle) {
+	return func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			di := (y-dst.Rect.Min.Y)*dst.Stride + (r.Min.X-dst.Rect.Min.X)*4
+			si := (y-src.Rect.Min.Y)*src.Stride + (r.Min.X-src.Rect.Min.X)*4
+			d := dst.Pix[di : di+r.Dx()*4]
+			s := src.Pix[si : si+r.Dx()*4]
+			for i := 0; i < len(s); i += 4 {
+				ss := s[i : i+4]
+				dd := d[i : i+4]
+				switch ss[3] {
+				case 0:
+					dd[0], dd[1], dd[2], dd[3] = 0, 0, 0, 0
+				case 0xff:
+					dd[0], dd[1], dd[2], dd[3] = ss[0], ss[1], ss[2], 0xff
+				default:
+					a := uint32(ss[3]) * 0x101
+					dd[0] = uint8(uint32(ss[0]) * 0x101 * a / 0xffff >> 8)
+					dd[1] = uint8(uint32(ss[1]) * 0x101 * a / 0xffff >> 8)
+					dd[2] = uint8(uint32(ss[2]) * 0x101 * a / 0xffff >> 8)
+					dd[3] = ss[3]
+				}
+			}
+		}
+	}
+}
+
+func newConvertFuncRGBAToNRGBA(dst *image.NRGBA, src *image.RGBA) func(image.Rectangle) {
+	return func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			di := (y-dst.Rect.Min.Y)*dst.Stride + (r.Min.X-dst.Rect.Min.X)*4
+			si := (y-src.Rect.Min.Y)*src.Stride + (r.Min.X-src.Rect.Min.X)*4
+			d := dst.Pix[di : di+r.Dx()*4]
+			s := src.Pix[si : si+r.Dx()*4]
+			for i := 0; i < len(s); i += 4 {
+				ss := s[i : i+4]
+				dd := d[i : i+4]
+				cr, cg, cb, ca := RGBAToNRGBA(uint32(ss[0])*0x101, uint32(ss[1])*0x101, uint32(ss[2])*0x101, uint32(ss[3])*0x101)
+				dd[0] = uint8(cr >> 8)
+				dd[1] = uint8(cg >> 8)
+				dd[2] = uint8(cb >> 8)
+				dd[3] = uint8(ca >> 8)
+			}
+		}
+	}
+}
+
+// newConvertFuncGrayToRGBA returns a function that converts a Gray image to a RGBA or NRGBA image (they are identical for opaque colors).
+func newConvertFuncGrayToRGBA(dstPix []uint8, dstStride int, dstMin image.Point, src *image.Gray) func(image.Rectangle) {
+	return func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			di := (y-dstMin.Y)*dstStride + (r.Min.X-dstMin.X)*4
+			si := (y-src.Rect.Min.Y)*src.Stride + (r.Min.X - src.Rect.Min.X)
+			d := dstPix[di : di+r.Dx()*4]
+			s := src.Pix[si : si+r.Dx()]
+			for i, v := range s {
+				dd := d[i*4 : i*4+4]
+				dd[0], dd[1], dd[2], dd[3] = v, v, v, 0xff
+			}
+		}
+	}
+}
+
+// newConvertFuncYCbCrToRGBA returns a function that converts a YCbCr image to a RGBA or NRGBA image (they are identical for opaque colors).
+func newConvertFuncYCbCrToRGBA(dstPix []uint8, dstStride int, dstMin image.Point, src *image.YCbCr) func(image.Rectangle) {
+	sx, sy := yCbCrSubsampleFactors(src.SubsampleRatio)
+	return func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			di := (y-dstMin.Y)*dstStride + (r.Min.X-dstMin.X)*4
+			yi := (y-src.Rect.Min.Y)*src.YStride + (r.Min.X - src.Rect.Min.X)
+			cy := (y/sy - src.Rect.Min.Y/sy) * src.CStride
+			d := dstPix[di : di+r.Dx()*4]
+			ys := src.Y[yi : yi+r.Dx()]
+			for i, yy := range ys {
+				ci := cy + ((r.Min.X+i)/sx - src.Rect.Min.X/sx)
+				cr, cg, cb := yCbCrToRGB(yy, src.Cb[ci], src.Cr[ci])
+				dd := d[i*4 : i*4+4]
+				dd[0], dd[1], dd[2], dd[3] = uint8(cr>>8), uint8(cg>>8), uint8(cb>>8), 0xff
+			}
+		}
+	}
+}
+
+// newConvertFuncPalettedToRGBA returns a function that converts a Paletted image to a RGBA or NRGBA image.
+//
+// The palette is converted once to the destination format.
+func newConvertFuncPalettedToRGBA(dstPix []uint8, dstStride int, dstMin image.Point, src *image.Paletted, nrgba bool) func(image.Rectangle) {
+	pa := newPaletteRGBA(src.Palette)
+	table := make([][4]uint8, len(pa))
+	for i, c := range pa {
+		cr, cg, cb, ca := c.r, c.g, c.b, c.a
+		if nrgba {
+			cr, cg, cb, ca = RGBAToNRGBA(cr, cg, cb, ca)
+		}
+		table[i] = [4]uint8{uint8(cr >> 8), uint8(cg >> 8), uint8(cb >> 8), uint8(ca >> 8)}
+	}
+	return func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			di := (y-dstMin.Y)*dstStride + (r.Min.X-dstMin.X)*4
+			si := (y-src.Rect.Min.Y)*src.Stride + (r.Min.X - src.Rect.Min.X)
+			d := dstPix[di : di+r.Dx()*4]
+			s := src.Pix[si : si+r.Dx()]
+			for i, v := range s {
+				c := table[v]
+				copy(d[i*4:i*4+4], c[:])
+			}
+		}
+	}
+}
+
+// newConvertFuncRow returns a function that converts the pixels row by row, with a RowReader and a RowWriter.
+func newConvertFuncRow(dst draw.Image, src image.Image) func(image.Rectangle) {
+	read := NewRowReader(src)
+	write := NewRowWriter(dst)
+	return func(r image.Rectangle) {
+		buf := make([]uint32, r.Dx()*4)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			read(r.Min.X, y, buf)
+			write(r.Min.X, y, buf)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestConvert(t *testing.T) {
+	bd := image.Rect(-3, -1, 40, 30)
+	for _, newImageFunc := range testImageFuncs {
+		src := newImageFunc(bd)
+		set := newSimpleSetFunc(src)
+		for y := bd.Min.Y; y < bd.Max.Y; y++ {
+			for x := bd.Min.X; x < bd.Max.X; x++ {
+				set(x, y, testColors[rand.Intn(len(testColors))])
+			}
+		}
+		for _, newImageDrawFunc := range testDrawImageFuncs {
+			dst1 := newImageDrawFunc(bd)
+			dst2 := newImageDrawFunc(bd)
+			t.Run(fmt.Sprintf("%T/%T", src, dst1), func(t *testing.T) {
+				Convert(dst1, src)
+				at := NewAtFunc(src)
+				set := NewSetFunc(dst2)
+				for y := bd.Min.Y; y < bd.Max.Y; y++ {
+					for x := bd.Min.X; x < bd.Max.X; x++ {
+						r, g, b, a := at(x, y)
+						set(x, y, r, g, b, a)
+					}
+				}
+				for y := bd.Min.Y; y < bd.Max.Y; y++ {
+					for x := bd.Min.X; x < bd.Max.X; x++ {
+						r1, g1, b1, a1 := dst1.At(x, y).RGBA()
+						r2, g2, b2, a2 := dst2.At(x, y).RGBA()
+						if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+							t.Fatalf("different color: pixel %dx%d: got {%d %d %d %d}, want {%d %d %d %d}", x, y, r1, g1, b1, a1, r2, g2, b2, a2)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkConvert(b *testing.B) {
+	bd := image.Rect(0, 0, 256, 256)
+	for _, tc := range []struct {
+		name string
+		src  image.Image
+		dst  draw.Image
+	}{
+		{"YCbCrToRGBA", image.NewYCbCr(bd, image.YCbCrSubsampleRatio420), image.NewRGBA(bd)},
+		{"NRGBAToRGBA", image.NewNRGBA(bd), image.NewRGBA(bd)},
+		{"PalettedToNRGBA", image.NewPaletted(bd, testPalette), image.NewNRGBA(bd)},
+		{"CMYKToGray", image.NewCMYK(bd), image.NewGray(bd)},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Convert(tc.dst, tc.src)
+			}
+		})
+	}
+}
@@ -0,0 +1,332 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// EdgeMode defines how the pixels outside of the bounds of an image are read.
+type EdgeMode int
+
+const (
+	// EdgeClamp repeats the pixels of the edges.
+	EdgeClamp EdgeMode = iota
+	// EdgeWrap wraps around the image, as if it was tiled.
+	EdgeWrap
+	// EdgeMirror mirrors the image at the edges, without repeating the pixels of the edges.
+	EdgeMirror
+	// EdgeTransparent reads transparent pixels.
+	EdgeTransparent
+)
+
+// edgeCoord returns the coordinate inside [min, max[ used to read the coordinate v.
+// It returns false if the pixel is transparent.
+func edgeCoord(v, min, max int, m EdgeMode) (int, bool) {
+	if v >= min && v < max {
+		return v, true
+	}
+	n := max - min
+	switch m {
+	case EdgeWrap:
+		v = (v - min) % n
+		if v < 0 {
+			v += n
+		}
+		return min + v, true
+	case EdgeMirror:
+		if n == 1 {
+			return min, true
+		}
+		period := 2 * (n - 1)
+		v = (v - min) % period
+		if v < 0 {
+			v += period
+		}
+		if v >= n {
+			v = period - v
+		}
+		return min + v, true
+	case EdgeTransparent:
+		return 0, false
+	default:
+		if v < min {
+			return min, true
+		}
+		return max - 1, true
+	}
+}
+
+// Kernel is a 2D convolution kernel.
+//
+// The center of the kernel is at (Width/2, Height/2).
+type Kernel struct {
+	Width, Height int
+	// Values holds the values of the kernel, in row-major order.
+	Values []float64
+}
+
+// ConvolveOptions are the options of the convolution functions.
+type ConvolveOptions struct {
+	// Edge defines how the pixels outside of the bounds of the source image are read.
+	Edge EdgeMode
+	// Parallel is used to process the image concurrently.
+	Parallel *Parallel
+}
+
+// Convolve applies a kernel to src and writes the result to dst.
+//
+// It processes the intersection of the bounds of dst and src.
+// The kernel is applied as a correlation: it is not flipped.
+// The values are read with NewAtFuncFloat, and the computation is done on alpha-premultiplied values.
+// The resulting alpha is clipped to [0, 1], and the color values are clipped to [0, alpha], except for RGBA128F destination images which keep the high dynamic range color values.
+// Each tile reads the pixels around it (the halo) from src, so dst and src must not share pixels.
+// If the width or the height of the kernel is lower than or equal to 0, it does nothing.
+// It panics if the kernel doesn't have Width*Height values.
+// If opts is nil, the default options are used.
+func Convolve(dst draw.Image, src image.Image, k *Kernel, opts *ConvolveOptions) {
+	if k.Width <= 0 || k.Height <= 0 {
+		return
+	}
+	if len(k.Values) != k.Width*k.Height {
+		panic(fmt.Sprintf("imageutil: kernel has %d values, want %d (%dx%d)", len(k.Values), k.Width*k.Height, k.Width, k.Height))
+	}
+	if opts == nil {
+		opts = new(ConvolveOptions)
+	}
+	r := dst.Bounds().Intersect(src.Bounds())
+	if r.Empty() {
+		return
+	}
+	ws := make([]float32, len(k.Values))
+	for i, v := range k.Values {
+		ws[i] = float32(v)
+	}
+	cx, cy := k.Width/2, k.Height/2
+	load := newConvolveLoader(src, opts.Edge)
+	store := newSetFuncFloatClip(dst)
+	opts.Parallel.Run(r, func(r image.Rectangle) {
+		halo := image.Rect(r.Min.X-cx, r.Min.Y-cy, r.Max.X+k.Width-1-cx, r.Max.Y+k.Height-1-cy)
+		buf := load(halo)
+		w := halo.Dx() * 4
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				var sum [4]float32
+				for ky := 0; ky < k.Height; ky++ {
+					row := buf[(y-r.Min.Y+ky)*w+(x-r.Min.X)*4:]
+					for kx, kv := range ws[ky*k.Width : (ky+1)*k.Width] {
+						s := row[kx*4 : kx*4+4]
+						sum[0] += s[0] * kv
+						sum[1] += s[1] * kv
+						sum[2] += s[2] * kv
+						sum[3] += s[3] * kv
+					}
+				}
+				store(x, y, sum)
+			}
+		}
+	})
+}
+
+// ConvolveSeparable applies a separable kernel to src and writes the result to dst.
+//
+// It is equivalent to Convolve with the kernel defined by the outer product of ky and kx, but it's much faster.
+// The center of kx (resp. ky) is at len(kx)/2 (resp. len(ky)/2).
+// The horizontal pass is done first, in a buffer local to each tile, then the vertical pass.
+//
+// See Convolve.
+func ConvolveSeparable(dst draw.Image, src image.Image, kx, ky []float64, opts *ConvolveOptions) {
+	if opts == nil {
+		opts = new(ConvolveOptions)
+	}
+	r := dst.Bounds().Intersect(src.Bounds())
+	if r.Empty() || len(kx) == 0 || len(ky) == 0 {
+		return
+	}
+	wx := make([]float32, len(kx))
+	for i, v := range kx {
+		wx[i] = float32(v)
+	}
+	wy := make([]float32, len(ky))
+	for i, v := range ky {
+		wy[i] = float32(v)
+	}
+	cx, cy := len(kx)/2, len(ky)/2
+	load := newConvolveLoader(src, opts.Edge)
+	store := newSetFuncFloatClip(dst)
+	opts.Parallel.Run(r, func(r image.Rectangle) {
+		halo := image.Rect(r.Min.X-cx, r.Min.Y-cy, r.Max.X+len(kx)-1-cx, r.Max.Y+len(ky)-1-cy)
+		buf := load(halo)
+		// The horizontal pass, for all the rows of the halo.
+		w := r.Dx() * 4
+		tmp := make([]float32, halo.Dy()*w)
+		for y := 0; y < halo.Dy(); y++ {
+			src := buf[y*halo.Dx()*4:]
+			dst := tmp[y*w : (y+1)*w]
+			for i := 0; i < w; i += 4 {
+				var sum [4]float32
+				for kx, kv := range wx {
+					s := src[i+kx*4 : i+kx*4+4]
+					sum[0] += s[0] * kv
+					sum[1] += s[1] * kv
+					sum[2] += s[2] * kv
+					sum[3] += s[3] * kv
+				}
+				copy(dst[i:i+4], sum[:])
+			}
+		}
+		// The vertical pass.
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				i := (y-r.Min.Y)*w + (x-r.Min.X)*4
+				var sum [4]float32
+				for ky, kv := range wy {
+					s := tmp[i+ky*w : i+ky*w+4]
+					sum[0] += s[0] * kv
+					sum[1] += s[1] * kv
+					sum[2] += s[2] * kv
+					sum[3] += s[3] * kv
+				}
+				store(x, y, sum)
+			}
+		}
+	})
+}
+
+// GaussianBlur applies a Gaussian blur to src and writes the result to dst.
+//
+// See GaussianKernel and ConvolveSeparable.
+func GaussianBlur(dst draw.Image, src image.Image, sigma float64, opts *ConvolveOptions) {
+	k := GaussianKernel(sigma)
+	ConvolveSeparable(dst, src, k, k, opts)
+}
+
+// BoxBlur applies a box blur to src and writes the result to dst.
+//
+// See BoxKernel and ConvolveSeparable.
+func BoxBlur(dst draw.Image, src image.Image, radius int, opts *ConvolveOptions) {
+	k := BoxKernel(radius)
+	ConvolveSeparable(dst, src, k, k, opts)
+}
+
+// BoxKernel returns a normalized 1D box kernel of size 2*radius+1.
+func BoxKernel(radius int) []float64 {
+	if radius < 0 {
+		radius = 0
+	}
+	k := make([]float64, 2*radius+1)
+	for i := range k {
+		k[i] = 1 / float64(len(k))
+	}
+	return k
+}
+
+// GaussianKernel returns a normalized 1D Gaussian kernel.
+//
+// Its radius is ceil(3*sigma).
+// If sigma is lower than or equal to 0, it returns the identity kernel.
+func GaussianKernel(sigma float64) []float64 {
+	if sigma <= 0 {
+		return []float64{1}
+	}
+	radius := int(math.Ceil(3 * sigma))
+	k := make([]float64, 2*radius+1)
+	for i := range k {
+		x := float64(i - radius)
+		k[i] = math.Exp(-x * x / (2 * sigma * sigma))
+	}
+	return normalizeKernel(k)
+}
+
+// LanczosKernel returns a normalized 1D Lanczos-windowed sinc kernel.
+//
+// a is the number of lobes, and scale is the width of a lobe in pixels.
+// It is a low-pass filter whose cutoff frequency is 1/scale.
+// If scale is lower than or equal to 1, it returns the identity kernel.
+func LanczosKernel(a int, scale float64) []float64 {
+	if a <= 0 || scale <= 1 {
+		return []float64{1}
+	}
+	radius := int(math.Ceil(float64(a)*scale)) - 1
+	k := make([]float64, 2*radius+1)
+	for i := range k {
+		k[i] = lanczos(float64(i-radius)/scale, float64(a))
+	}
+	return normalizeKernel(k)
+}
+
+// lanczos returns the value of the Lanczos kernel with a lobes at x.
+func lanczos(x, a float64) float64 {
+	if x <= -a || x >= a {
+		return 0
+	}
+	return sinc(x) * sinc(x/a)
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	x *= math.Pi
+	return math.Sin(x) / x
+}
+
+func normalizeKernel(k []float64) []float64 {
+	var sum float64
+	for _, v := range k {
+		sum += v
+	}
+	if sum != 0 {
+		for i := range k {
+			k[i] /= sum
+		}
+	}
+	return k
+}
+
+// newConvolveLoader returns a function that reads the pixels of a Rectangle, which can be outside of the bounds of the image.
+//
+// The returned buffer contains 4 values per pixel, in row-major order.
+func newConvolveLoader(p image.Image, m EdgeMode) func(r image.Rectangle) []float32 {
+	at := NewAtFuncFloat(p)
+	bd := p.Bounds()
+	return func(r image.Rectangle) []float32 {
+		buf := make([]float32, r.Dx()*r.Dy()*4)
+		// The horizontal coordinates are resolved once for all the rows.
+		xs := make([]int, r.Dx())
+		xok := make([]bool, r.Dx())
+		for i := range xs {
+			xs[i], xok[i] = edgeCoord(r.Min.X+i, bd.Min.X, bd.Max.X, m)
+		}
+		i := 0
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			sy, yok := edgeCoord(y, bd.Min.Y, bd.Max.Y, m)
+			for j, sx := range xs {
+				if yok && xok[j] {
+					s := buf[i : i+4]
+					s[0], s[1], s[2], s[3] = at(sx, sy)
+				}
+				i += 4
+			}
+		}
+		return buf
+	}
+}
+
+// newSetFuncFloatClip returns a function that writes a pixel, clipping its values to a valid premultiplied color.
+func newSetFuncFloatClip(p draw.Image) func(x, y int, c [4]float32) {
+	set := NewSetFuncFloat(p)
+	_, hdr := p.(*RGBA128F)
+	return func(x, y int, c [4]float32) {
+		a := clampFloat32(c[3])
+		for ch := 0; ch < 3; ch++ {
+			if c[ch] < 0 {
+				c[ch] = 0
+			} else if !hdr && c[ch] > a {
+				c[ch] = a
+			}
+		}
+		set(x, y, c[0], c[1], c[2], a)
+	}
+}
@@ -0,0 +1,217 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"testing"
+)
+
+var testEdgeModes = []EdgeMode{
+	EdgeClamp,
+	EdgeWrap,
+	EdgeMirror,
+	EdgeTransparent,
+}
+
+func TestEdgeCoord(t *testing.T) {
+	for _, tc := range []struct {
+		mode EdgeMode
+		want []int
+	}{
+		{EdgeClamp, []int{2, 2, 2, 2, 2, 3, 4, 5, 5, 5}},
+		{EdgeWrap, []int{2, 3, 4, 5, 2, 3, 4, 5, 2, 3}},
+		{EdgeMirror, []int{4, 5, 4, 3, 2, 3, 4, 5, 4, 3}},
+		{EdgeTransparent, []int{-1, -1, -1, -1, 2, 3, 4, 5, -1, -1}},
+	} {
+		for i, want := range tc.want {
+			v := i - 2
+			got, ok := edgeCoord(v, 2, 6, tc.mode)
+			if !ok {
+				got = -1
+			}
+			if got != want {
+				t.Fatalf("unexpected coordinate: mode %d, %d: got %d, want %d", tc.mode, v, got, want)
+			}
+		}
+	}
+}
+
+func TestConvolve(t *testing.T) {
+	src := testNewRandomImage(image.Rect(-3, 2, 14, 11))
+	k := &Kernel{
+		Width:  3,
+		Height: 2,
+		Values: []float64{
+			0.1, 0.2, 0.05,
+			0.3, 0.15, 0.2,
+		},
+	}
+	for _, m := range testEdgeModes {
+		t.Run(fmt.Sprint(m), func(t *testing.T) {
+			dst := image.NewRGBA64(src.Rect)
+			Convolve(dst, src, k, &ConvolveOptions{
+				Edge:     m,
+				Parallel: &Parallel{TileWidth: 4, TileHeight: 3, MinTileSize: 1},
+			})
+			testConvolveReference(t, dst, src, k, m)
+		})
+	}
+}
+
+func TestConvolveInvalidKernel(t *testing.T) {
+	p := testNewRandomImage(image.Rect(0, 0, 10, 10))
+	dst := image.NewNRGBA(p.Bounds())
+	// The empty kernels do nothing.
+	Convolve(dst, p, &Kernel{Width: 0, Height: 3}, nil)
+	Convolve(dst, p, &Kernel{Width: -1, Height: -1, Values: []float64{1}}, nil)
+	defer func() {
+		if recover() == nil {
+			t.Fatal("no panic")
+		}
+	}()
+	Convolve(dst, p, &Kernel{Width: 3, Height: 3, Values: []float64{1, 2, 3}}, nil)
+}
+
+func TestConvolveSeparable(t *testing.T) {
+	src := testNewRandomImage(image.Rect(-3, 2, 14, 11))
+	kx := GaussianKernel(1.2)
+	ky := BoxKernel(1)
+	k := &Kernel{Width: len(kx), Height: len(ky)}
+	for _, vy := range ky {
+		for _, vx := range kx {
+			k.Values = append(k.Values, vx*vy)
+		}
+	}
+	for _, m := range testEdgeModes {
+		t.Run(fmt.Sprint(m), func(t *testing.T) {
+			dst := image.NewRGBA64(src.Rect)
+			ConvolveSeparable(dst, src, kx, ky, &ConvolveOptions{
+				Edge:     m,
+				Parallel: &Parallel{TileWidth: 4, TileHeight: 3, MinTileSize: 1},
+			})
+			testConvolveReference(t, dst, src, k, m)
+		})
+	}
+}
+
+// testConvolveReference compares the result of a convolution with a naive implementation.
+func testConvolveReference(t *testing.T, dst *image.RGBA64, src image.Image, k *Kernel, m EdgeMode) {
+	t.Helper()
+	at := NewAtFuncFloat(src)
+	bd := src.Bounds()
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			var sum [4]float64
+			for ky := 0; ky < k.Height; ky++ {
+				for kx := 0; kx < k.Width; kx++ {
+					sx, okx := edgeCoord(x+kx-k.Width/2, bd.Min.X, bd.Max.X, m)
+					sy, oky := edgeCoord(y+ky-k.Height/2, bd.Min.Y, bd.Max.Y, m)
+					if !okx || !oky {
+						continue
+					}
+					r, g, b, a := at(sx, sy)
+					v := k.Values[ky*k.Width+kx] * 0xffff
+					sum[0] += float64(r) * v
+					sum[1] += float64(g) * v
+					sum[2] += float64(b) * v
+					sum[3] += float64(a) * v
+				}
+			}
+			c := dst.RGBA64At(x, y)
+			got := [4]uint16{c.R, c.G, c.B, c.A}
+			for ch, v := range sum {
+				if math.Abs(float64(got[ch])-v) > 1 {
+					t.Fatalf("different value: pixel %dx%d, channel %d: got %d, want %f", x, y, ch, got[ch], v)
+				}
+			}
+		}
+	}
+}
+
+func TestConvolveClip(t *testing.T) {
+	src := testNewRandomImage(image.Rect(0, 0, 10, 10))
+	dst := image.NewRGBA(src.Rect)
+	sharpen := &Kernel{
+		Width:  3,
+		Height: 3,
+		Values: []float64{
+			0, -1, 0,
+			-1, 5, -1,
+			0, -1, 0,
+		},
+	}
+	Convolve(dst, src, sharpen, nil)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		s := dst.Pix[i : i+4]
+		if s[0] > s[3] || s[1] > s[3] || s[2] > s[3] {
+			t.Fatalf("invalid premultiplied color: %v", s)
+		}
+	}
+}
+
+func TestConvolveHighDynamicRange(t *testing.T) {
+	src := NewRGBA128F(image.Rect(0, 0, 3, 1))
+	set := NewSetFuncF(src)
+	set(0, 0, 3, 0, 0, 1)
+	set(1, 0, 0, 0, 0, 1)
+	set(2, 0, 0, 0, 0, 1)
+	dst := NewRGBA128F(src.Rect)
+	ConvolveSeparable(dst, src, BoxKernel(1), []float64{1}, &ConvolveOptions{Edge: EdgeTransparent})
+	r, _, _, a := NewAtFuncF(dst)(1, 0)
+	if r != 1 || a != 1 {
+		t.Fatalf("unexpected value: got {%f %f}, want {1 1}", r, a)
+	}
+}
+
+func TestKernels(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		k    []float64
+		size int
+	}{
+		{"Box", BoxKernel(2), 5},
+		{"Gaussian", GaussianKernel(1.5), 11},
+		{"GaussianZero", GaussianKernel(0), 1},
+		{"Lanczos", LanczosKernel(3, 2), 11},
+		{"LanczosIdentity", LanczosKernel(3, 1), 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if len(tc.k) != tc.size {
+				t.Fatalf("unexpected size: got %d, want %d", len(tc.k), tc.size)
+			}
+			var sum float64
+			for i, v := range tc.k {
+				sum += v
+				if math.Abs(v-tc.k[len(tc.k)-1-i]) > 1e-12 {
+					t.Fatalf("kernel is not symmetric: %v", tc.k)
+				}
+			}
+			if math.Abs(sum-1) > 1e-9 {
+				t.Fatalf("kernel is not normalized: sum %f", sum)
+			}
+		})
+	}
+}
+
+func BenchmarkGaussianBlur(b *testing.B) {
+	src := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	dst := image.NewRGBA(src.Rect)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GaussianBlur(dst, src, 2, nil)
+	}
+}
+
+func BenchmarkConvolve(b *testing.B) {
+	src := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	dst := image.NewRGBA(src.Rect)
+	k := &Kernel{Width: 5, Height: 5, Values: make([]float64, 25)}
+	for i := range k.Values {
+		k.Values[i] = 1.0 / 25
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Convolve(dst, src, k, nil)
+	}
+}
@@ -0,0 +1,184 @@
+package imageutil
+
+import (
+	"image"
+	"math"
+)
+
+// DitherMethod is a dithering method.
+type DitherMethod int
+
+const (
+	// DitherFloydSteinberg is the Floyd-Steinberg error diffusion.
+	DitherFloydSteinberg DitherMethod = iota
+	// DitherAtkinson is the Atkinson error diffusion.
+	// Only 3/4 of the error is diffused, which preserves the contrast.
+	DitherAtkinson
+	// DitherJarvisJudiceNinke is the Jarvis-Judice-Ninke error diffusion.
+	DitherJarvisJudiceNinke
+	// DitherSierra is the Sierra (3 rows) error diffusion.
+	DitherSierra
+	// DitherBayer is the ordered dithering with a 8x8 Bayer matrix.
+	DitherBayer
+)
+
+// Dither writes src to dst with dithering.
+//
+// It processes the intersection of the bounds of dst and src.
+// The error diffusion methods process the pixels in serpentine order (alternating left to right and right to left).
+// The ordered method processes the pixels concurrently with Parallel1D.
+// An unknown method is handled as DitherFloydSteinberg.
+func Dither(dst *image.Paletted, src image.Image, m DitherMethod) {
+	r := dst.Rect.Intersect(src.Bounds())
+	if r.Empty() || len(dst.Palette) == 0 {
+		return
+	}
+	pa := newPaletteRGBA(dst.Palette)
+	pi := newPaletteIndex(pa, 0)
+	at := NewAtFunc(src)
+	if m == DitherBayer {
+		ditherBayer(dst, r, at, pa, pi)
+		return
+	}
+	k, ok := ditherKernels[m]
+	if !ok {
+		k = ditherKernels[DitherFloydSteinberg]
+	}
+	ditherErrorDiffusion(dst, r, at, pa, pi, k)
+}
+
+type ditherKernel struct {
+	div     int32
+	weights []ditherWeight
+}
+
+type ditherWeight struct {
+	dx, dy int
+	w      int32
+}
+
+var ditherKernels = map[DitherMethod]ditherKernel{
+	DitherFloydSteinberg: {16, []ditherWeight{
+		{1, 0, 7},
+		{-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+	}},
+	DitherAtkinson: {8, []ditherWeight{
+		{1, 0, 1}, {2, 0, 1},
+		{-1, 1, 1}, {0, 1, 1}, {1, 1, 1},
+		{0, 2, 1},
+	}},
+	DitherJarvisJudiceNinke: {48, []ditherWeight{
+		{1, 0, 7}, {2, 0, 5},
+		{-2, 1, 3}, {-1, 1, 5}, {0, 1, 7}, {1, 1, 5}, {2, 1, 3},
+		{-2, 2, 1}, {-1, 2, 3}, {0, 2, 5}, {1, 2, 3}, {2, 2, 1},
+	}},
+	DitherSierra: {32, []ditherWeight{
+		{1, 0, 5}, {2, 0, 3},
+		{-2, 1, 2}, {-1, 1, 4}, {0, 1, 5}, {1, 1, 4}, {2, 1, 2},
+		{-1, 2, 2}, {0, 2, 3}, {1, 2, 2},
+	}},
+}
+
+// ditherErrorPadding is the number of pixels added on each side of the error rows, so the kernels can write outside of the image.
+const ditherErrorPadding = 2
+
+func ditherErrorDiffusion(dst *image.Paletted, r image.Rectangle, at AtFunc, pa paletteRGBA, pi *paletteIndex, k ditherKernel) {
+	w := r.Dx() + ditherErrorPadding*2
+	// The errors of the current row and the 2 next rows, 4 channels per pixel.
+	errs := [3][]int32{
+		make([]int32, w*4),
+		make([]int32, w*4),
+		make([]int32, w*4),
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		x0, x1, dir := r.Min.X, r.Max.X, 1
+		if (y-r.Min.Y)%2 == 1 {
+			x0, x1, dir = r.Max.X-1, r.Min.X-1, -1
+		}
+		for x := x0; x != x1; x += dir {
+			ei := (x - r.Min.X + ditherErrorPadding) * 4
+			var c [4]int32
+			c[0], c[1], c[2], c[3] = ditherAt(at, x, y)
+			c = ditherAddError(c, errs[0][ei:ei+4])
+			i := pi.index(colorRGBA{uint32(c[0]), uint32(c[1]), uint32(c[2]), uint32(c[3])})
+			dst.Pix[(y-dst.Rect.Min.Y)*dst.Stride+(x-dst.Rect.Min.X)] = uint8(i)
+			p := pa[i]
+			e := [4]int32{
+				c[0] - int32(p.r),
+				c[1] - int32(p.g),
+				c[2] - int32(p.b),
+				c[3] - int32(p.a),
+			}
+			for _, dw := range k.weights {
+				ej := ei + dw.dx*dir*4
+				row := errs[dw.dy]
+				for ch := range e {
+					row[ej+ch] += e[ch] * dw.w / k.div
+				}
+			}
+		}
+		errs[0], errs[1], errs[2] = errs[1], errs[2], errs[0]
+		for i := range errs[2] {
+			errs[2][i] = 0
+		}
+	}
+}
+
+// ditherAddError adds the diffused error e to the premultiplied color c.
+//
+// The alpha is clamped first, then the color is clamped to it, so the result is a valid premultiplied color.
+func ditherAddError(c [4]int32, e []int32) [4]int32 {
+	c[3] = clampInt32(c[3]+e[3], 0, 0xffff)
+	for ch := 0; ch < 3; ch++ {
+		c[ch] = clampInt32(c[ch]+e[ch], 0, c[3])
+	}
+	return c
+}
+
+func ditherAt(at AtFunc, x, y int) (r, g, b, a int32) {
+	r1, g1, b1, a1 := at(x, y)
+	return int32(r1), int32(g1), int32(b1), int32(a1)
+}
+
+// ditherBayerMatrix is the 8x8 Bayer threshold matrix.
+var ditherBayerMatrix = [8][8]int32{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+func ditherBayer(dst *image.Paletted, r image.Rectangle, at AtFunc, pa paletteRGBA, pi *paletteIndex) {
+	// The spread is the approximate distance between 2 palette colors on a channel.
+	levels := math.Max(2, math.Round(math.Cbrt(float64(len(pa)))))
+	spread := int32(0xffff / (levels - 1))
+	Parallel1D(r, func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				cr, cg, cb, ca := ditherAt(at, x, y)
+				// The threshold is in [-0.5, 0.5[, scaled by the alpha because the colors are premultiplied.
+				t := ditherBayerMatrix[y&7][x&7]
+				d := int32(int64(spread) * int64(t*2-63) / 128 * int64(ca) / 0xffff)
+				cr = clampInt32(cr+d, 0, ca)
+				cg = clampInt32(cg+d, 0, ca)
+				cb = clampInt32(cb+d, 0, ca)
+				i := pi.index(colorRGBA{uint32(cr), uint32(cg), uint32(cb), uint32(ca)})
+				dst.Pix[(y-dst.Rect.Min.Y)*dst.Stride+(x-dst.Rect.Min.X)] = uint8(i)
+			}
+		}
+	})
+}
+
+func clampInt32(v, min, max int32) int32 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,94 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+var testDitherMethods = []DitherMethod{
+	DitherFloydSteinberg,
+	DitherAtkinson,
+	DitherJarvisJudiceNinke,
+	DitherSierra,
+	DitherBayer,
+}
+
+func TestDitherExact(t *testing.T) {
+	bd := image.Rect(-3, -1, 20, 15)
+	src := image.NewRGBA(bd)
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			src.Set(x, y, testPalette[(x*7+y*3+100)%len(testPalette)])
+		}
+	}
+	for _, m := range testDitherMethods {
+		t.Run(fmt.Sprint(m), func(t *testing.T) {
+			dst := image.NewPaletted(bd, testPalette)
+			Dither(dst, src, m)
+			for y := bd.Min.Y; y < bd.Max.Y; y++ {
+				for x := bd.Min.X; x < bd.Max.X; x++ {
+					r1, g1, b1, a1 := dst.At(x, y).RGBA()
+					r2, g2, b2, a2 := src.At(x, y).RGBA()
+					if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+						t.Fatalf("different color: pixel %dx%d: got {%d %d %d %d}, want {%d %d %d %d}", x, y, r1, g1, b1, a1, r2, g2, b2, a2)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDitherAddError(t *testing.T) {
+	for _, tc := range []struct {
+		c, e, expected [4]int32
+	}{
+		{[4]int32{0x1000, 0x2000, 0x3000, 0x4000}, [4]int32{0x100, -0x100, 0, 0x100}, [4]int32{0x1100, 0x1f00, 0x3000, 0x4100}},
+		// The color is clamped to the alpha.
+		{[4]int32{0x1000, 0x1000, 0x1000, 0x1000}, [4]int32{0x2000, 0, 0, -0x800}, [4]int32{0x800, 0x800, 0x800, 0x800}},
+		{[4]int32{0, 0, 0, 0}, [4]int32{0x2000, 0x2000, -0x2000, 0x1000}, [4]int32{0x1000, 0x1000, 0, 0x1000}},
+		{[4]int32{0xffff, 0xffff, 0xffff, 0xffff}, [4]int32{0x2000, 0, 0, 0x2000}, [4]int32{0xffff, 0xffff, 0xffff, 0xffff}},
+	} {
+		c := ditherAddError(tc.c, tc.e[:])
+		if c != tc.expected {
+			t.Fatalf("unexpected color for %v + %v: got %v, want %v", tc.c, tc.e, c, tc.expected)
+		}
+	}
+}
+
+func TestDitherGradient(t *testing.T) {
+	bd := image.Rect(0, 0, 256, 64)
+	src := image.NewGray(bd)
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			src.SetGray(x, y, color.Gray{uint8(x)})
+		}
+	}
+	pl := color.Palette{color.Black, color.White}
+	for _, m := range testDitherMethods {
+		if m == DitherAtkinson {
+			// It doesn't diffuse all the error, so it loses the details in the shadows and highlights.
+			continue
+		}
+		t.Run(fmt.Sprint(m), func(t *testing.T) {
+			dst := image.NewPaletted(bd, pl)
+			Dither(dst, src, m)
+			// The average of each block of columns must be close to the source.
+			for x0 := bd.Min.X; x0 < bd.Max.X; x0 += 32 {
+				var sumSrc, sumDst float64
+				for y := bd.Min.Y; y < bd.Max.Y; y++ {
+					for x := x0; x < x0+32; x++ {
+						sumSrc += float64(src.GrayAt(x, y).Y)
+						sumDst += float64(dst.Pix[dst.PixOffset(x, y)] * 0xff)
+					}
+				}
+				n := float64(32 * bd.Dy())
+				if d := math.Abs(sumSrc-sumDst) / n; d > 0xff*0.08 {
+					t.Fatalf("columns %d: average difference too large: %f", x0, d)
+				}
+			}
+		})
+	}
+}
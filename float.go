@@ -0,0 +1,404 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// NewAtFuncFloat returns an AtFuncF for an Image.
+//
+// The values are in [0, 1].
+// For RGBA128F images, it returns NewAtFuncF, and the values are not clipped.
+//
+// nolint: gocyclo
+func NewAtFuncFloat(p image.Image) AtFuncF {
+	switch p := p.(type) {
+	case *image.RGBA:
+		return newAtFuncFloatRGBA(p)
+	case *image.RGBA64:
+		return newAtFuncFloatRGBA64(p)
+	case *image.NRGBA:
+		return newAtFuncFloatNRGBA(p)
+	case *image.NRGBA64:
+		return newAtFuncFloatNRGBA64(p)
+	case *image.Alpha:
+		return newAtFuncFloatAlpha(p)
+	case *image.Alpha16:
+		return newAtFuncFloatAlpha16(p)
+	case *image.Gray:
+		return newAtFuncFloatGray(p)
+	case *image.Gray16:
+		return newAtFuncFloatGray16(p)
+	case *image.Paletted:
+		return newAtFuncFloatPaletted(p)
+	case *image.YCbCr:
+		return newAtFuncFloatYCbCr(p)
+	case *image.NYCbCrA:
+		return newAtFuncFloatNYCbCrA(p)
+	case *image.CMYK:
+		return newAtFuncFloatCMYK(p)
+	case *image.Uniform:
+		return newAtFuncFloatUniform(p)
+	case *RGBA128F:
+		return NewAtFuncF(p)
+	default:
+		return newAtFuncFloatDefault(p)
+	}
+}
+
+func newAtFuncFloatRGBA(p *image.RGBA) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		return float32(s[0]) / 0xff, float32(s[1]) / 0xff, float32(s[2]) / 0xff, float32(s[3]) / 0xff
+	}
+}
+
+func newAtFuncFloatRGBA64(p *image.RGBA64) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*8
+		s := p.Pix[i : i+8]
+		r = float32(uint16(s[0])<<8|uint16(s[1])) / 0xffff
+		g = float32(uint16(s[2])<<8|uint16(s[3])) / 0xffff
+		b = float32(uint16(s[4])<<8|uint16(s[5])) / 0xffff
+		a = float32(uint16(s[6])<<8|uint16(s[7])) / 0xffff
+		return
+	}
+}
+
+func newAtFuncFloatNRGBA(p *image.NRGBA) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		if s[3] == 0 {
+			return
+		}
+		a = float32(s[3]) / 0xff
+		r = float32(s[0]) / 0xff * a
+		g = float32(s[1]) / 0xff * a
+		b = float32(s[2]) / 0xff * a
+		return
+	}
+}
+
+func newAtFuncFloatNRGBA64(p *image.NRGBA64) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*8
+		s := p.Pix[i : i+8]
+		a16 := uint16(s[6])<<8 | uint16(s[7])
+		if a16 == 0 {
+			return
+		}
+		a = float32(a16) / 0xffff
+		r = float32(uint16(s[0])<<8|uint16(s[1])) / 0xffff * a
+		g = float32(uint16(s[2])<<8|uint16(s[3])) / 0xffff * a
+		b = float32(uint16(s[4])<<8|uint16(s[5])) / 0xffff * a
+		return
+	}
+}
+
+func newAtFuncFloatAlpha(p *image.Alpha) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		a = float32(p.Pix[i]) / 0xff
+		return a, a, a, a
+	}
+}
+
+func newAtFuncFloatAlpha16(p *image.Alpha16) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+		s := p.Pix[i : i+2]
+		a = float32(uint16(s[0])<<8|uint16(s[1])) / 0xffff
+		return a, a, a, a
+	}
+}
+
+func newAtFuncFloatGray(p *image.Gray) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		yy := float32(p.Pix[i]) / 0xff
+		return yy, yy, yy, 1
+	}
+}
+
+func newAtFuncFloatGray16(p *image.Gray16) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+		s := p.Pix[i : i+2]
+		yy := float32(uint16(s[0])<<8|uint16(s[1])) / 0xffff
+		return yy, yy, yy, 1
+	}
+}
+
+func newAtFuncFloatPaletted(p *image.Paletted) AtFuncF {
+	pa := newPaletteRGBA(p.Palette)
+	table := make([][4]float32, len(pa))
+	for i, c := range pa {
+		table[i] = [4]float32{float32(c.r) / 0xffff, float32(c.g) / 0xffff, float32(c.b) / 0xffff, float32(c.a) / 0xffff}
+	}
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		c := table[p.Pix[i]]
+		return c[0], c[1], c[2], c[3]
+	}
+}
+
+func newAtFuncFloatYCbCr(p *image.YCbCr) AtFuncF {
+	sx, sy := yCbCrSubsampleFactors(p.SubsampleRatio)
+	return func(x, y int) (r, g, b, a float32) {
+		yi := (y-p.Rect.Min.Y)*p.YStride + (x - p.Rect.Min.X)
+		ci := (y/sy-p.Rect.Min.Y/sy)*p.CStride + (x/sx - p.Rect.Min.X/sx)
+		cr, cg, cb := yCbCrToRGB(p.Y[yi], p.Cb[ci], p.Cr[ci])
+		return float32(cr) / 0xffff, float32(cg) / 0xffff, float32(cb) / 0xffff, 1
+	}
+}
+
+func newAtFuncFloatNYCbCrA(p *image.NYCbCrA) AtFuncF {
+	sx, sy := yCbCrSubsampleFactors(p.SubsampleRatio)
+	return func(x, y int) (r, g, b, a float32) {
+		ai := (y-p.Rect.Min.Y)*p.AStride + (x - p.Rect.Min.X)
+		if p.A[ai] == 0 {
+			return
+		}
+		a = float32(p.A[ai]) / 0xff
+		yi := (y-p.Rect.Min.Y)*p.YStride + (x - p.Rect.Min.X)
+		ci := (y/sy-p.Rect.Min.Y/sy)*p.CStride + (x/sx - p.Rect.Min.X/sx)
+		cr, cg, cb := yCbCrToRGB(p.Y[yi], p.Cb[ci], p.Cr[ci])
+		return float32(cr) / 0xffff * a, float32(cg) / 0xffff * a, float32(cb) / 0xffff * a, a
+	}
+}
+
+func newAtFuncFloatCMYK(p *image.CMYK) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		w := 1 - float32(s[3])/0xff
+		r = (1 - float32(s[0])/0xff) * w
+		g = (1 - float32(s[1])/0xff) * w
+		b = (1 - float32(s[2])/0xff) * w
+		a = 1
+		return
+	}
+}
+
+func newAtFuncFloatUniform(p *image.Uniform) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		r1, g1, b1, a1 := p.C.RGBA()
+		return float32(r1) / 0xffff, float32(g1) / 0xffff, float32(b1) / 0xffff, float32(a1) / 0xffff
+	}
+}
+
+func newAtFuncFloatDefault(p image.Image) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		r1, g1, b1, a1 := p.At(x, y).RGBA()
+		return float32(r1) / 0xffff, float32(g1) / 0xffff, float32(b1) / 0xffff, float32(a1) / 0xffff
+	}
+}
+
+// NewSetFuncFloat returns a SetFuncF for an Image.
+//
+// The values are clipped to [0, 1], and rounded to the precision of the image.
+// For RGBA128F images, it returns NewSetFuncF, and the values are not clipped.
+//
+// nolint: gocyclo
+func NewSetFuncFloat(p draw.Image) SetFuncF {
+	switch p := p.(type) {
+	case *image.RGBA:
+		return newSetFuncFloatRGBA(p)
+	case *image.RGBA64:
+		return newSetFuncFloatRGBA64(p)
+	case *image.NRGBA:
+		return newSetFuncFloatNRGBA(p)
+	case *image.NRGBA64:
+		return newSetFuncFloatNRGBA64(p)
+	case *image.Alpha:
+		return newSetFuncFloatAlpha(p)
+	case *image.Alpha16:
+		return newSetFuncFloatAlpha16(p)
+	case *image.Gray:
+		return newSetFuncFloatGray(p)
+	case *image.Gray16:
+		return newSetFuncFloatGray16(p)
+	case *image.Paletted:
+		return newSetFuncFloatPaletted(p)
+	case *image.CMYK:
+		return newSetFuncFloatCMYK(p)
+	case *RGBA128F:
+		return NewSetFuncF(p)
+	default:
+		return newSetFuncFloatDefault(p)
+	}
+}
+
+func newSetFuncFloatRGBA(p *image.RGBA) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		s[0] = float32ToUint8(r)
+		s[1] = float32ToUint8(g)
+		s[2] = float32ToUint8(b)
+		s[3] = float32ToUint8(a)
+	}
+}
+
+func newSetFuncFloatRGBA64(p *image.RGBA64) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*8
+		s := p.Pix[i : i+8]
+		r16, g16, b16, a16 := float32ToUint16(r), float32ToUint16(g), float32ToUint16(b), float32ToUint16(a)
+		s[0] = uint8(r16 >> 8)
+		s[1] = uint8(r16)
+		s[2] = uint8(g16 >> 8)
+		s[3] = uint8(g16)
+		s[4] = uint8(b16 >> 8)
+		s[5] = uint8(b16)
+		s[6] = uint8(a16 >> 8)
+		s[7] = uint8(a16)
+	}
+}
+
+func newSetFuncFloatNRGBA(p *image.NRGBA) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		r, g, b = unpremultiplyFloat32(r, g, b, a)
+		s[0] = float32ToUint8(r)
+		s[1] = float32ToUint8(g)
+		s[2] = float32ToUint8(b)
+		s[3] = float32ToUint8(a)
+	}
+}
+
+func newSetFuncFloatNRGBA64(p *image.NRGBA64) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*8
+		s := p.Pix[i : i+8]
+		r, g, b = unpremultiplyFloat32(r, g, b, a)
+		r16, g16, b16, a16 := float32ToUint16(r), float32ToUint16(g), float32ToUint16(b), float32ToUint16(a)
+		s[0] = uint8(r16 >> 8)
+		s[1] = uint8(r16)
+		s[2] = uint8(g16 >> 8)
+		s[3] = uint8(g16)
+		s[4] = uint8(b16 >> 8)
+		s[5] = uint8(b16)
+		s[6] = uint8(a16 >> 8)
+		s[7] = uint8(a16)
+	}
+}
+
+func newSetFuncFloatAlpha(p *image.Alpha) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		p.Pix[i] = float32ToUint8(a)
+	}
+}
+
+func newSetFuncFloatAlpha16(p *image.Alpha16) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+		s := p.Pix[i : i+2]
+		a16 := float32ToUint16(a)
+		s[0] = uint8(a16 >> 8)
+		s[1] = uint8(a16)
+	}
+}
+
+func newSetFuncFloatGray(p *image.Gray) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		p.Pix[i] = float32ToUint8(luminanceFloat32(r, g, b))
+	}
+}
+
+func newSetFuncFloatGray16(p *image.Gray16) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+		s := p.Pix[i : i+2]
+		y16 := float32ToUint16(luminanceFloat32(r, g, b))
+		s[0] = uint8(y16 >> 8)
+		s[1] = uint8(y16)
+	}
+}
+
+func newSetFuncFloatPaletted(p *image.Paletted) SetFuncF {
+	pi := newPaletteIndex(newPaletteRGBA(p.Palette), 0)
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		p.Pix[i] = uint8(pi.index(colorRGBA{float32ToUint16(r), float32ToUint16(g), float32ToUint16(b), float32ToUint16(a)}))
+	}
+}
+
+func newSetFuncFloatCMYK(p *image.CMYK) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		r, g, b = clampFloat32(r), clampFloat32(g), clampFloat32(b)
+		w := r
+		if w < g {
+			w = g
+		}
+		if w < b {
+			w = b
+		}
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		// The key is quantized first, so the other values compensate its rounding.
+		k8 := float32ToUint8(1 - w)
+		w = 1 - float32(k8)/0xff
+		if w == 0 {
+			s[0], s[1], s[2], s[3] = 0, 0, 0, 0xff
+			return
+		}
+		s[0] = float32ToUint8((w - r) / w)
+		s[1] = float32ToUint8((w - g) / w)
+		s[2] = float32ToUint8((w - b) / w)
+		s[3] = k8
+	}
+}
+
+func newSetFuncFloatDefault(p draw.Image) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		p.Set(x, y, color.RGBA64{
+			R: uint16(float32ToUint16(r)),
+			G: uint16(float32ToUint16(g)),
+			B: uint16(float32ToUint16(b)),
+			A: uint16(float32ToUint16(a)),
+		})
+	}
+}
+
+func float32ToUint8(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 0xff
+	}
+	return uint8(v*0xff + 0.5)
+}
+
+func clampFloat32(v float32) float32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
+
+// unpremultiplyFloat32 returns the non-alpha-premultiplied color values.
+func unpremultiplyFloat32(r, g, b, a float32) (float32, float32, float32) {
+	if a <= 0 {
+		return 0, 0, 0
+	}
+	if a >= 1 {
+		return r, g, b
+	}
+	return r / a, g / a, b / a
+}
+
+// luminanceFloat32 returns the luminance of a color, with the same coefficients as color.GrayModel.
+func luminanceFloat32(r, g, b float32) float32 {
+	return (19595*r + 38470*g + 7471*b) / (1 << 16)
+}
@@ -0,0 +1,160 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+var benchResRF, benchResGF, benchResBF, benchResAF float32
+
+func BenchmarkNewAtFuncFloat(b *testing.B) {
+	for _, tc := range []struct {
+		name     string
+		newImage func(r image.Rectangle) image.Image
+	}{
+		{
+			"RGBA",
+			func(r image.Rectangle) image.Image {
+				return image.NewRGBA(r)
+			},
+		},
+		{
+			"RGBA64",
+			func(r image.Rectangle) image.Image {
+				return image.NewRGBA64(r)
+			},
+		},
+		{
+			"NRGBATranslucent",
+			func(r image.Rectangle) image.Image {
+				p := image.NewNRGBA(r)
+				p.SetNRGBA(r.Min.X, r.Min.Y, color.NRGBA{0xff, 0xff, 0xff, 0x80})
+				return p
+			},
+		},
+		{
+			"NRGBA64Translucent",
+			func(r image.Rectangle) image.Image {
+				p := image.NewNRGBA64(r)
+				p.SetNRGBA64(r.Min.X, r.Min.Y, color.NRGBA64{0xffff, 0xffff, 0xffff, 0x8000})
+				return p
+			},
+		},
+		{
+			"Gray",
+			func(r image.Rectangle) image.Image {
+				return image.NewGray(r)
+			},
+		},
+		{
+			"Gray16",
+			func(r image.Rectangle) image.Image {
+				return image.NewGray16(r)
+			},
+		},
+		{
+			"YCbCr420",
+			func(r image.Rectangle) image.Image {
+				return image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+			},
+		},
+		{
+			"RGBA128F",
+			func(r image.Rectangle) image.Image {
+				return NewRGBA128F(r)
+			},
+		},
+		{
+			"Default",
+			func(r image.Rectangle) image.Image {
+				return &testImageDefault{image.NewRGBA(r)}
+			},
+		},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			p := tc.newImage(image.Rect(0, 0, 1, 1))
+			at := NewAtFuncFloat(p)
+			b.ResetTimer()
+			var resR, resG, resB, resA float32
+			for i := 0; i < b.N; i++ {
+				resR, resG, resB, resA = at(0, 0)
+			}
+			benchResRF, benchResGF, benchResBF, benchResAF = resR, resG, resB, resA
+		})
+	}
+}
+
+func BenchmarkNewSetFuncFloat(b *testing.B) {
+	for _, tc := range []struct {
+		name     string
+		newImage func(r image.Rectangle) draw.Image
+		color    color.Color
+	}{
+		{
+			name: "RGBA",
+			newImage: func(r image.Rectangle) draw.Image {
+				return image.NewRGBA(r)
+			},
+		},
+		{
+			name: "RGBA64",
+			newImage: func(r image.Rectangle) draw.Image {
+				return image.NewRGBA64(r)
+			},
+		},
+		{
+			name: "NRGBATranslucent",
+			newImage: func(r image.Rectangle) draw.Image {
+				return image.NewNRGBA(r)
+			},
+			color: color.NRGBA{0xff, 0xff, 0xff, 0x80},
+		},
+		{
+			name: "NRGBA64Translucent",
+			newImage: func(r image.Rectangle) draw.Image {
+				return image.NewNRGBA64(r)
+			},
+			color: color.NRGBA64{0xffff, 0xffff, 0xffff, 0x8000},
+		},
+		{
+			name: "Gray",
+			newImage: func(r image.Rectangle) draw.Image {
+				return image.NewGray(r)
+			},
+		},
+		{
+			name: "Gray16",
+			newImage: func(r image.Rectangle) draw.Image {
+				return image.NewGray16(r)
+			},
+		},
+		{
+			name: "RGBA128F",
+			newImage: func(r image.Rectangle) draw.Image {
+				return NewRGBA128F(r)
+			},
+		},
+		{
+			name: "Default",
+			newImage: func(r image.Rectangle) draw.Image {
+				return &testImageDefault{image.NewRGBA(r)}
+			},
+		},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			p := tc.newImage(image.Rect(0, 0, 1, 1))
+			set := NewSetFuncFloat(p)
+			var rr, gg, bb, aa float32 = 1, 1, 1, 1
+			if tc.color != nil {
+				r, g, b, a := tc.color.RGBA()
+				rr, gg, bb, aa = float32(r)/0xffff, float32(g)/0xffff, float32(b)/0xffff, float32(a)/0xffff
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				set(0, 0, rr, gg, bb, aa)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestNewAtFuncFloat(t *testing.T) {
+	bd := image.Rect(0, 0, 3, 3)
+	for _, newImageFunc := range testImageFuncs {
+		p := newImageFunc(bd)
+		t.Run(fmt.Sprintf("%T", p), func(t *testing.T) {
+			set := newSimpleSetFunc(p)
+			at := NewAtFuncFloat(p)
+			for _, c := range testColors {
+				for y := bd.Min.Y; y < bd.Max.Y; y++ {
+					for x := bd.Min.X; x < bd.Max.X; x++ {
+						set(x, y, c)
+						r1, g1, b1, a1 := at(x, y)
+						r2, g2, b2, a2 := p.At(x, y).RGBA()
+						if !testFloatEqual(r1, r2, 1) || !testFloatEqual(g1, g2, 1) || !testFloatEqual(b1, b2, 1) || !testFloatEqual(a1, a2, 1) {
+							t.Fatalf("different color: pixel %dx%d, color %#v: got {%f %f %f %f}, want {%d %d %d %d}", x, y, c, r1, g1, b1, a1, r2, g2, b2, a2)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewSetFuncFloat(t *testing.T) {
+	bd := image.Rect(0, 0, 3, 3)
+	for _, newImageDrawFunc := range testDrawImageFuncs {
+		p := newImageDrawFunc(bd)
+		t.Run(fmt.Sprintf("%T", p), func(t *testing.T) {
+			set := NewSetFuncFloat(p)
+			for _, c := range testColors {
+				c := color.RGBA64Model.Convert(c).(color.RGBA64)
+				for y := bd.Min.Y; y < bd.Max.Y; y++ {
+					for x := bd.Min.X; x < bd.Max.X; x++ {
+						set(x, y, float32(c.R)/0xffff, float32(c.G)/0xffff, float32(c.B)/0xffff, float32(c.A)/0xffff)
+						r1, g1, b1, a1 := p.At(x, y).RGBA()
+						p.Set(x, y, c)
+						r2, g2, b2, a2 := p.At(x, y).RGBA()
+						// The float values are rounded instead of truncated.
+						delta := uint32(0x101)
+						if _, ok := p.(*image.CMYK); ok {
+							// The rounding errors of the key and the other values are accumulated.
+							delta *= 2
+						}
+						if testAbsDiff(r1, r2) > delta || testAbsDiff(g1, g2) > delta || testAbsDiff(b1, b2) > delta || testAbsDiff(a1, a2) > delta {
+							t.Fatalf("different color: pixel %dx%d, color %#v: got {%d %d %d %d}, want {%d %d %d %d}", x, y, c, r1, g1, b1, a1, r2, g2, b2, a2)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewSetFuncFloatClip(t *testing.T) {
+	p := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	set := NewSetFuncFloat(p)
+	set(0, 0, 2, -1, 0.5, 1)
+	c := p.RGBAAt(0, 0)
+	if c != (color.RGBA{0xff, 0, 0x80, 0xff}) {
+		t.Fatalf("unexpected color: got %v, want {255 0 128 255}", c)
+	}
+}
+
+func testFloatEqual(v float32, want uint32, delta float64) bool {
+	return math.Abs(float64(v)*0xffff-float64(want)) <= delta
+}
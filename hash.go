@@ -0,0 +1,188 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+// Hash is a 64-bit perceptual hash.
+//
+// The bits are in row-major order, starting with the most significant bit.
+type Hash uint64
+
+// Distance returns the Hamming distance between 2 hashes (the number of different bits).
+//
+// Similar images have a small distance.
+func (h Hash) Distance(h2 Hash) int {
+	return bits.OnesCount64(uint64(h ^ h2))
+}
+
+// String implements fmt.Stringer.
+func (h Hash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// AverageHash returns the average hash (aHash) of an image.
+//
+// The bits are set for the pixels of a 8x8 grayscale version of the image that are brighter than the mean.
+func AverageHash(p image.Image) Hash {
+	vs := hashGray(p, 8, 8)
+	var mean float64
+	for _, v := range vs {
+		mean += v
+	}
+	mean /= float64(len(vs))
+	return hashThreshold(vs, mean)
+}
+
+// DifferenceHash returns the difference hash (dHash) of an image.
+//
+// The bits are set for the pixels of a 9x8 grayscale version of the image that are brighter than their left neighbor.
+func DifferenceHash(p image.Image) Hash {
+	vs := hashGray(p, 9, 8)
+	var h Hash
+	i := 0
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			if vs[y*9+x+1] > vs[y*9+x] {
+				h |= 1 << (63 - i)
+			}
+			i++
+		}
+	}
+	return h
+}
+
+// PerceptionHash returns the perception hash (pHash) of an image.
+//
+// It computes the DCT of a 32x32 grayscale version of the image.
+// The bits are set for the 8x8 lowest frequencies that are greater than their median.
+// The DC coefficient (the average brightness) is excluded from the median, and its bit is never set.
+func PerceptionHash(p image.Image) Hash {
+	const n = 32
+	vs := hashGray(p, n, n)
+	// The 2D DCT-II is computed with 2 passes of the 1D DCT, only for the lowest frequencies.
+	cos := make([]float64, 8*n)
+	for u := 0; u < 8; u++ {
+		for x := 0; x < n; x++ {
+			cos[u*n+x] = math.Cos(math.Pi * float64(u) * (2*float64(x) + 1) / (2 * n))
+		}
+	}
+	tmp := make([]float64, n*8)
+	for y := 0; y < n; y++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for x := 0; x < n; x++ {
+				s += vs[y*n+x] * cos[u*n+x]
+			}
+			tmp[y*8+u] = s
+		}
+	}
+	dct := make([]float64, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			var s float64
+			for y := 0; y < n; y++ {
+				s += tmp[y*8+u] * cos[v*n+y]
+			}
+			dct[v*8+u] = s
+		}
+	}
+	return hashThreshold(dct, hashMedian(dct[1:])) &^ (1 << 63)
+}
+
+// WaveletHash returns the wavelet hash (wHash) of an image.
+//
+// It computes 3 levels of the Haar wavelet transform of a 64x64 grayscale version of the image.
+// The bits are set for the 8x8 approximation coefficients that are greater than their median.
+func WaveletHash(p image.Image) Hash {
+	n := 64
+	vs := hashGray(p, n, n)
+	for n > 8 {
+		vs = haarApproximation(vs, n)
+		n /= 2
+	}
+	return hashThreshold(vs, hashMedian(vs))
+}
+
+// haarApproximation returns the approximation coefficients of 1 level of the 2D Haar wavelet transform of a n x n plane.
+func haarApproximation(vs []float64, n int) []float64 {
+	m := n / 2
+	res := make([]float64, m*m)
+	for y := 0; y < m; y++ {
+		for x := 0; x < m; x++ {
+			i := 2*y*n + 2*x
+			res[y*m+x] = (vs[i] + vs[i+1] + vs[i+n] + vs[i+n+1]) / 2
+		}
+	}
+	return res
+}
+
+// hashGray returns a w x h grayscale version of an image, in row-major order.
+//
+// Each pixel is the mean luminance of the area of the image that it covers, so the result doesn't depend much on the type of the image.
+// The luminance is computed with the Rec. 601 coefficients, from the alpha-premultiplied values (so the transparent pixels are black).
+func hashGray(p image.Image, w, h int) []float64 {
+	vs := make([]float64, w*h)
+	bd := p.Bounds()
+	if bd.Empty() {
+		return vs
+	}
+	at := NewAtFunc(p)
+	x0s, x1s := hashRanges(bd.Min.X, bd.Dx(), w)
+	y0s, y1s := hashRanges(bd.Min.Y, bd.Dy(), h)
+	(&Parallel{MinTileSize: -1}).Run(image.Rect(0, 0, w, h), func(r image.Rectangle) {
+		for j := r.Min.Y; j < r.Max.Y; j++ {
+			for i := r.Min.X; i < r.Max.X; i++ {
+				var s float64
+				for y := y0s[j]; y < y1s[j]; y++ {
+					for x := x0s[i]; x < x1s[i]; x++ {
+						cr, cg, cb, _ := at(x, y)
+						s += 0.299*float64(cr) + 0.587*float64(cg) + 0.114*float64(cb)
+					}
+				}
+				vs[j*w+i] = s / float64((y1s[j]-y0s[j])*(x1s[i]-x0s[i])) / 0xffff
+			}
+		}
+	})
+	return vs
+}
+
+// hashRanges splits [min, min+size[ in n ranges [starts[i], ends[i][.
+//
+// If size is lower than n, the ranges overlap, and each one contains 1 value.
+func hashRanges(min, size, n int) (starts, ends []int) {
+	starts, ends = make([]int, n), make([]int, n)
+	for i := range starts {
+		start := i * size / n
+		end := (i + 1) * size / n
+		if end <= start {
+			end = start + 1
+		}
+		starts[i], ends[i] = min+start, min+end
+	}
+	return starts, ends
+}
+
+func hashThreshold(vs []float64, t float64) Hash {
+	var h Hash
+	for i, v := range vs {
+		if v > t {
+			h |= 1 << (63 - i)
+		}
+	}
+	return h
+}
+
+func hashMedian(vs []float64) float64 {
+	s := append([]float64(nil), vs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
@@ -0,0 +1,161 @@
+package imageutil
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+)
+
+var testHashFuncs = []struct {
+	name string
+	hash func(image.Image) Hash
+}{
+	{"Average", AverageHash},
+	{"Difference", DifferenceHash},
+	{"Perception", PerceptionHash},
+	{"Wavelet", WaveletHash},
+}
+
+func TestHashDistance(t *testing.T) {
+	for _, tc := range []struct {
+		h1, h2   Hash
+		expected int
+	}{
+		{0, 0, 0},
+		{0, 1, 1},
+		{0xf0, 0x0f, 8},
+		{0, 0xffffffffffffffff, 64},
+	} {
+		d := tc.h1.Distance(tc.h2)
+		if d != tc.expected {
+			t.Fatalf("unexpected distance between %v and %v: got %d, want %d", tc.h1, tc.h2, d, tc.expected)
+		}
+	}
+}
+
+func TestHashString(t *testing.T) {
+	s := Hash(0x0f0f).String()
+	expected := "0000000000000f0f"
+	if s != expected {
+		t.Fatalf("unexpected string: got %q, want %q", s, expected)
+	}
+}
+
+func TestHashGradient(t *testing.T) {
+	p := image.NewGray(image.Rect(-10, 5, 90, 85))
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+			p.SetGray(x, y, color.Gray{uint8((x - p.Rect.Min.X) * 2)})
+		}
+	}
+	for _, tc := range []struct {
+		name     string
+		hash     func(image.Image) Hash
+		expected Hash
+	}{
+		{"Average", AverageHash, 0x0f0f0f0f0f0f0f0f},
+		{"Difference", DifferenceHash, 0xffffffffffffffff},
+		{"Wavelet", WaveletHash, 0x0f0f0f0f0f0f0f0f},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := tc.hash(p)
+			if h != tc.expected {
+				t.Fatalf("unexpected hash: got %v, want %v", h, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHashStable(t *testing.T) {
+	p := testNewHashImage(image.Rect(0, 0, 200, 150), 0)
+	// The same picture as a YCbCr image, through JPEG.
+	buf := new(bytes.Buffer)
+	err := jpeg.Encode(buf, p, &jpeg.Options{Quality: 90})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ycc, err := jpeg.Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small := image.NewRGBA(image.Rect(0, 0, 100, 75))
+	Resize(small, p, ResizeBilinear, nil)
+	other := testNewHashImage(image.Rect(0, 0, 200, 150), 1)
+	for _, tc := range testHashFuncs {
+		t.Run(tc.name, func(t *testing.T) {
+			h := tc.hash(p)
+			for _, sim := range []image.Image{ycc, small} {
+				d := h.Distance(tc.hash(sim))
+				if d > 4 {
+					t.Fatalf("unexpected distance for similar %T: got %d, want <= 4", sim, d)
+				}
+			}
+			d := h.Distance(tc.hash(other))
+			if d < 10 {
+				t.Fatalf("unexpected distance for different image: got %d, want >= 10", d)
+			}
+		})
+	}
+}
+
+func TestPerceptionHashBrightness(t *testing.T) {
+	src := testNewHashImage(image.Rect(0, 0, 200, 150), 0)
+	p := image.NewGray(src.Rect)
+	brighter := image.NewGray(src.Rect)
+	for i := range p.Pix {
+		v := src.Pix[i*4] / 2
+		p.Pix[i] = v + 0x10
+		brighter.Pix[i] = v + 0x60
+	}
+	h := PerceptionHash(p)
+	if h&(1<<63) != 0 {
+		t.Fatalf("the DC bit is set: %v", h)
+	}
+	// Only the DC coefficient changes.
+	if d := h.Distance(PerceptionHash(brighter)); d > 2 {
+		t.Fatalf("unexpected distance for brighter image: got %d, want <= 2", d)
+	}
+}
+
+func TestHashSmall(t *testing.T) {
+	p := testNewRandomImage(image.Rect(0, 0, 3, 2))
+	for _, tc := range testHashFuncs {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.hash(p)
+			tc.hash(image.NewRGBA(image.Rectangle{}))
+		})
+	}
+}
+
+// testNewHashImage returns a smooth image with a pattern defined by a seed.
+func testNewHashImage(r image.Rectangle, seed int) *image.NRGBA {
+	p := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			fx, fy := float64(x)/float64(r.Dx()), float64(y)/float64(r.Dy())
+			var v float64
+			if seed == 0 {
+				v = math.Sin(fx*7+fy*3) + math.Cos(fy*9)
+			} else {
+				v = math.Cos(fx*5-fy*11) + math.Sin(fx*fy*13)
+			}
+			c := uint8((v + 2) / 4 * 0xff)
+			p.SetNRGBA(x, y, color.NRGBA{c, 0xff - c, c / 2, 0xff})
+		}
+	}
+	return p
+}
+
+func BenchmarkHash(b *testing.B) {
+	p := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	for _, tc := range testHashFuncs {
+		b.Run(tc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				tc.hash(p)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package imageutil
+
+import (
+	"image"
+	"math"
+)
+
+// HistogramChannel is a channel of a Histogram.
+type HistogramChannel int
+
+const (
+	// HistogramRed is the red channel.
+	HistogramRed HistogramChannel = iota
+	// HistogramGreen is the green channel.
+	HistogramGreen
+	// HistogramBlue is the blue channel.
+	HistogramBlue
+	// HistogramAlpha is the alpha channel.
+	HistogramAlpha
+	// HistogramLuminance is the luminance, computed with the Rec. 601 coefficients (like color.GrayModel).
+	HistogramLuminance
+)
+
+// HistogramMode defines how the pixels are counted, depending on their alpha value.
+type HistogramMode int
+
+const (
+	// HistogramAll counts all the pixels with a weight of 1.
+	HistogramAll HistogramMode = iota
+	// HistogramAlphaWeighted counts the pixels with a weight equal to their alpha value (in [0, 1]).
+	HistogramAlphaWeighted
+	// HistogramIgnoreTransparent ignores the fully transparent pixels, and counts the other pixels with a weight of 1.
+	HistogramIgnoreTransparent
+)
+
+// HistogramOptions are the options of NewHistogram.
+type HistogramOptions struct {
+	// Bins16 uses 65536 bins (16-bit) instead of 256 bins (8-bit).
+	Bins16 bool
+	// Mode defines how the pixels are counted.
+	Mode HistogramMode
+	// Parallel is used to process the image concurrently.
+	Parallel *Parallel
+}
+
+// Histogram is a histogram of the channels of an image.
+//
+// The color values are not alpha-premultiplied.
+type Histogram struct {
+	// Bins holds the weights of the bins of each channel, indexed by HistogramChannel.
+	// The bin i of a channel with n bins contains the values v such as i = floor(v * n / 65536), v being in [0, 0xffff].
+	Bins [5][]float64
+}
+
+// NewHistogram returns the Histogram of an image.
+//
+// There are fast paths for Gray, Gray16, RGBA, NRGBA and YCbCr images.
+// The fast path of YCbCr images scans the Y, Cb and Cr planes directly.
+// The luminance is computed the same way for all the image types.
+// The histograms of the tiles are computed concurrently, and merged.
+// If opts is nil, the default options are used.
+func NewHistogram(p image.Image, opts *HistogramOptions) *Histogram {
+	if opts == nil {
+		opts = new(HistogramOptions)
+	}
+	n, shift := 256, uint(8)
+	if opts.Bins16 {
+		n, shift = 1<<16, 0
+	}
+	fill := newHistogramFill(p)
+	h := ParallelReduce(opts.Parallel, p.Bounds(), func(r image.Rectangle) *Histogram {
+		t := &histogramTile{
+			h:     newHistogram(n),
+			shift: shift,
+			mode:  opts.Mode,
+		}
+		fill(r, t)
+		return t.h
+	}, func(a, b *Histogram) *Histogram {
+		for ch := range a.Bins {
+			for i, v := range b.Bins[ch] {
+				a.Bins[ch][i] += v
+			}
+		}
+		return a
+	})
+	if h == nil {
+		h = newHistogram(n)
+	}
+	return h
+}
+
+func newHistogram(n int) *Histogram {
+	h := new(Histogram)
+	for ch := range h.Bins {
+		h.Bins[ch] = make([]float64, n)
+	}
+	return h
+}
+
+// Total returns the total weight of the pixels.
+func (h *Histogram) Total() float64 {
+	var t float64
+	for _, v := range h.Bins[HistogramAlpha] {
+		t += v
+	}
+	return t
+}
+
+// ChannelStats are the statistics of a channel.
+//
+// The values are in [0, 1].
+type ChannelStats struct {
+	Mean   float64
+	StdDev float64
+	Min    float64
+	Max    float64
+}
+
+// Stats returns the statistics of a channel.
+//
+// The value of a bin is its index divided by the number of bins minus 1.
+// If the histogram is empty, it returns zero values.
+func (h *Histogram) Stats(ch HistogramChannel) ChannelStats {
+	bins := h.Bins[ch]
+	scale := 1 / float64(len(bins)-1)
+	var st ChannelStats
+	var total, sum, sum2 float64
+	first := true
+	for i, w := range bins {
+		if w == 0 {
+			continue
+		}
+		v := float64(i) * scale
+		if first {
+			st.Min = v
+			first = false
+		}
+		st.Max = v
+		total += w
+		sum += v * w
+		sum2 += v * v * w
+	}
+	if total == 0 {
+		return st
+	}
+	st.Mean = sum / total
+	st.StdDev = math.Sqrt(math.Max(sum2/total-st.Mean*st.Mean, 0))
+	return st
+}
+
+// Percentile returns the value (in [0, 1]) of a percentile (in [0, 100]) of a channel.
+//
+// It is the value of the first bin whose cumulative weight is greater than or equal to p percent of the total weight.
+// If the histogram is empty, it returns 0.
+func (h *Histogram) Percentile(ch HistogramChannel, p float64) float64 {
+	bins := h.Bins[ch]
+	var total float64
+	for _, w := range bins {
+		total += w
+	}
+	if total == 0 {
+		return 0
+	}
+	t := total * p / 100
+	var sum float64
+	last := 0
+	for i, w := range bins {
+		if w == 0 {
+			continue
+		}
+		sum += w
+		last = i
+		if sum >= t {
+			break
+		}
+	}
+	return float64(last) / float64(len(bins)-1)
+}
+
+// histogramTile accumulates the pixels of a tile in a Histogram.
+type histogramTile struct {
+	h     *Histogram
+	shift uint
+	mode  HistogramMode
+}
+
+// add adds a pixel, with non-premultiplied 16-bit values.
+func (t *histogramTile) add(r, g, b, a, l uint32) {
+	w := 1.0
+	switch t.mode {
+	case HistogramAlphaWeighted:
+		w = float64(a) / 0xffff
+	case HistogramIgnoreTransparent:
+		if a == 0 {
+			return
+		}
+	}
+	t.h.Bins[HistogramRed][r>>t.shift] += w
+	t.h.Bins[HistogramGreen][g>>t.shift] += w
+	t.h.Bins[HistogramBlue][b>>t.shift] += w
+	t.h.Bins[HistogramAlpha][a>>t.shift] += w
+	t.h.Bins[HistogramLuminance][l>>t.shift] += w
+}
+
+func histogramLuminance(r, g, b uint32) uint32 {
+	return (19595*r + 38470*g + 7471*b + 1<<15) >> 16
+}
+
+// newHistogramFill returns a function that adds the pixels of a Rectangle to a histogramTile.
+func newHistogramFill(p image.Image) func(r image.Rectangle, t *histogramTile) {
+	switch p := p.(type) {
+	case *image.Gray:
+		return func(r image.Rectangle, t *histogramTile) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				i := p.PixOffset(r.Min.X, y)
+				for _, v := range p.Pix[i : i+r.Dx()] {
+					c := uint32(v) * 0x101
+					t.add(c, c, c, 0xffff, c)
+				}
+			}
+		}
+	case *image.Gray16:
+		return func(r image.Rectangle, t *histogramTile) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				i := p.PixOffset(r.Min.X, y)
+				s := p.Pix[i : i+r.Dx()*2]
+				for j := 0; j < len(s); j += 2 {
+					c := uint32(s[j])<<8 | uint32(s[j+1])
+					t.add(c, c, c, 0xffff, c)
+				}
+			}
+		}
+	case *image.NRGBA:
+		return func(r image.Rectangle, t *histogramTile) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				i := p.PixOffset(r.Min.X, y)
+				s := p.Pix[i : i+r.Dx()*4]
+				for j := 0; j < len(s); j += 4 {
+					cr, cg, cb, ca := uint32(s[j])*0x101, uint32(s[j+1])*0x101, uint32(s[j+2])*0x101, uint32(s[j+3])*0x101
+					if ca == 0 {
+						// Like RGBAToNRGBA, the transparent pixels are black.
+						cr, cg, cb = 0, 0, 0
+					}
+					t.add(cr, cg, cb, ca, histogramLuminance(cr, cg, cb))
+				}
+			}
+		}
+	case *image.RGBA:
+		return func(r image.Rectangle, t *histogramTile) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				i := p.PixOffset(r.Min.X, y)
+				s := p.Pix[i : i+r.Dx()*4]
+				for j := 0; j < len(s); j += 4 {
+					cr, cg, cb, ca := RGBAToNRGBA(uint32(s[j])*0x101, uint32(s[j+1])*0x101, uint32(s[j+2])*0x101, uint32(s[j+3])*0x101)
+					t.add(cr, cg, cb, ca, histogramLuminance(cr, cg, cb))
+				}
+			}
+		}
+	case *image.YCbCr:
+		// The number of pixels per chroma sample, horizontally.
+		cw := 1
+		switch p.SubsampleRatio {
+		case image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420:
+			cw = 2
+		case image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410:
+			cw = 4
+		}
+		return func(r image.Rectangle, t *histogramTile) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				yi := p.YOffset(r.Min.X, y)
+				ys := p.Y[yi : yi+r.Dx()]
+				ci := p.COffset(r.Min.X, y) - r.Min.X/cw
+				for i, yy := range ys {
+					// The same formula as COffset, so it is correct for the negative coordinates.
+					j := ci + (r.Min.X+i)/cw
+					cr, cg, cb := yCbCrToRGB(yy, p.Cb[j], p.Cr[j])
+					t.add(cr, cg, cb, 0xffff, histogramLuminance(cr, cg, cb))
+				}
+			}
+		}
+	default:
+		at := NewAtFunc(p)
+		return func(r image.Rectangle, t *histogramTile) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					cr, cg, cb, ca := RGBAToNRGBA(at(x, y))
+					t.add(cr, cg, cb, ca, histogramLuminance(cr, cg, cb))
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+func TestHistogramFastPaths(t *testing.T) {
+	// The source is opaque, so the conversions are lossless.
+	src := testNewRandomImage(image.Rect(-3, 2, 30, 25))
+	for i := 3; i < len(src.Pix); i += 4 {
+		src.Pix[i] = 0xff
+	}
+	for _, p := range []image.Image{
+		image.NewGray(src.Bounds()),
+		image.NewGray16(src.Bounds()),
+		image.NewNRGBA(src.Bounds()),
+		image.NewRGBA(src.Bounds()),
+		image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio444),
+		image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio422),
+		image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio420),
+		image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio440),
+		image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio411),
+		image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio410),
+	} {
+		name := fmt.Sprintf("%T", p)
+		if yp, ok := p.(*image.YCbCr); ok {
+			name += yp.SubsampleRatio.String()
+		}
+		t.Run(name, func(t *testing.T) {
+			if yp, ok := p.(*image.YCbCr); ok {
+				for y := yp.Rect.Min.Y; y < yp.Rect.Max.Y; y++ {
+					for x := yp.Rect.Min.X; x < yp.Rect.Max.X; x++ {
+						c := color.YCbCrModel.Convert(src.At(x, y)).(color.YCbCr)
+						yp.Y[yp.YOffset(x, y)] = c.Y
+						yp.Cb[yp.COffset(x, y)], yp.Cr[yp.COffset(x, y)] = c.Cb, c.Cr
+					}
+				}
+			} else {
+				draw.Draw(p.(draw.Image), p.Bounds(), src, src.Bounds().Min, draw.Src)
+			}
+			for _, bins16 := range []bool{false, true} {
+				opts := &HistogramOptions{
+					Bins16:   bins16,
+					Parallel: &Parallel{TileHeight: 3, MinTileSize: 1},
+				}
+				h := NewHistogram(p, opts)
+				// The default path is used for the Crop view.
+				expected := NewHistogram(&Crop{Image: p, Rect: p.Bounds()}, opts)
+				for ch := range h.Bins {
+					testHistogramBinsEqual(t, h.Bins[ch], expected.Bins[ch])
+				}
+			}
+		})
+	}
+}
+
+func testHistogramBinsEqual(tb testing.TB, bins, expected []float64) {
+	tb.Helper()
+	if len(bins) != len(expected) {
+		tb.Fatalf("unexpected bins length: got %d, want %d", len(bins), len(expected))
+	}
+	for i, v := range bins {
+		if v != expected[i] {
+			tb.Fatalf("unexpected bin %d: got %f, want %f", i, v, expected[i])
+		}
+	}
+}
+
+func TestHistogramStats(t *testing.T) {
+	p := image.NewGray(image.Rect(0, 0, 4, 1))
+	copy(p.Pix, []uint8{0, 0x33, 0x66, 0xff})
+	h := NewHistogram(p, nil)
+	if total := h.Total(); total != 4 {
+		t.Fatalf("unexpected total: got %f, want %f", total, 4.0)
+	}
+	st := h.Stats(HistogramLuminance)
+	vs := []float64{0, 0.2, 0.4, 1}
+	mean := (vs[0] + vs[1] + vs[2] + vs[3]) / 4
+	var variance float64
+	for _, v := range vs {
+		variance += (v - mean) * (v - mean) / 4
+	}
+	expected := ChannelStats{
+		Mean:   mean,
+		StdDev: math.Sqrt(variance),
+		Min:    0,
+		Max:    1,
+	}
+	if math.Abs(st.Mean-expected.Mean) > 1e-9 || math.Abs(st.StdDev-expected.StdDev) > 1e-9 || st.Min != expected.Min || st.Max != expected.Max {
+		t.Fatalf("unexpected stats: got %+v, want %+v", st, expected)
+	}
+	for _, tc := range []struct {
+		p        float64
+		expected float64
+	}{
+		{0, 0},
+		{25, 0},
+		{30, 0.2},
+		{50, 0.2},
+		{75, 0.4},
+		{100, 1},
+	} {
+		v := h.Percentile(HistogramRed, tc.p)
+		if math.Abs(v-tc.expected) > 1e-9 {
+			t.Fatalf("unexpected percentile %f: got %f, want %f", tc.p, v, tc.expected)
+		}
+	}
+}
+
+func TestHistogramModes(t *testing.T) {
+	p := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	p.SetNRGBA(0, 0, color.NRGBA{0xff, 0, 0, 0xff})
+	p.SetNRGBA(1, 0, color.NRGBA{0xff, 0, 0, 0x00})
+	p.SetNRGBA(2, 0, color.NRGBA{0, 0, 0, 0x80})
+	for _, tc := range []struct {
+		mode        HistogramMode
+		red0, red1  float64
+		totalWeight float64
+	}{
+		{HistogramAll, 2, 1, 3},
+		{HistogramAlphaWeighted, float64(0x8080) / 0xffff, 1, 1 + float64(0x8080)/0xffff},
+		{HistogramIgnoreTransparent, 1, 1, 2},
+	} {
+		t.Run(fmt.Sprint(tc.mode), func(t *testing.T) {
+			h := NewHistogram(p, &HistogramOptions{Mode: tc.mode})
+			// The transparent pixel is black.
+			if v := h.Bins[HistogramRed][0]; math.Abs(v-tc.red0) > 1e-9 {
+				t.Fatalf("unexpected red bin 0: got %f, want %f", v, tc.red0)
+			}
+			if v := h.Bins[HistogramRed][0xff]; math.Abs(v-tc.red1) > 1e-9 {
+				t.Fatalf("unexpected red bin 255: got %f, want %f", v, tc.red1)
+			}
+			if v := h.Total(); math.Abs(v-tc.totalWeight) > 1e-9 {
+				t.Fatalf("unexpected total: got %f, want %f", v, tc.totalWeight)
+			}
+		})
+	}
+}
+
+func TestHistogramEmpty(t *testing.T) {
+	h := NewHistogram(image.NewRGBA(image.Rectangle{}), nil)
+	if h.Total() != 0 || h.Percentile(HistogramRed, 50) != 0 || h.Stats(HistogramRed) != (ChannelStats{}) {
+		t.Fatal("unexpected non-empty histogram")
+	}
+}
+
+func BenchmarkNewHistogram(b *testing.B) {
+	src := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	for _, p := range []image.Image{
+		image.NewGray(src.Bounds()),
+		image.NewNRGBA(src.Bounds()),
+		image.NewRGBA(src.Bounds()),
+		image.NewYCbCr(src.Bounds(), image.YCbCrSubsampleRatio420),
+		image.NewRGBA64(src.Bounds()),
+	} {
+		b.Run(fmt.Sprintf("%T", p), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				NewHistogram(p, nil)
+			}
+		})
+	}
+}
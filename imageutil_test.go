@@ -44,3 +44,22 @@ var testPalette = color.Palette{
 	color.RGBA{0, 0, 255, 255},
 	color.RGBA{255, 255, 255, 255},
 }
+
+func testNewRandomImage(r image.Rectangle) *image.NRGBA {
+	p := image.NewNRGBA(r)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			p.Set(x, y, testRandomColor())
+		}
+	}
+	return p
+}
+
+// testNewOpaqueRandomImage returns an opaque random image, so the conversions between premultiplied and non-premultiplied values are lossless.
+func testNewOpaqueRandomImage(r image.Rectangle) *image.NRGBA {
+	p := testNewRandomImage(r)
+	for i := 3; i < len(p.Pix); i += 4 {
+		p.Pix[i] = 0xff
+	}
+	return p
+}
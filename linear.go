@@ -0,0 +1,143 @@
+package imageutil
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"sync"
+)
+
+var (
+	linearTablesOnce  sync.Once
+	srgbToLinearTable []uint16
+	linearToSRGBTable []uint16
+)
+
+// initLinearTables initializes the lookup tables of the sRGB transfer functions.
+//
+// They are initialized lazily, because they use 256 KiB of memory.
+func initLinearTables() {
+	linearTablesOnce.Do(func() {
+		srgbToLinearTable = make([]uint16, 1<<16)
+		linearToSRGBTable = make([]uint16, 1<<16)
+		for i := range srgbToLinearTable {
+			v := float64(i) / 0xffff
+			srgbToLinearTable[i] = uint16(math.Round(srgbToLinear(v) * 0xffff))
+			linearToSRGBTable[i] = uint16(math.Round(linearToSRGB(v) * 0xffff))
+		}
+	})
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// SRGBToLinear converts a 16 bits sRGB encoded value to linear light.
+//
+// It uses a lookup table.
+func SRGBToLinear(v uint32) uint32 {
+	initLinearTables()
+	return uint32(srgbToLinearTable[v&0xffff])
+}
+
+// LinearToSRGB converts a 16 bits linear light value to sRGB encoded.
+//
+// It uses a lookup table.
+func LinearToSRGB(v uint32) uint32 {
+	initLinearTables()
+	return uint32(linearToSRGBTable[v&0xffff])
+}
+
+// RGBAToLinear converts sRGB encoded RGBA to linear light RGBA.
+//
+// Both are alpha-premultiplied: the color is unpremultiplied, linearized, and premultiplied again.
+func RGBAToLinear(r, g, b, a uint32) (uint32, uint32, uint32, uint32) {
+	if a == 0 {
+		return 0, 0, 0, 0
+	}
+	initLinearTables()
+	r, g, b, a = RGBAToNRGBA(r, g, b, a)
+	r = uint32(srgbToLinearTable[r&0xffff])
+	g = uint32(srgbToLinearTable[g&0xffff])
+	b = uint32(srgbToLinearTable[b&0xffff])
+	return NRGBAToRGBA(r, g, b, a)
+}
+
+// LinearToRGBA converts linear light RGBA to sRGB encoded RGBA.
+//
+// Both are alpha-premultiplied: the color is unpremultiplied, encoded, and premultiplied again.
+func LinearToRGBA(r, g, b, a uint32) (uint32, uint32, uint32, uint32) {
+	if a == 0 {
+		return 0, 0, 0, 0
+	}
+	initLinearTables()
+	r, g, b, a = RGBAToNRGBA(r, g, b, a)
+	r = uint32(linearToSRGBTable[r&0xffff])
+	g = uint32(linearToSRGBTable[g&0xffff])
+	b = uint32(linearToSRGBTable[b&0xffff])
+	return NRGBAToRGBA(r, g, b, a)
+}
+
+// NewAtFuncLinear returns an AtFunc for an Image, that returns linear light RGBA values.
+//
+// The image is assumed to be sRGB encoded.
+func NewAtFuncLinear(p image.Image) AtFunc {
+	at := NewAtFunc(p)
+	initLinearTables()
+	return func(x, y int) (r, g, b, a uint32) {
+		return RGBAToLinear(at(x, y))
+	}
+}
+
+// NewSetFuncLinear returns a SetFunc for an Image, that accepts linear light RGBA values.
+//
+// The values are sRGB encoded before they are set to the image.
+func NewSetFuncLinear(p draw.Image) SetFunc {
+	set := NewSetFunc(p)
+	initLinearTables()
+	return func(x, y int, r, g, b, a uint32) {
+		r, g, b, a = LinearToRGBA(r, g, b, a)
+		set(x, y, r, g, b, a)
+	}
+}
+
+// premultipliedToLinearFloat32 converts alpha-premultiplied sRGB encoded color values to alpha-premultiplied linear light.
+//
+// It uses the lookup tables, which must be initialized with initLinearTables.
+func premultipliedToLinearFloat32(r, g, b, a float32) (float32, float32, float32) {
+	if a <= 0 {
+		return 0, 0, 0
+	}
+	return lookupLinearTableFloat32(srgbToLinearTable, r/a, srgbToLinear) * a, lookupLinearTableFloat32(srgbToLinearTable, g/a, srgbToLinear) * a, lookupLinearTableFloat32(srgbToLinearTable, b/a, srgbToLinear) * a
+}
+
+// premultipliedFromLinearFloat32 converts alpha-premultiplied linear light color values to alpha-premultiplied sRGB encoded.
+//
+// The color values are clipped to [0, alpha].
+// It uses the lookup tables, which must be initialized with initLinearTables.
+func premultipliedFromLinearFloat32(r, g, b, a float32) (float32, float32, float32) {
+	if a <= 0 {
+		return 0, 0, 0
+	}
+	return lookupLinearTableFloat32(linearToSRGBTable, clampFloat32(r/a), linearToSRGB) * a, lookupLinearTableFloat32(linearToSRGBTable, clampFloat32(g/a), linearToSRGB) * a, lookupLinearTableFloat32(linearToSRGBTable, clampFloat32(b/a), linearToSRGB) * a
+}
+
+// lookupLinearTableFloat32 converts a value with a lookup table of a sRGB transfer function.
+//
+// The values outside of [0, 1] (high dynamic range) and NaN are converted with f.
+func lookupLinearTableFloat32(table []uint16, v float32, f func(float64) float64) float32 {
+	if !(v >= 0 && v <= 1) {
+		return float32(f(float64(v)))
+	}
+	return float32(table[int(v*0xffff+0.5)]) / 0xffff
+}
@@ -0,0 +1,103 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestSRGBToLinear(t *testing.T) {
+	prev := uint32(0)
+	for v := uint32(0); v <= 0xffff; v++ {
+		l := SRGBToLinear(v)
+		want := uint32(math.Round(srgbToLinear(float64(v)/0xffff) * 0xffff))
+		if l != want {
+			t.Fatalf("different value: %d: got %d, want %d", v, l, want)
+		}
+		if l < prev {
+			t.Fatalf("not monotonic: %d: %d < %d", v, l, prev)
+		}
+		prev = l
+		// The linear values are less precise in the shadows, because the slope of the sRGB curve is 12.92.
+		if d := testAbsDiff(LinearToSRGB(l), v); d > 7 {
+			t.Fatalf("round trip error: %d: %d", v, d)
+		}
+	}
+	if SRGBToLinear(0xffff) != 0xffff || LinearToSRGB(0xffff) != 0xffff {
+		t.Fatal("white is not preserved")
+	}
+}
+
+func TestRGBAToLinear(t *testing.T) {
+	for _, c := range testColors {
+		r, g, b, a := c.RGBA()
+		lr, lg, lb, la := RGBAToLinear(r, g, b, a)
+		if la != a || lr > la || lg > la || lb > la {
+			t.Fatalf("invalid linear color: %v: {%d %d %d %d}", c, lr, lg, lb, la)
+		}
+		nr, ng, nb, na := RGBAToNRGBA(r, g, b, a)
+		wr, wg, wb, wa := NRGBAToRGBA(SRGBToLinear(nr), SRGBToLinear(ng), SRGBToLinear(nb), na)
+		if lr != wr || lg != wg || lb != wb || la != wa {
+			t.Fatalf("different color: %v: got {%d %d %d %d}, want {%d %d %d %d}", c, lr, lg, lb, la, wr, wg, wb, wa)
+		}
+		r2, g2, b2, a2 := LinearToRGBA(lr, lg, lb, la)
+		if a2 != a {
+			t.Fatalf("different alpha: %v: got %d, want %d", c, a2, a)
+		}
+		if c.(color.NRGBA).A == 0xff && (r2>>8 != r>>8 || g2>>8 != g>>8 || b2>>8 != b>>8) {
+			t.Fatalf("round trip error: %v: got {%d %d %d %d}, want {%d %d %d %d}", c, r2, g2, b2, a2, r, g, b, a)
+		}
+	}
+}
+
+func TestNewAtFuncLinearSetFuncLinear(t *testing.T) {
+	bd := image.Rect(0, 0, 3, 3)
+	src := image.NewRGBA(bd)
+	dst := image.NewRGBA(bd)
+	at := NewAtFuncLinear(src)
+	set := NewSetFuncLinear(dst)
+	for _, c := range testColors {
+		if c.(color.NRGBA).A != 0xff {
+			continue
+		}
+		src.Set(1, 1, c)
+		r, g, b, a := at(1, 1)
+		if r2, g2, b2, a2 := RGBAToLinear(src.At(1, 1).RGBA()); r != r2 || g != g2 || b != b2 || a != a2 {
+			t.Fatalf("different color: %v: got {%d %d %d %d}, want {%d %d %d %d}", c, r, g, b, a, r2, g2, b2, a2)
+		}
+		set(1, 1, r, g, b, a)
+		if dst.At(1, 1) != src.At(1, 1) {
+			t.Fatalf("round trip error: got %v, want %v", dst.At(1, 1), src.At(1, 1))
+		}
+	}
+}
+
+func BenchmarkRGBAToLinear(b *testing.B) {
+	var resR, resG, resB, resA uint32
+	for i := 0; i < b.N; i++ {
+		resR, resG, resB, resA = RGBAToLinear(0x8000, 0x4000, 0x0000, 0x8000)
+	}
+	benchResR, benchResG, benchResB, benchResA = resR, resG, resB, resA
+}
+
+func testAbsDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestPremultipliedLinearFloat32NaN(t *testing.T) {
+	initLinearTables()
+	nan := float32(math.NaN())
+	r, _, _ := premultipliedToLinearFloat32(nan, 0.5, 0.5, 1)
+	if !math.IsNaN(float64(r)) {
+		t.Fatalf("unexpected value: got %f, want NaN", r)
+	}
+	premultipliedFromLinearFloat32(nan, 0.5, 0.5, 1)
+	// A NaN value of a float image doesn't crash Resize.
+	src := NewRGBA128F(image.Rect(0, 0, 4, 4))
+	src.Pix[0], src.Pix[3] = nan, 1
+	Resize(image.NewRGBA(image.Rect(0, 0, 2, 2)), src, ResizeBilinear, &ResizeOptions{Linear: true})
+}
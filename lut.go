@@ -0,0 +1,388 @@
+package imageutil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LUTInterpolation is the interpolation method of a 3D LUT.
+type LUTInterpolation int
+
+const (
+	// LUTTrilinear interpolates the 8 points of the grid cell.
+	LUTTrilinear LUTInterpolation = iota
+	// LUTTetrahedral interpolates the 4 points of the tetrahedron of the grid cell.
+	// On the neutral axis (r = g = b), only the points of the diagonal of the grid are used.
+	LUTTetrahedral
+)
+
+// LUT is a color lookup table.
+//
+// It is applied to the non-premultiplied color values.
+// The 1D curves are applied first, then the 3D table.
+type LUT struct {
+	// Curves are the 1D tables of the red, green and blue channels.
+	// A nil ToneCurve doesn't change the channel.
+	Curves [3]*ToneCurve
+	// Size is the number of points of each axis of the 3D grid.
+	// If it's lower than 2, the 3D table is not used.
+	Size int
+	// Table holds the red, green and blue values (in [0, 1]) of the points of the 3D grid.
+	// The red index changes fastest, then the green index, then the blue index (like the .cube format).
+	Table []float32
+	// Interpolation is the interpolation method of the 3D table.
+	Interpolation LUTInterpolation
+}
+
+// NewLUT3D returns a LUT with a 3D table of the given size, computed with a function.
+//
+// The values of the function are in [0, 1].
+func NewLUT3D(size int, f func(r, g, b float64) (float64, float64, float64)) *LUT {
+	l := &LUT{
+		Size:  size,
+		Table: make([]float32, size*size*size*3),
+	}
+	s := float64(size - 1)
+	i := 0
+	for b := 0; b < size; b++ {
+		for g := 0; g < size; g++ {
+			for r := 0; r < size; r++ {
+				vr, vg, vb := f(float64(r)/s, float64(g)/s, float64(b)/s)
+				l.Table[i], l.Table[i+1], l.Table[i+2] = float32(vr), float32(vg), float32(vb)
+				i += 3
+			}
+		}
+	}
+	return l
+}
+
+func (l *LUT) has3D() bool {
+	return l.Size >= 2 && len(l.Table) >= l.Size*l.Size*l.Size*3
+}
+
+// lookup returns the interpolated value of the 3D table at the grid coordinates (x, y, z).
+//
+// The coordinates are in [0, Size-1].
+func (l *LUT) lookup(x, y, z float32) [3]float32 {
+	s := l.Size
+	ix, iy, iz := lutCell(x, s), lutCell(y, s), lutCell(z, s)
+	fx, fy, fz := x-float32(ix), y-float32(iy), z-float32(iz)
+	// The offsets of the next points on each axis.
+	dx, dy, dz := 3, s*3, s*s*3
+	t := l.Table[((iz*s+iy)*s+ix)*3:]
+	var res [3]float32
+	if l.Interpolation == LUTTetrahedral {
+		// The weights and the offsets of the 2 middle points of the tetrahedron.
+		var w0, w1, w2, w3 float32
+		var o1, o2 int
+		switch {
+		case fx > fy && fy > fz:
+			w0, w1, w2, w3, o1, o2 = 1-fx, fx-fy, fy-fz, fz, dx, dx+dy
+		case fx > fy && fx > fz:
+			w0, w1, w2, w3, o1, o2 = 1-fx, fx-fz, fz-fy, fy, dx, dx+dz
+		case fx > fy:
+			w0, w1, w2, w3, o1, o2 = 1-fz, fz-fx, fx-fy, fy, dz, dx+dz
+		case fz > fy:
+			w0, w1, w2, w3, o1, o2 = 1-fz, fz-fy, fy-fx, fx, dz, dy+dz
+		case fz > fx:
+			w0, w1, w2, w3, o1, o2 = 1-fy, fy-fz, fz-fx, fx, dy, dy+dz
+		default:
+			w0, w1, w2, w3, o1, o2 = 1-fy, fy-fx, fx-fz, fz, dy, dx+dy
+		}
+		o3 := dx + dy + dz
+		for c := 0; c < 3; c++ {
+			res[c] = w0*t[c] + w1*t[o1+c] + w2*t[o2+c] + w3*t[o3+c]
+		}
+		return res
+	}
+	for c := 0; c < 3; c++ {
+		c00 := t[c] + (t[dx+c]-t[c])*fx
+		c10 := t[dy+c] + (t[dx+dy+c]-t[dy+c])*fx
+		c01 := t[dz+c] + (t[dx+dz+c]-t[dz+c])*fx
+		c11 := t[dy+dz+c] + (t[dx+dy+dz+c]-t[dy+dz+c])*fx
+		c0 := c00 + (c10-c00)*fy
+		c1 := c01 + (c11-c01)*fy
+		res[c] = c0 + (c1-c0)*fz
+	}
+	return res
+}
+
+// lutCell returns the index of the grid cell of a coordinate.
+//
+// The last point belongs to the last cell.
+func lutCell(v float32, size int) int {
+	i := int(v)
+	if i >= size-1 {
+		i = size - 2
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+// apply applies the LUT to non-premultiplied 16-bit values.
+func (l *LUT) apply(r, g, b uint32) (uint32, uint32, uint32) {
+	vs := [3]uint32{r, g, b}
+	for c, cv := range l.Curves {
+		if cv != nil {
+			vs[c] = uint32(cv[vs[c]])
+		}
+	}
+	if !l.has3D() {
+		return vs[0], vs[1], vs[2]
+	}
+	s := float32(l.Size-1) / 0xffff
+	res := l.lookup(float32(vs[0])*s, float32(vs[1])*s, float32(vs[2])*s)
+	return float32ToUint16(res[0]), float32ToUint16(res[1]), float32ToUint16(res[2])
+}
+
+// ApplyLUT applies a LUT to src, and writes the result to dst.
+//
+// It processes the intersection of the bounds of dst and src.
+// dst and src can be the same image.
+// The alpha is not changed.
+// There are fast paths for RGBA and NRGBA images, if dst and src have the same type: the tables are indexed by the 8-bit values.
+// Otherwise, the values are read with NewAtFunc and written with NewSetFunc.
+// If opts is nil, the default options are used.
+func ApplyLUT(dst draw.Image, src image.Image, l *LUT, opts *ToneOptions) {
+	switch dst := dst.(type) {
+	case *image.NRGBA:
+		if src, ok := src.(*image.NRGBA); ok {
+			applyLUTPix(dst.Pix, dst.Stride, dst.Rect, src.Pix, src.Stride, src.Rect, false, l, opts)
+			return
+		}
+	case *image.RGBA:
+		if src, ok := src.(*image.RGBA); ok {
+			applyLUTPix(dst.Pix, dst.Stride, dst.Rect, src.Pix, src.Stride, src.Rect, true, l, opts)
+			return
+		}
+	}
+	toneApply(dst, src, opts, func(x, y int, r, g, b, a uint32) (uint32, uint32, uint32) {
+		return l.apply(r, g, b)
+	})
+}
+
+// applyLUTPix applies a LUT to 8-bit RGBA (premultiplied) or NRGBA pixels.
+//
+// The transparent pixels become transparent black, and the premultiplied pixels that are not opaque use the 16-bit path.
+func applyLUTPix(dst []uint8, dstStride int, dstRect image.Rectangle, src []uint8, srcStride int, srcRect image.Rectangle, premultiplied bool, l *LUT, opts *ToneOptions) {
+	if opts == nil {
+		opts = new(ToneOptions)
+	}
+	r := dstRect.Intersect(srcRect)
+	if r.Empty() {
+		return
+	}
+	// The 1D curves, and the grid coordinates, for each 8-bit value.
+	var curves [3][256]uint8
+	var coords [3][256]float32
+	s := float32(l.Size-1) / 0xffff
+	for c, cv := range l.Curves {
+		for i := 0; i < 256; i++ {
+			v := uint32(i) * 0x101
+			if cv != nil {
+				v = uint32(cv[v])
+			}
+			curves[c][i] = uint8(v >> 8)
+			coords[c][i] = float32(v) * s
+		}
+	}
+	has3D := l.has3D()
+	opts.Parallel.Run(r, func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			di := (y-dstRect.Min.Y)*dstStride + (r.Min.X-dstRect.Min.X)*4
+			si := (y-srcRect.Min.Y)*srcStride + (r.Min.X-srcRect.Min.X)*4
+			d := dst[di : di+r.Dx()*4]
+			sp := src[si : si+r.Dx()*4]
+			for i := 0; i < len(d); i += 4 {
+				s := sp[i : i+4 : i+4]
+				a := s[3]
+				switch {
+				case a == 0:
+					d[i], d[i+1], d[i+2], d[i+3] = 0, 0, 0, 0
+					continue
+				case premultiplied && a != 0xff:
+					cr, cg, cb, ca := RGBAToNRGBA(uint32(s[0])*0x101, uint32(s[1])*0x101, uint32(s[2])*0x101, uint32(a)*0x101)
+					cr, cg, cb = l.apply(cr, cg, cb)
+					cr, cg, cb, _ = NRGBAToRGBA(cr, cg, cb, ca)
+					d[i], d[i+1], d[i+2], d[i+3] = uint8(cr>>8), uint8(cg>>8), uint8(cb>>8), a
+					continue
+				}
+				if !has3D {
+					d[i], d[i+1], d[i+2], d[i+3] = curves[0][s[0]], curves[1][s[1]], curves[2][s[2]], a
+					continue
+				}
+				res := l.lookup(coords[0][s[0]], coords[1][s[1]], coords[2][s[2]])
+				d[i], d[i+1], d[i+2], d[i+3] = float32ToUint8(res[0]), float32ToUint8(res[1]), float32ToUint8(res[2]), a
+			}
+		}
+	})
+}
+
+// ErrCubeFormat is returned when a .cube file is invalid.
+var ErrCubeFormat = errors.New("invalid cube format")
+
+// ParseCube parses a LUT in the Adobe/Resolve .cube format.
+//
+// It supports a 1D table (LUT_1D_SIZE), a 3D table (LUT_3D_SIZE), or both (the 1D table is applied first, as a shaper).
+// The input domain is defined by DOMAIN_MIN and DOMAIN_MAX (for the first table), or LUT_1D_INPUT_RANGE and LUT_3D_INPUT_RANGE.
+// The 1D table is interpolated linearly, and compiled to 16-bit curves, including the domain of the 3D table.
+// The unknown keywords are ignored.
+// The returned errors wrap ErrCubeFormat.
+func ParseCube(rd io.Reader) (*LUT, error) {
+	var size1D, size3D int
+	domain1D := [2][3]float64{{0, 0, 0}, {1, 1, 1}}
+	domain3D := domain1D
+	// The DOMAIN_MIN and DOMAIN_MAX values, which are assigned after the header is parsed, because they can be before the sizes.
+	var domain [2][3]float64
+	var hasDomain [2]bool
+	var data []float64
+	sc := bufio.NewScanner(rd)
+	line := 0
+	for sc.Scan() {
+		line++
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var err error
+		switch fields[0] {
+		case "TITLE":
+		case "LUT_1D_SIZE":
+			size1D, err = parseCubeSize(fields, 65536)
+		case "LUT_3D_SIZE":
+			size3D, err = parseCubeSize(fields, 256)
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			i := 0
+			if fields[0] == "DOMAIN_MAX" {
+				i = 1
+			}
+			var vs []float64
+			vs, err = parseCubeFloats(fields[1:], 3)
+			if err == nil {
+				copy(domain[i][:], vs)
+				hasDomain[i] = true
+			}
+		case "LUT_1D_INPUT_RANGE", "LUT_3D_INPUT_RANGE":
+			var vs []float64
+			vs, err = parseCubeFloats(fields[1:], 2)
+			if err == nil {
+				d := &domain1D
+				if fields[0] == "LUT_3D_INPUT_RANGE" {
+					d = &domain3D
+				}
+				*d = [2][3]float64{{vs[0], vs[0], vs[0]}, {vs[1], vs[1], vs[1]}}
+			}
+		default:
+			if !isCubeNumber(fields[0]) {
+				continue
+			}
+			var vs []float64
+			vs, err = parseCubeFloats(fields, 3)
+			data = append(data, vs...)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %s: %v", ErrCubeFormat, line, fields[0], err)
+		}
+	}
+	err := sc.Err()
+	if err != nil {
+		return nil, err
+	}
+	if size1D == 0 && size3D == 0 {
+		return nil, fmt.Errorf("%w: missing size", ErrCubeFormat)
+	}
+	// The domain applies to the first table.
+	d := &domain3D
+	if size1D > 0 {
+		d = &domain1D
+	}
+	for i, ok := range hasDomain {
+		if ok {
+			d[i] = domain[i]
+		}
+	}
+	expected := size1D + size3D*size3D*size3D
+	if len(data) != expected*3 {
+		return nil, fmt.Errorf("%w: got %d entries, want %d", ErrCubeFormat, len(data)/3, expected)
+	}
+	for c := 0; c < 3; c++ {
+		if domain1D[1][c] <= domain1D[0][c] || domain3D[1][c] <= domain3D[0][c] {
+			return nil, fmt.Errorf("%w: invalid domain", ErrCubeFormat)
+		}
+	}
+	l := new(LUT)
+	if size3D > 0 {
+		l.Size = size3D
+		l.Table = make([]float32, size3D*size3D*size3D*3)
+		for i, v := range data[size1D*3:] {
+			l.Table[i] = float32(v)
+		}
+	}
+	if size1D > 0 || domain3D != [2][3]float64{{0, 0, 0}, {1, 1, 1}} {
+		for c := range l.Curves {
+			c := c
+			l.Curves[c] = newToneCurveFunc(func(v float64) float64 {
+				if size1D > 0 {
+					x := clampFloat((v-domain1D[0][c])/(domain1D[1][c]-domain1D[0][c])) * float64(size1D-1)
+					i := int(x)
+					if i >= size1D-1 {
+						i = size1D - 2
+					}
+					v0, v1 := data[i*3+c], data[(i+1)*3+c]
+					v = v0 + (v1-v0)*(x-float64(i))
+				}
+				if size3D > 0 {
+					v = (v - domain3D[0][c]) / (domain3D[1][c] - domain3D[0][c])
+				}
+				return v
+			})
+		}
+	}
+	return l, nil
+}
+
+func parseCubeSize(fields []string, max int) (int, error) {
+	if len(fields) != 2 {
+		return 0, errors.New("invalid number of values")
+	}
+	n, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, err
+	}
+	if n < 2 || n > max {
+		return 0, fmt.Errorf("size %d not in [2, %d]", n, max)
+	}
+	return n, nil
+}
+
+func parseCubeFloats(fields []string, n int) ([]float64, error) {
+	if len(fields) != n {
+		return nil, fmt.Errorf("got %d values, want %d", len(fields), n)
+	}
+	vs := make([]float64, n)
+	for i, f := range fields {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, err
+		}
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid value %q", f)
+		}
+		vs[i] = v
+	}
+	return vs, nil
+}
+
+func isCubeNumber(s string) bool {
+	c := s[0]
+	return (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.'
+}
@@ -0,0 +1,270 @@
+package imageutil
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+	"testing"
+)
+
+var testLUTInterpolations = []LUTInterpolation{
+	LUTTrilinear,
+	LUTTetrahedral,
+}
+
+// testLUTFunc is an affine function, so it is interpolated exactly.
+func testLUTFunc(r, g, b float64) (float64, float64, float64) {
+	return b, 0.5*r + 0.25, 1 - g
+}
+
+func TestApplyLUT3D(t *testing.T) {
+	src := testNewRandomImage(image.Rect(-3, 2, 20, 15))
+	for _, interp := range testLUTInterpolations {
+		l := NewLUT3D(5, testLUTFunc)
+		l.Interpolation = interp
+		for _, tc := range []struct {
+			newImage func(image.Rectangle) draw.Image
+			// shift is the number of bits removed from the 16-bit values, so they are compared at the precision of the image.
+			shift uint
+			// premultiplied is true if the image stores premultiplied values.
+			// The unpremultiplied values of a premultiplied 8-bit image are imprecise with a low alpha, so they are not compared.
+			premultiplied bool
+		}{
+			{func(r image.Rectangle) draw.Image { return image.NewNRGBA(r) }, 8, false},
+			{func(r image.Rectangle) draw.Image { return image.NewRGBA(r) }, 8, true},
+			{func(r image.Rectangle) draw.Image { return image.NewRGBA64(r) }, 0, true},
+		} {
+			p := tc.newImage(src.Bounds())
+			draw.Draw(p, p.Bounds(), src, src.Bounds().Min, draw.Src)
+			t.Run(fmt.Sprintf("%d/%T", interp, p), func(t *testing.T) {
+				dst := tc.newImage(p.Bounds())
+				ApplyLUT(dst, p, l, &ToneOptions{Parallel: &Parallel{TileHeight: 3, MinTileSize: 1}})
+				at, dstAt := NewAtFunc(p), NewAtFunc(dst)
+				bd := p.Bounds()
+				for y := bd.Min.Y; y < bd.Max.Y; y++ {
+					for x := bd.Min.X; x < bd.Max.X; x++ {
+						r, g, b, a := RGBAToNRGBA(at(x, y))
+						rf, gf, bf := testLUTFunc(float64(r)/0xffff, float64(g)/0xffff, float64(b)/0xffff)
+						er, eg, eb, ea := floatToUint16(rf), floatToUint16(gf), floatToUint16(bf), a
+						dr, dg, db, da := dstAt(x, y)
+						if tc.premultiplied {
+							er, eg, eb, ea = NRGBAToRGBA(er, eg, eb, ea)
+						} else {
+							if a == 0 {
+								continue
+							}
+							dr, dg, db, da = RGBAToNRGBA(dr, dg, db, da)
+						}
+						got := []uint32{dr >> tc.shift, dg >> tc.shift, db >> tc.shift, da >> tc.shift}
+						want := []uint32{er >> tc.shift, eg >> tc.shift, eb >> tc.shift, ea >> tc.shift}
+						for i := range got {
+							if testAbsDiff(got[i], want[i]) > 1 {
+								t.Fatalf("unexpected color at (%d, %d): got %v, want %v", x, y, got, want)
+							}
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestApplyLUTFastPath(t *testing.T) {
+	src := testNewOpaqueRandomImage(image.Rect(0, 0, 20, 15))
+	l := NewLUT3D(9, func(r, g, b float64) (float64, float64, float64) {
+		return r * r, math.Sqrt(g), (r + g + b) / 3
+	})
+	l.Curves[1] = NewLevelsCurve(0.1, 0.9, 1)
+	for _, interp := range testLUTInterpolations {
+		l.Interpolation = interp
+		dst := image.NewNRGBA(src.Bounds())
+		ApplyLUT(dst, src, l, nil)
+		// The Crop view uses the generic path.
+		expected := image.NewNRGBA(src.Bounds())
+		ApplyLUT(expected, &Crop{Image: src, Rect: src.Bounds()}, l, nil)
+		for i, v := range dst.Pix {
+			if testAbsDiff(uint32(v), uint32(expected.Pix[i])) > 1 {
+				t.Fatalf("unexpected value at %d: got %d, want %d", i, v, expected.Pix[i])
+			}
+		}
+	}
+}
+
+func TestApplyLUT1DInPlace(t *testing.T) {
+	p := testNewOpaqueRandomImage(image.Rect(0, 0, 20, 15))
+	c := NewLevelsCurve(0.2, 0.7, 1.3)
+	expected := image.NewNRGBA(p.Bounds())
+	ApplyCurves(expected, p, c, nil, c, nil)
+	ApplyLUT(p, p, &LUT{Curves: [3]*ToneCurve{c, nil, c}}, nil)
+	for i, v := range p.Pix {
+		if v != expected.Pix[i] {
+			t.Fatalf("unexpected value at %d: got %d, want %d", i, v, expected.Pix[i])
+		}
+	}
+}
+
+func TestLUTTetrahedralNeutral(t *testing.T) {
+	l := NewLUT3D(3, func(r, g, b float64) (float64, float64, float64) {
+		return r * g, g * b, b * r
+	})
+	l.Interpolation = LUTTetrahedral
+	for _, v := range []uint32{0, 0x1234, 0x8000, 0xc000, 0xffff} {
+		r, g, b := l.apply(v, v, v)
+		// On the neutral axis, only the diagonal points of the grid are used.
+		vf := float64(v) / 0xffff
+		i := math.Min(math.Floor(vf*2), 1)
+		f := vf*2 - i
+		p0, p1 := i/2*i/2, (i+1)/2*(i+1)/2
+		expected := floatToUint16(p0 + (p1-p0)*f)
+		if testAbsDiff(r, expected) > 1 || r != g || g != b {
+			t.Fatalf("unexpected value for %d: got %d %d %d, want %d", v, r, g, b, expected)
+		}
+	}
+}
+
+func TestParseCube3D(t *testing.T) {
+	s := `# Created by hand
+TITLE "Swap"
+LUT_3D_SIZE 2
+
+DOMAIN_MIN 0 0 0
+DOMAIN_MAX 1 1 1
+0 0 0
+0 0 1
+0 1 0
+0 1 1
+1 0 0
+1 0 1
+1 1 0
+1 1 1
+`
+	l, err := ParseCube(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Size != 2 || l.Curves != [3]*ToneCurve{} {
+		t.Fatalf("unexpected LUT: %+v", l)
+	}
+	// The red and blue channels are swapped.
+	r, g, b := l.apply(0x1000, 0x2000, 0x3000)
+	if r != 0x3000 || g != 0x2000 || b != 0x1000 {
+		t.Fatalf("unexpected values: got %#x %#x %#x", r, g, b)
+	}
+}
+
+func TestParseCube1D(t *testing.T) {
+	s := `LUT_1D_SIZE 3
+LUT_1D_INPUT_RANGE 0 2
+0 1 0
+0.5 0.5 0.25
+1 0 1
+`
+	l, err := ParseCube(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Size != 0 {
+		t.Fatalf("unexpected 3D size: %d", l.Size)
+	}
+	// The input 0.5 is at 1/4 of the domain.
+	r, g, b := l.apply(0x8000, 0x8000, 0x8000)
+	for i, tc := range []struct {
+		v        uint32
+		expected float64
+	}{
+		{r, 0.25},
+		{g, 0.75},
+		{b, 0.125},
+	} {
+		if math.Abs(float64(tc.v)/0xffff-tc.expected) > 0.001 {
+			t.Fatalf("unexpected value for channel %d: got %f, want %f", i, float64(tc.v)/0xffff, tc.expected)
+		}
+	}
+}
+
+func TestParseCubeDomainBeforeSize(t *testing.T) {
+	// The DOMAIN_MIN and DOMAIN_MAX keywords are before LUT_1D_SIZE.
+	s := `DOMAIN_MIN 0 0 0
+DOMAIN_MAX 2 2 2
+LUT_1D_SIZE 2
+0 0 0
+1 1 1
+`
+	l, err := ParseCube(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The input 0.5 is at 1/4 of the domain.
+	r, g, b := l.apply(0x8000, 0x8000, 0x8000)
+	for i, v := range []uint32{r, g, b} {
+		if math.Abs(float64(v)/0xffff-0.25) > 0.001 {
+			t.Fatalf("unexpected value for channel %d: got %f, want %f", i, float64(v)/0xffff, 0.25)
+		}
+	}
+}
+
+func TestParseCubeShaper(t *testing.T) {
+	// The 1D table is a shaper that doubles the values, and the 3D domain is [0, 2].
+	s := `LUT_1D_SIZE 2
+LUT_3D_SIZE 2
+LUT_1D_INPUT_RANGE 0 1
+LUT_3D_INPUT_RANGE 0 2
+0 0 0
+2 2 2
+0 0 0
+1 0 0
+0 1 0
+1 1 0
+0 0 1
+1 0 1
+0 1 1
+1 1 1
+`
+	l, err := ParseCube(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, g, b := l.apply(0x1000, 0x2000, 0x3000)
+	if testAbsDiff(r, 0x1000) > 1 || testAbsDiff(g, 0x2000) > 1 || testAbsDiff(b, 0x3000) > 1 {
+		t.Fatalf("unexpected values: got %#x %#x %#x", r, g, b)
+	}
+}
+
+func TestParseCubeError(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"LUT_3D_SIZE 1\n",
+		"LUT_3D_SIZE a\n",
+		"LUT_3D_SIZE 2\n0 0 0\n",
+		"LUT_1D_SIZE 2\n0 0 0\n1 1\n",
+		"LUT_1D_SIZE 2\n0 0 0\n1 1 x\n",
+		"LUT_1D_SIZE 2\nDOMAIN_MIN 1 1 1\nDOMAIN_MAX 0 0 0\n0 0 0\n1 1 1\n",
+	} {
+		_, err := ParseCube(strings.NewReader(s))
+		if !errors.Is(err, ErrCubeFormat) {
+			t.Fatalf("unexpected error for %q: got %v, want %v", s, err, ErrCubeFormat)
+		}
+	}
+}
+
+func BenchmarkApplyLUT(b *testing.B) {
+	src := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	for _, interp := range testLUTInterpolations {
+		l := NewLUT3D(33, testLUTFunc)
+		l.Interpolation = interp
+		for _, p := range []draw.Image{
+			image.NewNRGBA(src.Bounds()),
+			image.NewRGBA64(src.Bounds()),
+		} {
+			draw.Draw(p, p.Bounds(), src, src.Bounds().Min, draw.Src)
+			b.Run(fmt.Sprintf("%d/%T", interp, p), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					ApplyLUT(p, p, l, nil)
+				}
+			})
+		}
+	}
+}
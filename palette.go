@@ -0,0 +1,187 @@
+package imageutil
+
+import (
+	"image"
+	"sort"
+	"sync/atomic"
+)
+
+// NewSetFuncPalettedCache returns a SetFunc for a Paletted image, with a cache of the nearest palette colors.
+//
+// cacheSize is the maximum number of cached colors, it is rounded up to a power of 2.
+// Only the colors with 8 bits precision (e.g. read from an 8 bits image) are cached.
+// If cacheSize is lower than or equal to 0, the cache is disabled.
+//
+// The returned SetFunc is safe for concurrent use.
+func NewSetFuncPalettedCache(p *image.Paletted, cacheSize int) SetFunc {
+	pi := newPaletteIndex(newPaletteRGBA(p.Palette), cacheSize)
+	return func(x, y int, r, g, b, a uint32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		p.Pix[i] = uint8(pi.index(colorRGBA{r, g, b, a}))
+	}
+}
+
+// paletteIndexTreeMinSize is the minimum palette size for which a k-d tree is used.
+// For smaller palettes, a linear scan is faster.
+const paletteIndexTreeMinSize = 16
+
+// paletteIndex finds the nearest color in a palette.
+//
+// It returns exactly the same results as paletteRGBA.index.
+type paletteIndex struct {
+	pa    paletteRGBA
+	tree  []paletteTreeNode
+	cache []uint64
+	shift uint
+}
+
+func newPaletteIndex(pa paletteRGBA, cacheSize int) *paletteIndex {
+	pi := &paletteIndex{
+		pa: pa,
+	}
+	if len(pa) >= paletteIndexTreeMinSize {
+		pi.tree = newPaletteTree(pa)
+	}
+	if cacheSize > 0 {
+		bits := uint(0)
+		for 1<<bits < cacheSize && bits < 32 {
+			bits++
+		}
+		pi.cache = make([]uint64, 1<<bits)
+		pi.shift = 32 - bits
+	}
+	return pi
+}
+
+func (pi *paletteIndex) index(c colorRGBA) int {
+	if pi.cache == nil {
+		return pi.lookup(c)
+	}
+	key, ok := paletteCacheKey(c)
+	if !ok {
+		return pi.lookup(c)
+	}
+	slot := &pi.cache[(key*0x9e3779b1)>>pi.shift]
+	e := atomic.LoadUint64(slot)
+	if e != 0 && uint32(e>>32) == key {
+		return int(uint32(e)) - 1
+	}
+	i := pi.lookup(c)
+	atomic.StoreUint64(slot, uint64(key)<<32|uint64(i+1))
+	return i
+}
+
+func (pi *paletteIndex) lookup(c colorRGBA) int {
+	if pi.tree == nil {
+		return pi.pa.index(c)
+	}
+	s := paletteTreeSearch{
+		c:       [4]uint32{c.r, c.g, c.b, c.a},
+		best:    0,
+		bestSum: 1<<32 - 1,
+	}
+	s.search(pi.tree, 0)
+	return s.best
+}
+
+// paletteCacheKey returns the cache key of a color.
+//
+// It returns false if the color doesn't have 8 bits precision, because the key would be ambiguous.
+func paletteCacheKey(c colorRGBA) (uint32, bool) {
+	for _, v := range [4]uint32{c.r, c.g, c.b, c.a} {
+		if v > 0xffff || v>>8 != v&0xff {
+			return 0, false
+		}
+	}
+	return (c.r>>8)<<24 | (c.g>>8)<<16 | (c.b>>8)<<8 | c.a>>8, true
+}
+
+// paletteTreeNode is a node of a k-d tree of palette colors.
+//
+// The tree is stored in a slice, and the root is at index 0.
+type paletteTreeNode struct {
+	c           [4]uint32
+	index       int
+	axis        int
+	left, right int
+}
+
+func newPaletteTree(pa paletteRGBA) []paletteTreeNode {
+	items := make([]paletteTreeNode, len(pa))
+	for i, c := range pa {
+		items[i] = paletteTreeNode{
+			c:     [4]uint32{c.r, c.g, c.b, c.a},
+			index: i,
+		}
+	}
+	tree := make([]paletteTreeNode, 0, len(pa))
+	buildPaletteTree(&tree, items)
+	return tree
+}
+
+// buildPaletteTree appends the nodes of the sub-tree containing items, and returns the index of its root, or -1 if it's empty.
+func buildPaletteTree(tree *[]paletteTreeNode, items []paletteTreeNode) int {
+	if len(items) == 0 {
+		return -1
+	}
+	axis, maxSpread := 0, uint32(0)
+	for k := 0; k < 4; k++ {
+		min, max := uint32(1<<32-1), uint32(0)
+		for _, it := range items {
+			if it.c[k] < min {
+				min = it.c[k]
+			}
+			if it.c[k] > max {
+				max = it.c[k]
+			}
+		}
+		if max-min > maxSpread {
+			axis, maxSpread = k, max-min
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].c[axis] != items[j].c[axis] {
+			return items[i].c[axis] < items[j].c[axis]
+		}
+		return items[i].index < items[j].index
+	})
+	m := len(items) / 2
+	n := len(*tree)
+	node := items[m]
+	node.axis = axis
+	*tree = append(*tree, node)
+	left := buildPaletteTree(tree, items[:m])
+	right := buildPaletteTree(tree, items[m+1:])
+	(*tree)[n].left = left
+	(*tree)[n].right = right
+	return n
+}
+
+type paletteTreeSearch struct {
+	c       [4]uint32
+	best    int
+	bestSum uint32
+}
+
+// search searches the nearest color in the sub-tree rooted at n.
+//
+// Ties are resolved by the lowest palette index, like paletteRGBA.index.
+func (s *paletteTreeSearch) search(tree []paletteTreeNode, n int) {
+	node := &tree[n]
+	sum := sqDiff(s.c[0], node.c[0]) + sqDiff(s.c[1], node.c[1]) + sqDiff(s.c[2], node.c[2]) + sqDiff(s.c[3], node.c[3])
+	if sum < s.bestSum || (sum == s.bestSum && node.index < s.best) {
+		s.best, s.bestSum = node.index, sum
+	}
+	v, split := s.c[node.axis], node.c[node.axis]
+	near, far := node.left, node.right
+	if v >= split {
+		near, far = far, near
+	}
+	if near >= 0 {
+		s.search(tree, near)
+	}
+	// The colors on the other side of the split are at least at this distance.
+	if far >= 0 && sqDiff(v, split) <= s.bestSum {
+		s.search(tree, far)
+	}
+}
@@ -0,0 +1,76 @@
+package imageutil
+
+import (
+	"fmt"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+func TestPaletteIndex(t *testing.T) {
+	for _, size := range []int{1, 5, 16, 17, 64, 256} {
+		for _, cacheSize := range []int{0, 100} {
+			t.Run(fmt.Sprintf("Size%dCache%d", size, cacheSize), func(t *testing.T) {
+				pl := make(color.Palette, size)
+				for i := range pl {
+					pl[i] = testRandomColor()
+				}
+				// Duplicate colors test the ties resolution.
+				if size > 1 {
+					pl[size-1] = pl[0]
+				}
+				pa := newPaletteRGBA(pl)
+				pi := newPaletteIndex(pa, cacheSize)
+				var cs []colorRGBA
+				for _, c := range testColors {
+					r, g, b, a := c.RGBA()
+					cs = append(cs, colorRGBA{r, g, b, a})
+				}
+				for _, c := range pa {
+					cs = append(cs, c)
+				}
+				for i := 0; i < 1000; i++ {
+					a := uint32(rand.Intn(1 << 16))
+					cs = append(cs, colorRGBA{uint32(rand.Intn(int(a) + 1)), uint32(rand.Intn(int(a) + 1)), uint32(rand.Intn(int(a) + 1)), a})
+				}
+				for pass := 0; pass < 2; pass++ {
+					for _, c := range cs {
+						got := pi.index(c)
+						want := pa.index(c)
+						if got != want {
+							t.Fatalf("different index: color %v: got %d, want %d", c, got, want)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkPaletteIndex(b *testing.B) {
+	pl := make(color.Palette, 256)
+	for i := range pl {
+		pl[i] = testRandomColor()
+	}
+	pa := newPaletteRGBA(pl)
+	c := colorRGBA{0x8080, 0x4040, 0xc0c0, 0xffff}
+	b.Run("Linear", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			pa.index(c)
+		}
+	})
+	b.Run("Tree", func(b *testing.B) {
+		pi := newPaletteIndex(pa, 0)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			pi.index(c)
+		}
+	})
+	b.Run("Cache", func(b *testing.B) {
+		pi := newPaletteIndex(pa, 1024)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			pi.index(c)
+		}
+	})
+}
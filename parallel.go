@@ -1,69 +1,404 @@
 package imageutil
 
 import (
+	"context"
+	"fmt"
 	"image"
 	"runtime"
+	"runtime/debug"
 	"sync"
 )
 
 // Parallel1D dispatches tasks concurrently for a Rectangle.
 //
-// It splits the image horizontally in GOMAXPROCS parts and runs GOMAXPROCS workers.
+// It is a shortcut for the zero value of Parallel:
+// it splits the image horizontally in up to GOMAXPROCS balanced strips and runs GOMAXPROCS workers.
+// The strips have at least DefaultParallelMinTileSize pixels, and a small image is processed inline, without goroutines.
 //
 // It should be used if all the pixels of the image have the same process cost.
 func Parallel1D(r image.Rectangle, f func(image.Rectangle)) {
-	p := runtime.GOMAXPROCS(0)
-	wg := new(sync.WaitGroup)
-	for y := 0; y < p; y++ {
-		rr := image.Rect(
-			r.Min.X,
-			r.Min.Y+(r.Dy()*y/p),
-			r.Max.X,
-			r.Min.Y+(r.Dy()*(y+1)/p),
-		)
-		if !rr.Empty() {
-			wg.Add(1)
-			go func(rr image.Rectangle) {
-				f(rr)
-				wg.Done()
-			}(rr)
-		}
-	}
-	wg.Wait()
+	new(Parallel).Run(r, f)
 }
 
 // Parallel2D dispatches tasks concurrently for a Rectangle.
 //
-// It splits the image in a GOMAXPROCS x GOMAXPROCS grid
-// and runs GOMAXPROCS workers.
+// It runs a Parallel with tiles of 1/GOMAXPROCS of the width and height of the image (up to a GOMAXPROCS x GOMAXPROCS grid),
+// and GOMAXPROCS workers.
+// The tiles have at least DefaultParallelMinTileSize pixels, and a small image is processed inline, without goroutines.
 //
 // It should be used if all the pixels of the image don't have the same process cost.
 func Parallel2D(r image.Rectangle, f func(image.Rectangle)) {
+	newParallel2D(r).Run(r, f)
+}
+
+// Parallel1DContext is like Parallel1D, but it supports cancellation and errors.
+//
+// See Parallel.RunContext.
+func Parallel1DContext(ctx context.Context, r image.Rectangle, f func(context.Context, image.Rectangle) error) error {
+	return new(Parallel).RunContext(ctx, r, f)
+}
+
+// Parallel2DContext is like Parallel2D, but it supports cancellation and errors.
+//
+// See Parallel.RunContext.
+func Parallel2DContext(ctx context.Context, r image.Rectangle, f func(context.Context, image.Rectangle) error) error {
+	return newParallel2D(r).RunContext(ctx, r, f)
+}
+
+func newParallel2D(r image.Rectangle) *Parallel {
 	p := runtime.GOMAXPROCS(0)
-	rc := make(chan image.Rectangle)
+	return &Parallel{
+		TileWidth:  ceilDiv(r.Dx(), p),
+		TileHeight: ceilDiv(r.Dy(), p),
+	}
+}
+
+// Parallel dispatches tasks concurrently for the tiles of a Rectangle.
+//
+// The zero value splits the Rectangle horizontally in up to GOMAXPROCS balanced strips and runs GOMAXPROCS workers.
+// A nil *Parallel is valid and equivalent to the zero value, so the options structs can leave it unset.
+type Parallel struct {
+	// Workers is the number of workers.
+	// If it's lower than or equal to 0, GOMAXPROCS is used.
+	// With a Pool, it is the maximum number of tiles of a call that run concurrently, and the pool's size is the global limit.
+	Workers int
+	// TileWidth is the width of the tiles.
+	// If it's lower than or equal to 0, the tiles have the width of the Rectangle.
+	TileWidth int
+	// TileHeight is the height of the tiles.
+	// If it's lower than or equal to 0, the Rectangle is split horizontally in up to Workers strips, whose heights differ by at most 1.
+	TileHeight int
+	// MinTileSize is the minimum number of pixels of a tile.
+	// Smaller tiles are enlarged, and a Rectangle that is not larger than it is processed inline, without goroutines.
+	// If it's 0, DefaultParallelMinTileSize is used.
+	// If it's negative, there is no minimum.
+	MinTileSize int
+	// Order is the order in which the tiles are dispatched.
+	Order ParallelOrder
+	// Pool is the WorkerPool that runs the tasks.
+	// If it's nil, new goroutines are started for each call.
+	// If it's not nil and Workers is lower than or equal to 0, the number of workers of the pool is used.
+	Pool *WorkerPool
+}
+
+// DefaultParallelMinTileSize is the default value of Parallel.MinTileSize.
+const DefaultParallelMinTileSize = 1 << 10
+
+// ParallelOrder is the order in which the tiles are dispatched.
+type ParallelOrder int
+
+const (
+	// ParallelOrderRowMajor dispatches the tiles row by row, from left to right.
+	ParallelOrderRowMajor ParallelOrder = iota
+	// ParallelOrderSerpentine dispatches the tiles row by row, alternating left to right and right to left.
+	// Consecutive tiles are always adjacent, which is cache-friendly for tasks reading neighbor pixels.
+	ParallelOrderSerpentine
+	// ParallelOrderHilbert dispatches the tiles along a Hilbert curve.
+	// Concurrent tiles are close to each other in both dimensions.
+	ParallelOrderHilbert
+)
+
+// Run calls f concurrently for each tile of r.
+//
+// If f panics, the panic is propagated to the caller of Run, as a *PanicError that keeps the stack of the original panic.
+// It panics if the WorkerPool is closed.
+func (p *Parallel) Run(r image.Rectangle, f func(image.Rectangle)) {
+	err := p.RunContext(context.Background(), r, func(ctx context.Context, rr image.Rectangle) error {
+		f(rr)
+		return nil
+	})
+	repanic(err)
+}
+
+// repanic panics with a non-nil error.
+//
+// A *PanicError is not unwrapped, so the crash report shows the stack of the original panic.
+func repanic(err error) {
+	if err == nil {
+		return
+	}
+	panic(err)
+}
+
+// RunContext calls f concurrently for each tile of r.
+//
+// It stops dispatching new tiles after the first error or if the context is canceled.
+// It returns the first error, or the context error if the context was canceled before all tiles were dispatched.
+// A panic in f is recovered and returned as a *PanicError.
+func (p *Parallel) RunContext(ctx context.Context, r image.Rectangle, f func(context.Context, image.Rectangle) error) error {
+	p = p.orDefault()
+	rs := p.tiles(r)
+	return p.run(ctx, len(rs), func(ctx context.Context, i int) error {
+		return f(ctx, rs[i])
+	})
+}
+
+// orDefault returns p, or the zero value if p is nil.
+func (p *Parallel) orDefault() *Parallel {
+	if p == nil {
+		return new(Parallel)
+	}
+	return p
+}
+
+// run runs the n tasks, identified by their index.
+//
+// A single task runs inline, without goroutines, even with a WorkerPool.
+func (p *Parallel) run(ctx context.Context, n int, f func(context.Context, int) error) error {
+	if n <= 1 {
+		return runInline(ctx, n, f)
+	}
+	if p.Pool != nil {
+		return p.Pool.run(ctx, n, p.Workers, f)
+	}
+	workers := p.workers()
+	if workers > n {
+		workers = n
+	}
+	if workers <= 1 {
+		return runInline(ctx, n, f)
+	}
+	return runWorkers(ctx, workers, n, f)
+}
+
+func (p *Parallel) workers() int {
+	if p.Workers > 0 {
+		return p.Workers
+	}
+	if p.Pool != nil {
+		return p.Pool.Workers()
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func (p *Parallel) minTileSize() int {
+	if p.MinTileSize == 0 {
+		return DefaultParallelMinTileSize
+	}
+	return p.MinTileSize
+}
+
+func (p *Parallel) tiles(r image.Rectangle) []image.Rectangle {
+	if r.Empty() {
+		return nil
+	}
+	tw, th := p.tileSize(r)
+	nx, ny := ceilDiv(r.Dx(), tw), ceilDiv(r.Dy(), th)
+	rowY := func(y int) int {
+		return r.Min.Y + y*th
+	}
+	if p.TileHeight <= 0 {
+		// The strips are balanced, so the last worker doesn't get a smaller strip, or none.
+		ny = p.strips(r, tw)
+		rowY = func(y int) int {
+			return r.Min.Y + r.Dy()*y/ny
+		}
+	}
+	rs := make([]image.Rectangle, 0, nx*ny)
+	forEachTile(nx, ny, p.Order, func(x, y int) {
+		rr := image.Rect(
+			r.Min.X+x*tw,
+			rowY(y),
+			r.Min.X+(x+1)*tw,
+			rowY(y+1),
+		).Intersect(r)
+		rs = append(rs, rr)
+	})
+	return rs
+}
+
+// strips returns the number of strips of tiles with the width tw, if TileHeight is not set.
+//
+// It is the number of workers, limited by the height of r and by MinTileSize.
+func (p *Parallel) strips(r image.Rectangle, tw int) int {
+	n := p.workers()
+	if n > r.Dy() {
+		n = r.Dy()
+	}
+	if minSize := p.minTileSize(); minSize > 0 {
+		m := r.Dy() / ceilDiv(minSize, tw)
+		if m < 1 {
+			m = 1
+		}
+		if n > m {
+			n = m
+		}
+	}
+	return n
+}
+
+func (p *Parallel) tileSize(r image.Rectangle) (tw, th int) {
+	tw, th = p.TileWidth, p.TileHeight
+	if tw <= 0 || tw > r.Dx() {
+		tw = r.Dx()
+	}
+	if th <= 0 {
+		th = ceilDiv(r.Dy(), p.workers())
+	}
+	if th > r.Dy() {
+		th = r.Dy()
+	}
+	minSize := p.minTileSize()
+	if tw*th < minSize {
+		th = ceilDiv(minSize, tw)
+		if th > r.Dy() {
+			th = r.Dy()
+		}
+		if tw*th < minSize {
+			tw = ceilDiv(minSize, th)
+			if tw > r.Dx() {
+				tw = r.Dx()
+			}
+		}
+	}
+	return tw, th
+}
+
+// forEachTile calls f for each tile coordinates of a nx x ny grid, in the given order.
+func forEachTile(nx, ny int, o ParallelOrder, f func(x, y int)) {
+	switch o {
+	case ParallelOrderSerpentine:
+		for y := 0; y < ny; y++ {
+			for i := 0; i < nx; i++ {
+				x := i
+				if y%2 == 1 {
+					x = nx - 1 - i
+				}
+				f(x, y)
+			}
+		}
+	case ParallelOrderHilbert:
+		n := 1
+		for n < nx || n < ny {
+			n *= 2
+		}
+		for d := 0; d < n*n; d++ {
+			x, y := hilbertPoint(n, d)
+			if x < nx && y < ny {
+				f(x, y)
+			}
+		}
+	default:
+		for y := 0; y < ny; y++ {
+			for x := 0; x < nx; x++ {
+				f(x, y)
+			}
+		}
+	}
+}
+
+// hilbertPoint returns the coordinates of the point at distance d along a Hilbert curve filling a n x n square.
+//
+// n must be a power of 2.
+func hilbertPoint(n, d int) (x, y int) {
+	for s := 1; s < n; s *= 2 {
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+		if ry == 0 {
+			if rx == 1 {
+				x = s - 1 - x
+				y = s - 1 - y
+			}
+			x, y = y, x
+		}
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+	return x, y
+}
+
+// runInline runs the n tasks sequentially in the current goroutine.
+func runInline(ctx context.Context, n int, f func(context.Context, int) error) error {
+	for i := 0; i < n; i++ {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		err = callParallelTask(ctx, i, f)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runWorkers runs the n tasks concurrently in new goroutines.
+func runWorkers(ctx context.Context, workers int, n int, f func(context.Context, int) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var firstErr error
+	errOnce := new(sync.Once)
+	ic := make(chan int)
 	wg := new(sync.WaitGroup)
-	wg.Add(p)
-	for i := 0; i < p; i++ {
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
 		go func() {
-			for rr := range rc {
-				f(rr)
+			defer wg.Done()
+			for i := range ic {
+				err := callParallelTask(ctx, i, f)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
 			}
-			wg.Done()
 		}()
 	}
-	for y := 0; y < p; y++ {
-		for x := 0; x < p; x++ {
-			rr := image.Rect(
-				r.Min.X+(r.Dx()*x/p),
-				r.Min.Y+(r.Dy()*y/p),
-				r.Min.X+(r.Dx()*(x+1)/p),
-				r.Min.Y+(r.Dy()*(y+1)/p),
-			)
-			if !rr.Empty() {
-				rc <- rr
-			}
+	var ctxErr error
+loop:
+	for i := 0; i < n; i++ {
+		if ctxErr = ctx.Err(); ctxErr != nil {
+			break
+		}
+		select {
+		case ic <- i:
+		case <-ctx.Done():
+			ctxErr = ctx.Err()
+			break loop
 		}
 	}
-	close(rc)
+	close(ic)
 	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctxErr
+}
+
+func callParallelTask(ctx context.Context, i int, f func(context.Context, int) error) (err error) {
+	defer func() {
+		v := recover()
+		if v != nil {
+			err = &PanicError{
+				Value: v,
+				Stack: debug.Stack(),
+			}
+		}
+	}()
+	return f(ctx, i)
+}
+
+// PanicError is returned when a task panics.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error implements error.
+//
+// It contains the stack of the goroutine that panicked.
+func (err *PanicError) Error() string {
+	if len(err.Stack) == 0 {
+		return fmt.Sprintf("panic: %v", err.Value)
+	}
+	return fmt.Sprintf("panic: %v\n\n%s", err.Value, err.Stack)
+}
+
+func ceilDiv(a, b int) int {
+	if b <= 0 {
+		return a
+	}
+	return (a + b - 1) / b
 }
@@ -1,7 +1,13 @@
 package imageutil
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"image"
+	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 )
 
@@ -22,3 +28,218 @@ func TestParallel2D(t *testing.T) {
 		}
 	})
 }
+
+func TestParallelNil(t *testing.T) {
+	r := image.Rect(100, 100, 200, 200)
+	var p *Parallel
+	var count int64
+	p.Run(r, func(sub image.Rectangle) {
+		atomic.AddInt64(&count, int64(sub.Dx()*sub.Dy()))
+	})
+	if count != int64(r.Dx()*r.Dy()) {
+		t.Fatalf("unexpected pixels count: got %d, want %d", count, r.Dx()*r.Dy())
+	}
+}
+
+func TestParallel1DContext(t *testing.T) {
+	testParallelContext(t, Parallel1DContext)
+}
+
+func TestParallel2DContext(t *testing.T) {
+	testParallelContext(t, Parallel2DContext)
+}
+
+func testParallelContext(t *testing.T, parallel func(context.Context, image.Rectangle, func(context.Context, image.Rectangle) error) error) {
+	t.Helper()
+	r := image.Rect(100, 100, 200, 200)
+	t.Run("Success", func(t *testing.T) {
+		var area int64
+		err := parallel(context.Background(), r, func(ctx context.Context, sub image.Rectangle) error {
+			if !sub.In(r) {
+				return fmt.Errorf("%s is not in %s", sub, r)
+			}
+			atomic.AddInt64(&area, int64(sub.Dx()*sub.Dy()))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if area != int64(r.Dx()*r.Dy()) {
+			t.Fatalf("unexpected area: got %d, want %d", area, r.Dx()*r.Dy())
+		}
+	})
+	t.Run("Error", func(t *testing.T) {
+		errTest := errors.New("test")
+		err := parallel(context.Background(), r, func(ctx context.Context, sub image.Rectangle) error {
+			return errTest
+		})
+		if err != errTest {
+			t.Fatalf("unexpected error: got %v, want %v", err, errTest)
+		}
+	})
+	t.Run("Panic", func(t *testing.T) {
+		err := parallel(context.Background(), r, func(ctx context.Context, sub image.Rectangle) error {
+			panic("test")
+		})
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) {
+			t.Fatalf("unexpected error: got %v, want *PanicError", err)
+		}
+		if panicErr.Value != "test" {
+			t.Fatalf("unexpected panic value: got %v, want %v", panicErr.Value, "test")
+		}
+	})
+	t.Run("Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		var called int64
+		err := parallel(ctx, r, func(ctx context.Context, sub image.Rectangle) error {
+			atomic.AddInt64(&called, 1)
+			return nil
+		})
+		if err != context.Canceled {
+			t.Fatalf("unexpected error: got %v, want %v", err, context.Canceled)
+		}
+		if called != 0 {
+			t.Fatalf("unexpected calls: got %d, want 0", called)
+		}
+	})
+}
+
+func TestParallel(t *testing.T) {
+	r := image.Rect(-10, 20, 95, 83)
+	for _, tc := range []struct {
+		name string
+		p    Parallel
+	}{
+		{"Default", Parallel{}},
+		{"Workers", Parallel{Workers: 3, MinTileSize: -1}},
+		{"TileSize", Parallel{TileWidth: 16, TileHeight: 8, MinTileSize: -1}},
+		{"MinTileSize", Parallel{TileWidth: 4, TileHeight: 4, MinTileSize: 100}},
+		{"Serpentine", Parallel{TileWidth: 16, TileHeight: 8, MinTileSize: -1, Order: ParallelOrderSerpentine}},
+		{"Hilbert", Parallel{TileWidth: 16, TileHeight: 8, MinTileSize: -1, Order: ParallelOrderHilbert}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			counts := make([]int32, r.Dx()*r.Dy())
+			tc.p.Run(r, func(sub image.Rectangle) {
+				if !sub.In(r) {
+					t.Errorf("%s is not in %s", sub, r)
+					return
+				}
+				for y := sub.Min.Y; y < sub.Max.Y; y++ {
+					for x := sub.Min.X; x < sub.Max.X; x++ {
+						atomic.AddInt32(&counts[(y-r.Min.Y)*r.Dx()+(x-r.Min.X)], 1)
+					}
+				}
+			})
+			for i, c := range counts {
+				if c != 1 {
+					t.Fatalf("pixel %d processed %d times", i, c)
+				}
+			}
+		})
+	}
+}
+
+func TestParallelOrder(t *testing.T) {
+	r := image.Rect(0, 0, 40, 30)
+	for _, o := range []ParallelOrder{ParallelOrderRowMajor, ParallelOrderSerpentine, ParallelOrderHilbert} {
+		p := &Parallel{TileWidth: 10, TileHeight: 10, MinTileSize: -1, Order: o}
+		rs := p.tiles(r)
+		if len(rs) != 12 {
+			t.Fatalf("order %d: unexpected tiles count: got %d, want 12", o, len(rs))
+		}
+		if o != ParallelOrderSerpentine {
+			continue
+		}
+		for i := 1; i < len(rs); i++ {
+			d := rs[i].Min.Sub(rs[i-1].Min)
+			if d.X*d.X+d.Y*d.Y != 100 {
+				t.Fatalf("order %d: tiles %s and %s are not adjacent", o, rs[i-1], rs[i])
+			}
+		}
+	}
+}
+
+func TestParallelMinTileSize(t *testing.T) {
+	p := &Parallel{TileWidth: 4, TileHeight: 4, MinTileSize: 100}
+	rs := p.tiles(image.Rect(0, 0, 40, 100))
+	for _, rr := range rs {
+		if rr.Dx()*rr.Dy() < p.MinTileSize {
+			t.Fatalf("%s is smaller than %d", rr, p.MinTileSize)
+		}
+	}
+}
+
+func TestParallelBalanced(t *testing.T) {
+	p := &Parallel{Workers: 4, MinTileSize: -1}
+	rs := p.tiles(image.Rect(0, 0, 10, 9))
+	if len(rs) != 4 {
+		t.Fatalf("unexpected tiles count: got %d, want 4", len(rs))
+	}
+	for _, rr := range rs {
+		if rr.Dy() < 2 || rr.Dy() > 3 {
+			t.Fatalf("unbalanced tile: %s", rr)
+		}
+	}
+	// The strips are not smaller than MinTileSize.
+	p = &Parallel{Workers: 4, MinTileSize: 30}
+	rs = p.tiles(image.Rect(0, 0, 10, 9))
+	if len(rs) != 3 {
+		t.Fatalf("unexpected tiles count: got %d, want 3", len(rs))
+	}
+}
+
+func TestParallelInline(t *testing.T) {
+	r := image.Rect(0, 0, 10, 10)
+	calls := 0
+	new(Parallel).Run(r, func(sub image.Rectangle) {
+		calls++
+		if sub != r {
+			t.Fatalf("unexpected tile: got %s, want %s", sub, r)
+		}
+	})
+	if calls != 1 {
+		t.Fatalf("unexpected calls: got %d, want 1", calls)
+	}
+}
+
+func TestParallelInlinePool(t *testing.T) {
+	wp := NewWorkerPool(2)
+	defer wp.Close()
+	p := &Parallel{Pool: wp}
+	// The tile runs in the goroutine of the caller, so the caller is in the stack.
+	p.Run(image.Rect(0, 0, 10, 10), func(sub image.Rectangle) {
+		buf := make([]byte, 1<<16)
+		buf = buf[:runtime.Stack(buf, false)]
+		if !strings.Contains(string(buf), "TestParallelInlinePool") {
+			t.Fatalf("the tile is not run inline:\n%s", buf)
+		}
+	})
+}
+
+func TestParallelRunPanic(t *testing.T) {
+	for _, p := range []*Parallel{
+		{MinTileSize: -1, Workers: 4},
+		{Workers: 1},
+	} {
+		func() {
+			defer func() {
+				v := recover()
+				err, ok := v.(*PanicError)
+				if !ok || err.Value != "test" {
+					t.Fatalf("unexpected panic: got %v, want *PanicError with %v", v, "test")
+				}
+				// The stack of the original panic is kept.
+				if !strings.Contains(string(err.Stack), "testParallelPanicFunc") || !strings.Contains(err.Error(), "testParallelPanicFunc") {
+					t.Fatalf("the stack doesn't contain the panicking function:\n%s", err.Stack)
+				}
+			}()
+			p.Run(image.Rect(0, 0, 100, 100), testParallelPanicFunc)
+		}()
+	}
+}
+
+func testParallelPanicFunc(sub image.Rectangle) {
+	panic("test")
+}
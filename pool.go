@@ -0,0 +1,204 @@
+package imageutil
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+)
+
+// ErrWorkerPoolClosed is returned when tasks are submitted to a closed WorkerPool.
+var ErrWorkerPoolClosed = errors.New("worker pool closed")
+
+// WorkerPool is a pool of workers that can be shared by concurrent Parallel calls.
+//
+// It limits the total number of concurrent tasks to its number of workers.
+// The tiles of concurrent calls are dispatched in a round-robin way, so a large image doesn't delay the small ones.
+//
+// A task must not submit tasks to the same WorkerPool, because it could block all the workers.
+type WorkerPool struct {
+	workers int
+	mu      sync.Mutex
+	cond    *sync.Cond
+	jobs    []*poolJob
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// NewWorkerPool returns a new WorkerPool with the given number of workers.
+//
+// If workers is lower than or equal to 0, GOMAXPROCS is used.
+func NewWorkerPool(workers int) *WorkerPool {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	wp := &WorkerPool{
+		workers: workers,
+	}
+	wp.cond = sync.NewCond(&wp.mu)
+	wp.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go wp.work()
+	}
+	return wp
+}
+
+// Workers returns the number of workers.
+func (wp *WorkerPool) Workers() int {
+	return wp.workers
+}
+
+// Close closes the WorkerPool.
+//
+// The tasks that were already submitted are completed, then the workers are stopped.
+// It blocks until all the workers are stopped.
+func (wp *WorkerPool) Close() {
+	wp.mu.Lock()
+	wp.closed = true
+	wp.mu.Unlock()
+	wp.cond.Broadcast()
+	wp.wg.Wait()
+}
+
+type poolJob struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	f       func(context.Context, int) error
+	n       int
+	limit   int
+	next    int
+	running int
+	queued  bool
+	stopped bool
+	err     error
+	done    chan struct{}
+}
+
+// run runs the n tasks in the WorkerPool and waits for their completion.
+//
+// If limit is greater than 0, at most limit tasks of the job run concurrently.
+func (wp *WorkerPool) run(ctx context.Context, n int, limit int, f func(context.Context, int) error) error {
+	wp.mu.Lock()
+	if wp.closed {
+		wp.mu.Unlock()
+		return ErrWorkerPoolClosed
+	}
+	if n == 0 {
+		wp.mu.Unlock()
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	j := &poolJob{
+		ctx:    ctx,
+		cancel: cancel,
+		f:      f,
+		n:      n,
+		limit:  limit,
+		queued: true,
+		done:   make(chan struct{}),
+	}
+	wp.jobs = append(wp.jobs, j)
+	wp.mu.Unlock()
+	wp.cond.Broadcast()
+	select {
+	case <-j.done:
+	case <-ctx.Done():
+		wp.mu.Lock()
+		wp.stopJob(j, nil)
+		wp.mu.Unlock()
+		<-j.done
+	}
+	if j.err != nil {
+		return j.err
+	}
+	if j.next < j.n {
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (wp *WorkerPool) work() {
+	defer wp.wg.Done()
+	wp.mu.Lock()
+	defer wp.mu.Unlock()
+	for {
+		for len(wp.jobs) == 0 && !wp.closed {
+			wp.cond.Wait()
+		}
+		if len(wp.jobs) == 0 {
+			return
+		}
+		j := wp.jobs[0]
+		wp.jobs = wp.jobs[1:]
+		j.queued = false
+		if j.ctx.Err() != nil {
+			wp.stopJob(j, nil)
+			continue
+		}
+		i := j.next
+		j.next++
+		j.running++
+		if j.limit <= 0 || j.running < j.limit {
+			// Round-robin: the job goes back to the end of the queue.
+			wp.requeueJob(j)
+		}
+		wp.mu.Unlock()
+		err := callParallelTask(j.ctx, i, j.f)
+		wp.mu.Lock()
+		j.running--
+		if err != nil {
+			wp.stopJob(j, err)
+		} else if wp.requeueJob(j) {
+			// The job was limited, another worker can run its next task.
+			wp.cond.Signal()
+		}
+		wp.finishJob(j)
+	}
+}
+
+// stopJob prevents the remaining tasks of a job from being dispatched.
+//
+// The caller must hold the lock.
+func (wp *WorkerPool) stopJob(j *poolJob, err error) {
+	if !j.stopped {
+		j.stopped = true
+		j.err = err
+		j.cancel()
+		for i, jj := range wp.jobs {
+			if jj == j {
+				wp.jobs = append(wp.jobs[:i], wp.jobs[i+1:]...)
+				break
+			}
+		}
+		j.queued = false
+	}
+	wp.finishJob(j)
+}
+
+// requeueJob appends a job to the end of the queue, if it has remaining tasks and is not already queued.
+// It returns true if the job was appended.
+//
+// The caller must hold the lock.
+func (wp *WorkerPool) requeueJob(j *poolJob) bool {
+	if j.queued || j.stopped || j.next >= j.n {
+		return false
+	}
+	j.queued = true
+	wp.jobs = append(wp.jobs, j)
+	return true
+}
+
+// finishJob marks a job as done if it has no more tasks to run.
+//
+// The caller must hold the lock.
+func (wp *WorkerPool) finishJob(j *poolJob) {
+	if j.running > 0 || (!j.stopped && j.next < j.n) {
+		return
+	}
+	select {
+	case <-j.done:
+	default:
+		close(j.done)
+	}
+}
@@ -0,0 +1,118 @@
+package imageutil
+
+import (
+	"context"
+	"errors"
+	"image"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWorkerPool(t *testing.T) {
+	wp := NewWorkerPool(3)
+	defer wp.Close()
+	r := image.Rect(-10, 20, 95, 83)
+	p := &Parallel{
+		TileWidth:   16,
+		TileHeight:  8,
+		MinTileSize: -1,
+		Pool:        wp,
+	}
+	var running, maxRunning int32
+	wg := new(sync.WaitGroup)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var area int64
+			p.Run(r, func(sub image.Rectangle) {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				if !sub.In(r) {
+					t.Errorf("%s is not in %s", sub, r)
+				}
+				atomic.AddInt64(&area, int64(sub.Dx()*sub.Dy()))
+				atomic.AddInt32(&running, -1)
+			})
+			if area != int64(r.Dx()*r.Dy()) {
+				t.Errorf("unexpected area: got %d, want %d", area, r.Dx()*r.Dy())
+			}
+		}()
+	}
+	wg.Wait()
+	if maxRunning > int32(wp.Workers()) {
+		t.Fatalf("too many concurrent tasks: got %d, want <= %d", maxRunning, wp.Workers())
+	}
+}
+
+func TestWorkerPoolWorkersLimit(t *testing.T) {
+	wp := NewWorkerPool(4)
+	defer wp.Close()
+	p := &Parallel{
+		Workers:     2,
+		TileHeight:  1,
+		MinTileSize: -1,
+		Pool:        wp,
+	}
+	var running, maxRunning int32
+	p.Run(image.Rect(0, 0, 10, 20), func(sub image.Rectangle) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&maxRunning)
+			if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+	})
+	if maxRunning > int32(p.Workers) {
+		t.Fatalf("too many concurrent tasks: got %d, want <= %d", maxRunning, p.Workers)
+	}
+}
+
+func TestWorkerPoolError(t *testing.T) {
+	wp := NewWorkerPool(2)
+	defer wp.Close()
+	p := &Parallel{MinTileSize: -1, Pool: wp}
+	errTest := errors.New("test")
+	err := p.RunContext(context.Background(), image.Rect(0, 0, 100, 100), func(ctx context.Context, sub image.Rectangle) error {
+		return errTest
+	})
+	if err != errTest {
+		t.Fatalf("unexpected error: got %v, want %v", err, errTest)
+	}
+}
+
+func TestWorkerPoolCanceled(t *testing.T) {
+	wp := NewWorkerPool(2)
+	defer wp.Close()
+	p := &Parallel{MinTileSize: -1, Pool: wp}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := p.RunContext(ctx, image.Rect(0, 0, 100, 100), func(ctx context.Context, sub image.Rectangle) error {
+		return nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("unexpected error: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWorkerPoolClosed(t *testing.T) {
+	wp := NewWorkerPool(2)
+	wp.Close()
+	p := &Parallel{Pool: wp}
+	err := p.RunContext(context.Background(), image.Rect(0, 0, 100, 100), func(ctx context.Context, sub image.Rectangle) error {
+		return nil
+	})
+	if err != ErrWorkerPoolClosed {
+		t.Fatalf("unexpected error: got %v, want %v", err, ErrWorkerPoolClosed)
+	}
+}
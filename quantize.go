@@ -0,0 +1,365 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// QuantizeMethod is a color quantization method.
+type QuantizeMethod int
+
+const (
+	// QuantizeMedianCut is the median cut method.
+	// It recursively splits the color box with the widest range at its median.
+	QuantizeMedianCut QuantizeMethod = iota
+	// QuantizeOctree is the octree method.
+	// It builds a tree of the colors bits, and merges the least used leaves.
+	// Because alpha is a dimension, each node has 16 children.
+	QuantizeOctree
+)
+
+// QuantizeOptions are the options of Quantize.
+type QuantizeOptions struct {
+	// Method is the quantization method.
+	Method QuantizeMethod
+	// KMeansIterations is the number of k-means iterations used to refine the palette.
+	// If it's 0, the palette is not refined.
+	KMeansIterations int
+	// ReserveTransparent reserves the first entry of the palette for the fully transparent color.
+	// The fully transparent pixels are not used to compute the other entries.
+	ReserveTransparent bool
+	// Parallel is used to process the image concurrently.
+	Parallel *Parallel
+}
+
+// Quantize returns a palette of at most n colors representing the colors of an image.
+//
+// The colors are read with NewAtFunc, and are quantized with 8 bits per channel.
+// Alpha is a dimension of the color space, like red, green and blue.
+// The palette contains color.RGBA values.
+// If opts is nil, the default options are used.
+func Quantize(p image.Image, n int, opts *QuantizeOptions) color.Palette {
+	if opts == nil {
+		opts = new(QuantizeOptions)
+	}
+	if n <= 0 {
+		return nil
+	}
+	var pl color.Palette
+	if opts.ReserveTransparent {
+		pl = append(pl, color.RGBA{})
+		n--
+	}
+	if n == 0 {
+		return pl
+	}
+	es := newQuantizeHistogram(p, opts.Parallel, opts.ReserveTransparent)
+	var cs []quantizeColor
+	switch opts.Method {
+	case QuantizeOctree:
+		cs = quantizeOctree(es, n)
+	default:
+		cs = quantizeMedianCut(es, n)
+	}
+	if opts.KMeansIterations > 0 {
+		cs = quantizeKMeans(es, cs, opts.KMeansIterations, opts.Parallel)
+	}
+	for _, c := range cs {
+		pl = append(pl, c.rgba())
+	}
+	return pl
+}
+
+// quantizeColor is a premultiplied 8 bits color.
+type quantizeColor [4]uint32
+
+func (c quantizeColor) key() uint32 {
+	return c[0]<<24 | c[1]<<16 | c[2]<<8 | c[3]
+}
+
+func (c quantizeColor) rgba() color.RGBA {
+	return color.RGBA{uint8(c[0]), uint8(c[1]), uint8(c[2]), uint8(c[3])}
+}
+
+// quantizeEntry is an entry of the colors histogram.
+type quantizeEntry struct {
+	c     quantizeColor
+	count uint64
+}
+
+// newQuantizeHistogram returns the histogram of the colors of an image, sorted by color.
+func newQuantizeHistogram(p image.Image, pl *Parallel, skipTransparent bool) []quantizeEntry {
+	at := NewAtFunc(p)
+	h := ParallelReduce(pl, p.Bounds(), func(r image.Rectangle) map[uint32]uint64 {
+		h := make(map[uint32]uint64)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				cr, cg, cb, ca := at(x, y)
+				if skipTransparent && ca>>8 == 0 {
+					continue
+				}
+				h[cr>>8<<24|cg>>8<<16|cb>>8<<8|ca>>8]++
+			}
+		}
+		return h
+	}, func(a, b map[uint32]uint64) map[uint32]uint64 {
+		for k, v := range b {
+			a[k] += v
+		}
+		return a
+	})
+	es := make([]quantizeEntry, 0, len(h))
+	for k, v := range h {
+		es = append(es, quantizeEntry{
+			c:     quantizeColor{k >> 24, k >> 16 & 0xff, k >> 8 & 0xff, k & 0xff},
+			count: v,
+		})
+	}
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].c.key() < es[j].c.key()
+	})
+	return es
+}
+
+// quantizeMean returns the mean color of histogram entries, weighted by their count.
+func quantizeMean(es []quantizeEntry) quantizeColor {
+	var sum [4]uint64
+	var count uint64
+	for _, e := range es {
+		for ch := range sum {
+			sum[ch] += uint64(e.c[ch]) * e.count
+		}
+		count += e.count
+	}
+	var c quantizeColor
+	if count == 0 {
+		return c
+	}
+	for ch := range c {
+		c[ch] = uint32((sum[ch] + count/2) / count)
+	}
+	return c
+}
+
+func quantizeMedianCut(es []quantizeEntry, n int) []quantizeColor {
+	if len(es) == 0 {
+		return nil
+	}
+	boxes := [][]quantizeEntry{append([]quantizeEntry(nil), es...)}
+	for len(boxes) < n {
+		best, bestCh, bestRange := -1, 0, uint32(0)
+		for i, b := range boxes {
+			if len(b) < 2 {
+				continue
+			}
+			ch, rg := quantizeWidestChannel(b)
+			if best < 0 || rg > bestRange {
+				best, bestCh, bestRange = i, ch, rg
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b1, b2 := quantizeSplitBox(boxes[best], bestCh)
+		boxes[best] = b1
+		boxes = append(boxes, b2)
+	}
+	cs := make([]quantizeColor, len(boxes))
+	for i, b := range boxes {
+		cs[i] = quantizeMean(b)
+	}
+	return cs
+}
+
+func quantizeWidestChannel(b []quantizeEntry) (ch int, rg uint32) {
+	for k := 0; k < 4; k++ {
+		min, max := uint32(0xff), uint32(0)
+		for _, e := range b {
+			if e.c[k] < min {
+				min = e.c[k]
+			}
+			if e.c[k] > max {
+				max = e.c[k]
+			}
+		}
+		if max-min > rg || k == 0 {
+			ch, rg = k, max-min
+		}
+	}
+	return ch, rg
+}
+
+// quantizeSplitBox splits a box at the weighted median of a channel.
+func quantizeSplitBox(b []quantizeEntry, ch int) (b1, b2 []quantizeEntry) {
+	sort.Slice(b, func(i, j int) bool {
+		if b[i].c[ch] != b[j].c[ch] {
+			return b[i].c[ch] < b[j].c[ch]
+		}
+		return b[i].c.key() < b[j].c.key()
+	})
+	var total uint64
+	for _, e := range b {
+		total += e.count
+	}
+	var sum uint64
+	m := 1
+	for i, e := range b[:len(b)-1] {
+		sum += e.count
+		m = i + 1
+		if sum*2 >= total {
+			break
+		}
+	}
+	return b[:m:m], b[m:]
+}
+
+// quantizeOctreeDepth is the depth of the octree leaves, 1 level per bit.
+const quantizeOctreeDepth = 8
+
+type quantizeOctreeNode struct {
+	children [16]*quantizeOctreeNode
+	sum      [4]uint64
+	count    uint64
+	leaf     bool
+	order    int
+}
+
+func quantizeOctree(es []quantizeEntry, n int) []quantizeColor {
+	if len(es) == 0 {
+		return nil
+	}
+	root := new(quantizeOctreeNode)
+	// The reducible nodes of each level, in creation order.
+	var levels [quantizeOctreeDepth][]*quantizeOctreeNode
+	nodes := 0
+	leaves := 0
+	for _, e := range es {
+		node := root
+		for level := 0; ; level++ {
+			for ch := range node.sum {
+				node.sum[ch] += uint64(e.c[ch]) * e.count
+			}
+			node.count += e.count
+			if level == quantizeOctreeDepth {
+				node.leaf = true
+				break
+			}
+			bit := uint(quantizeOctreeDepth - 1 - level)
+			i := (e.c[0]>>bit&1)<<3 | (e.c[1]>>bit&1)<<2 | (e.c[2]>>bit&1)<<1 | e.c[3]>>bit&1
+			child := node.children[i]
+			if child == nil {
+				nodes++
+				child = &quantizeOctreeNode{order: nodes}
+				node.children[i] = child
+				if level+1 == quantizeOctreeDepth {
+					leaves++
+				} else {
+					levels[level+1] = append(levels[level+1], child)
+				}
+			}
+			node = child
+		}
+	}
+	levels[0] = []*quantizeOctreeNode{root}
+	for level := quantizeOctreeDepth - 1; level >= 0 && leaves > n; level-- {
+		ns := levels[level]
+		sort.SliceStable(ns, func(i, j int) bool {
+			if ns[i].count != ns[j].count {
+				return ns[i].count < ns[j].count
+			}
+			return ns[i].order < ns[j].order
+		})
+		for _, node := range ns {
+			if leaves <= n {
+				break
+			}
+			children := 0
+			for i, child := range node.children {
+				if child != nil {
+					children++
+					node.children[i] = nil
+				}
+			}
+			node.leaf = true
+			leaves -= children - 1
+		}
+	}
+	var cs []quantizeColor
+	var walk func(node *quantizeOctreeNode)
+	walk = func(node *quantizeOctreeNode) {
+		if node.leaf {
+			var c quantizeColor
+			for ch := range c {
+				c[ch] = uint32((node.sum[ch] + node.count/2) / node.count)
+			}
+			cs = append(cs, c)
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				walk(child)
+			}
+		}
+	}
+	walk(root)
+	return cs
+}
+
+// quantizeKMeans refines the colors with the k-means algorithm.
+//
+// The histogram entries are processed concurrently, as the rows of a 1 pixel wide Rectangle.
+func quantizeKMeans(es []quantizeEntry, cs []quantizeColor, iterations int, pl *Parallel) []quantizeColor {
+	k := len(cs)
+	if k == 0 {
+		return cs
+	}
+	type cluster struct {
+		sum   [4]uint64
+		count uint64
+	}
+	for it := 0; it < iterations; it++ {
+		pa := make(paletteRGBA, k)
+		for i, c := range cs {
+			pa[i] = colorRGBA{c[0] * 0x101, c[1] * 0x101, c[2] * 0x101, c[3] * 0x101}
+		}
+		pi := newPaletteIndex(pa, 0)
+		clusters := parallelReduceRange(pl, len(es), func(start, end int) []cluster {
+			clusters := make([]cluster, k)
+			for _, e := range es[start:end] {
+				i := pi.index(colorRGBA{e.c[0] * 0x101, e.c[1] * 0x101, e.c[2] * 0x101, e.c[3] * 0x101})
+				for ch := range e.c {
+					clusters[i].sum[ch] += uint64(e.c[ch]) * e.count
+				}
+				clusters[i].count += e.count
+			}
+			return clusters
+		}, func(a, b []cluster) []cluster {
+			for i := range a {
+				for ch := range a[i].sum {
+					a[i].sum[ch] += b[i].sum[ch]
+				}
+				a[i].count += b[i].count
+			}
+			return a
+		})
+		changed := false
+		for i, cl := range clusters {
+			if cl.count == 0 {
+				continue
+			}
+			var c quantizeColor
+			for ch := range c {
+				c[ch] = uint32((cl.sum[ch] + cl.count/2) / cl.count)
+			}
+			if c != cs[i] {
+				cs[i] = c
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return cs
+}
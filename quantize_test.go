@@ -0,0 +1,92 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+var testQuantizeMethods = []QuantizeMethod{
+	QuantizeMedianCut,
+	QuantizeOctree,
+}
+
+func TestQuantizeExact(t *testing.T) {
+	bd := image.Rect(-3, -1, 20, 15)
+	p := image.NewRGBA(bd)
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			p.Set(x, y, testPalette[(x*7+y*3+100)%len(testPalette)])
+		}
+	}
+	for _, m := range testQuantizeMethods {
+		for _, kmeans := range []int{0, 5} {
+			t.Run(fmt.Sprintf("%d/%d", m, kmeans), func(t *testing.T) {
+				pl := Quantize(p, 16, &QuantizeOptions{
+					Method:           m,
+					KMeansIterations: kmeans,
+				})
+				if len(pl) != len(testPalette) {
+					t.Fatalf("unexpected palette length: got %d, want %d", len(pl), len(testPalette))
+				}
+				for _, c := range testPalette {
+					if pl.Convert(c) != c {
+						t.Fatalf("color %v not found in palette %v", c, pl)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestQuantizeGradient(t *testing.T) {
+	bd := image.Rect(0, 0, 64, 64)
+	p := image.NewNRGBA(bd)
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			p.SetNRGBA(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), 0x80, uint8(255 - x)})
+		}
+	}
+	p.SetNRGBA(0, 0, color.NRGBA{})
+	for _, m := range testQuantizeMethods {
+		t.Run(fmt.Sprint(m), func(t *testing.T) {
+			opts := &QuantizeOptions{
+				Method:             m,
+				ReserveTransparent: true,
+				Parallel:           &Parallel{MinTileSize: -1},
+			}
+			pl := Quantize(p, 32, opts)
+			if len(pl) == 0 || len(pl) > 32 {
+				t.Fatalf("unexpected palette length: %d", len(pl))
+			}
+			if pl[0] != (color.RGBA{}) {
+				t.Fatalf("first color is not transparent: %v", pl[0])
+			}
+			if pl2 := Quantize(p, 32, opts); fmt.Sprint(pl2) != fmt.Sprint(pl) {
+				t.Fatalf("not deterministic: %v != %v", pl2, pl)
+			}
+			opts.KMeansIterations = 10
+			plKMeans := Quantize(p, 32, opts)
+			if e1, e2 := testQuantizeError(p, plKMeans), testQuantizeError(p, pl); e1 > e2 {
+				t.Fatalf("k-means increased the error: %d > %d", e1, e2)
+			}
+		})
+	}
+}
+
+func testQuantizeError(p image.Image, pl color.Palette) uint64 {
+	pa := newPaletteRGBA(pl)
+	at := NewAtFunc(p)
+	var sum uint64
+	bd := p.Bounds()
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			r, g, b, a := at(x, y)
+			c := colorRGBA{r, g, b, a}
+			ca := pa[pa.index(c)]
+			sum += uint64(sqDiff(c.r, ca.r)) + uint64(sqDiff(c.g, ca.g)) + uint64(sqDiff(c.b, ca.b)) + uint64(sqDiff(c.a, ca.a))
+		}
+	}
+	return sum
+}
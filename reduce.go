@@ -0,0 +1,81 @@
+package imageutil
+
+import (
+	"context"
+	"image"
+)
+
+// ParallelReduce computes a value concurrently for each tile of a Rectangle, and combines the values.
+//
+// The tiles are defined by p.
+// The values are combined sequentially in the order of the tiles, so the result is deterministic regardless of the scheduling order,
+// even if combine is not commutative.
+// If r is empty, the zero value of T is returned.
+func ParallelReduce[T any](p *Parallel, r image.Rectangle, f func(image.Rectangle) T, combine func(a, b T) T) T {
+	res, err := ParallelReduceContext(context.Background(), p, r, func(ctx context.Context, rr image.Rectangle) (T, error) {
+		return f(rr), nil
+	}, combine)
+	repanic(err)
+	return res
+}
+
+// ParallelReduceContext is like ParallelReduce, but it supports cancellation and errors.
+//
+// See Parallel.RunContext.
+func ParallelReduceContext[T any](ctx context.Context, p *Parallel, r image.Rectangle, f func(context.Context, image.Rectangle) (T, error), combine func(a, b T) T) (T, error) {
+	p = p.orDefault()
+	rs := p.tiles(r)
+	vs := make([]T, len(rs))
+	err := p.run(ctx, len(rs), func(ctx context.Context, i int) error {
+		v, err := f(ctx, rs[i])
+		if err != nil {
+			return err
+		}
+		vs[i] = v
+		return nil
+	})
+	var res T
+	if err != nil {
+		return res, err
+	}
+	for i, v := range vs {
+		if i == 0 {
+			res = v
+			continue
+		}
+		res = combine(res, v)
+	}
+	return res, nil
+}
+
+// parallelReduceRange is like ParallelReduce, but for the indexes [0, n) of a slice.
+//
+// The range is split in up to Workers balanced chunks, of at least MinTileSize indexes.
+func parallelReduceRange[T any](p *Parallel, n int, f func(start, end int) T, combine func(a, b T) T) T {
+	p = p.orDefault()
+	chunks := p.workers()
+	if chunks > n {
+		chunks = n
+	}
+	if minSize := p.minTileSize(); minSize > 0 && chunks > n/minSize {
+		chunks = n / minSize
+		if chunks < 1 && n > 0 {
+			chunks = 1
+		}
+	}
+	vs := make([]T, chunks)
+	err := p.run(context.Background(), chunks, func(ctx context.Context, i int) error {
+		vs[i] = f(n*i/chunks, n*(i+1)/chunks)
+		return nil
+	})
+	repanic(err)
+	var res T
+	for i, v := range vs {
+		if i == 0 {
+			res = v
+			continue
+		}
+		res = combine(res, v)
+	}
+	return res
+}
@@ -0,0 +1,103 @@
+package imageutil
+
+import (
+	"context"
+	"errors"
+	"image"
+	"testing"
+)
+
+func TestParallelReduce(t *testing.T) {
+	r := image.Rect(-10, 20, 95, 83)
+	for _, p := range []*Parallel{
+		nil,
+		{TileWidth: 16, TileHeight: 8, MinTileSize: -1, Order: ParallelOrderHilbert},
+	} {
+		sum := ParallelReduce(p, r, func(sub image.Rectangle) int {
+			return sub.Dx() * sub.Dy()
+		}, func(a, b int) int {
+			return a + b
+		})
+		if sum != r.Dx()*r.Dy() {
+			t.Fatalf("unexpected sum: got %d, want %d", sum, r.Dx()*r.Dy())
+		}
+		union := ParallelReduce(p, r, func(sub image.Rectangle) image.Rectangle {
+			return sub
+		}, image.Rectangle.Union)
+		if union != r {
+			t.Fatalf("unexpected union: got %s, want %s", union, r)
+		}
+	}
+}
+
+func TestParallelReduceDeterministic(t *testing.T) {
+	r := image.Rect(0, 0, 100, 100)
+	p := &Parallel{TileWidth: 10, TileHeight: 10, MinTileSize: -1}
+	f := func(sub image.Rectangle) []image.Rectangle {
+		return []image.Rectangle{sub}
+	}
+	combine := func(a, b []image.Rectangle) []image.Rectangle {
+		return append(a, b...)
+	}
+	want := ParallelReduce(p, r, f, combine)
+	for i := 0; i < 10; i++ {
+		got := ParallelReduce(p, r, f, combine)
+		for j := range want {
+			if got[j] != want[j] {
+				t.Fatalf("different result: index %d: got %s, want %s", j, got[j], want[j])
+			}
+		}
+	}
+}
+
+func TestParallelReduceContextError(t *testing.T) {
+	errTest := errors.New("test")
+	_, err := ParallelReduceContext(context.Background(), nil, image.Rect(0, 0, 100, 100), func(ctx context.Context, sub image.Rectangle) (int, error) {
+		return 0, errTest
+	}, func(a, b int) int {
+		return a + b
+	})
+	if err != errTest {
+		t.Fatalf("unexpected error: got %v, want %v", err, errTest)
+	}
+}
+
+func TestParallelReduceEmpty(t *testing.T) {
+	sum := ParallelReduce(nil, image.Rectangle{}, func(sub image.Rectangle) int {
+		return 1
+	}, func(a, b int) int {
+		return a + b
+	})
+	if sum != 0 {
+		t.Fatalf("unexpected sum: got %d, want 0", sum)
+	}
+}
+
+func TestParallelReduceRange(t *testing.T) {
+	for _, tc := range []struct {
+		p *Parallel
+		n int
+	}{
+		{nil, 0},
+		{nil, 10},
+		{&Parallel{Workers: 4, MinTileSize: -1}, 10},
+		{&Parallel{Workers: 4, MinTileSize: -1}, 3},
+		{&Parallel{Workers: 4, MinTileSize: 4}, 10},
+	} {
+		chunks := parallelReduceRange(tc.p, tc.n, func(start, end int) [][2]int {
+			return [][2]int{{start, end}}
+		}, func(a, b [][2]int) [][2]int {
+			return append(a, b...)
+		})
+		next := 0
+		for _, c := range chunks {
+			if c[0] != next || c[1] <= c[0] {
+				t.Fatalf("unexpected chunks for %d indexes: %v", tc.n, chunks)
+			}
+			next = c[1]
+		}
+		if next != tc.n {
+			t.Fatalf("unexpected chunks for %d indexes: %v", tc.n, chunks)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package imageutil
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// ResizeFilter is a resampling filter.
+type ResizeFilter int
+
+const (
+	// ResizeNearest is the nearest neighbor filter.
+	ResizeNearest ResizeFilter = iota
+	// ResizeBilinear is the bilinear (triangle) filter.
+	ResizeBilinear
+	// ResizeCatmullRom is the Catmull-Rom bicubic filter (B=0, C=1/2).
+	ResizeCatmullRom
+	// ResizeMitchell is the Mitchell-Netravali bicubic filter (B=1/3, C=1/3).
+	ResizeMitchell
+	// ResizeLanczos3 is the Lanczos filter with 3 lobes.
+	ResizeLanczos3
+)
+
+type resizeKernel struct {
+	support float64
+	at      func(x float64) float64
+}
+
+var resizeKernels = map[ResizeFilter]resizeKernel{
+	ResizeBilinear: {1, func(x float64) float64 {
+		x = math.Abs(x)
+		if x >= 1 {
+			return 0
+		}
+		return 1 - x
+	}},
+	ResizeCatmullRom: {2, func(x float64) float64 {
+		return bicubic(x, 0, 0.5)
+	}},
+	ResizeMitchell: {2, func(x float64) float64 {
+		return bicubic(x, 1.0/3, 1.0/3)
+	}},
+	ResizeLanczos3: {3, func(x float64) float64 {
+		return lanczos(x, 3)
+	}},
+}
+
+// bicubic returns the value of the Mitchell-Netravali cubic filter with parameters b and c at x.
+func bicubic(x, b, c float64) float64 {
+	x = math.Abs(x)
+	switch {
+	case x < 1:
+		return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+	case x < 2:
+		return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+	default:
+		return 0
+	}
+}
+
+// ResizeOptions are the options of Resize.
+type ResizeOptions struct {
+	// Linear resamples the values in linear light, instead of sRGB encoded values.
+	// It is slower, but it gives more accurate results, especially for downscaling.
+	Linear bool
+	// Parallel is used to process the image concurrently.
+	Parallel *Parallel
+}
+
+// Resize resamples src to the bounds of dst.
+//
+// The bounds of src are scaled to the bounds of dst.
+// The values are read with NewAtFuncFloat, and the computation is done on alpha-premultiplied values.
+// The pixels outside of the bounds of src are read with EdgeClamp.
+// For downscaling, the filter is stretched, so all the source pixels contribute to the result.
+// The horizontal pass is done first, in an intermediate buffer, then the vertical pass.
+// Each pass runs concurrently, and uses weight tables computed once per column or row.
+// The resulting values are clipped like Convolve.
+// An unknown filter is handled as ResizeBilinear.
+// If opts is nil, the default options are used.
+func Resize(dst draw.Image, src image.Image, filter ResizeFilter, opts *ResizeOptions) {
+	if opts == nil {
+		opts = new(ResizeOptions)
+	}
+	dr, sr := dst.Bounds(), src.Bounds()
+	if dr.Empty() || sr.Empty() {
+		return
+	}
+	wx := newResizeWeights(dr.Dx(), sr.Dx(), filter)
+	wy := newResizeWeights(dr.Dy(), sr.Dy(), filter)
+	at := NewAtFuncFloat(src)
+	store := newSetFuncFloatClip(dst)
+	if opts.Linear {
+		initLinearTables()
+	}
+	// The horizontal pass, from the rows of src to the rows of tmp.
+	w := dr.Dx() * 4
+	tmp := make([]float32, sr.Dy()*w)
+	opts.Parallel.Run(image.Rect(dr.Min.X, sr.Min.Y, dr.Max.X, sr.Max.Y), func(r image.Rectangle) {
+		// Only the source columns used by the destination columns of the tile are read.
+		s0, s1 := wx.sourceRange(r.Min.X-dr.Min.X, r.Max.X-dr.Min.X)
+		row := make([]float32, sr.Dx()*4)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := sr.Min.X + s0; x < sr.Min.X+s1; x++ {
+				s := row[(x-sr.Min.X)*4 : (x-sr.Min.X)*4+4]
+				s[0], s[1], s[2], s[3] = at(x, y)
+				if opts.Linear {
+					s[0], s[1], s[2] = premultipliedToLinearFloat32(s[0], s[1], s[2], s[3])
+				}
+			}
+			d := tmp[(y-sr.Min.Y)*w:]
+			for x := r.Min.X; x < r.Max.X; x++ {
+				i := x - dr.Min.X
+				sum := wx.apply(i, row, 4)
+				copy(d[i*4:i*4+4], sum[:])
+			}
+		}
+	})
+	// The vertical pass, from the columns of tmp to dst.
+	opts.Parallel.Run(dr, func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				sum := wy.apply(y-dr.Min.Y, tmp[(x-dr.Min.X)*4:], w)
+				if opts.Linear {
+					sum[0], sum[1], sum[2] = premultipliedFromLinearFloat32(sum[0], sum[1], sum[2], clampFloat32(sum[3]))
+				}
+				store(x, y, sum)
+			}
+		}
+	})
+}
+
+// resizeWeights holds the weights of the source pixels for each destination pixel, on 1 axis.
+type resizeWeights struct {
+	// starts holds the index of the first source pixel for each destination pixel.
+	starts []int
+	// counts holds the number of source pixels for each destination pixel.
+	counts []int
+	// values holds the weights, size values per destination pixel.
+	values []float32
+	size   int
+}
+
+// newResizeWeights returns the weights for dn destination pixels and sn source pixels.
+//
+// The positions are relative to the minimum of the bounds.
+func newResizeWeights(dn, sn int, filter ResizeFilter) *resizeWeights {
+	scale := float64(sn) / float64(dn)
+	if filter == ResizeNearest {
+		ws := &resizeWeights{
+			starts: make([]int, dn),
+			counts: make([]int, dn),
+			values: make([]float32, dn),
+			size:   1,
+		}
+		for i := range ws.starts {
+			j := int((float64(i) + 0.5) * scale)
+			if j >= sn {
+				j = sn - 1
+			}
+			ws.starts[i], ws.counts[i], ws.values[i] = j, 1, 1
+		}
+		return ws
+	}
+	k, ok := resizeKernels[filter]
+	if !ok {
+		k = resizeKernels[ResizeBilinear]
+	}
+	// The filter is stretched for downscaling.
+	fscale := math.Max(scale, 1)
+	support := k.support * fscale
+	size := int(math.Ceil(support))*2 + 1
+	if size > sn {
+		size = sn
+	}
+	ws := &resizeWeights{
+		starts: make([]int, dn),
+		counts: make([]int, dn),
+		values: make([]float32, dn*size),
+		size:   size,
+	}
+	tmp := make([]float64, size)
+	for i := range ws.starts {
+		center := (float64(i)+0.5)*scale - 0.5
+		left := int(math.Ceil(center - support))
+		right := int(math.Floor(center + support))
+		start := clampInt(left, 0, sn-1)
+		end := clampInt(right, 0, sn-1)
+		// The pixels outside of the source are clamped to the edges, so their weights are accumulated on the edge pixels.
+		for j := range tmp {
+			tmp[j] = 0
+		}
+		var sum float64
+		for j := left; j <= right; j++ {
+			v := k.at((float64(j) - center) / fscale)
+			if v == 0 {
+				continue
+			}
+			tmp[clampInt(j, start, end)-start] += v
+			sum += v
+		}
+		count := end - start + 1
+		vs := ws.values[i*size : i*size+count]
+		for j := range vs {
+			if sum != 0 {
+				vs[j] = float32(tmp[j] / sum)
+			}
+		}
+		ws.starts[i], ws.counts[i] = start, count
+	}
+	return ws
+}
+
+// sourceRange returns the range of the source pixels used by the destination pixels [start, end[.
+func (ws *resizeWeights) sourceRange(start, end int) (s0, s1 int) {
+	// The starts and the ends are monotonic.
+	s0 = ws.starts[start]
+	s1 = ws.starts[end-1] + ws.counts[end-1]
+	return s0, s1
+}
+
+// apply returns the weighted sum of the source pixels for the destination pixel i.
+//
+// s holds the source pixels, with 4 values per pixel, and stride values between 2 pixels.
+func (ws *resizeWeights) apply(i int, s []float32, stride int) [4]float32 {
+	var sum [4]float32
+	start, count := ws.starts[i], ws.counts[i]
+	for j, v := range ws.values[i*ws.size : i*ws.size+count] {
+		k := (start + j) * stride
+		p := s[k : k+4]
+		sum[0] += p[0] * v
+		sum[1] += p[1] * v
+		sum[2] += p[2] * v
+		sum[3] += p[3] * v
+	}
+	return sum
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
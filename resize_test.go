@@ -0,0 +1,173 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+var testResizeFilters = []ResizeFilter{
+	ResizeNearest,
+	ResizeBilinear,
+	ResizeCatmullRom,
+	ResizeMitchell,
+	ResizeLanczos3,
+}
+
+func TestResize(t *testing.T) {
+	src := testNewRandomImage(image.Rect(-2, 3, 15, 14))
+	for _, filter := range testResizeFilters {
+		for _, dr := range []image.Rectangle{
+			image.Rect(0, 0, 40, 30),
+			image.Rect(5, -3, 11, 2),
+			image.Rect(0, 0, 17, 4),
+			image.Rect(0, 0, 1, 1),
+		} {
+			t.Run(fmt.Sprintf("%d/%s", filter, dr), func(t *testing.T) {
+				dst := image.NewRGBA64(dr)
+				Resize(dst, src, filter, &ResizeOptions{
+					Parallel: &Parallel{TileHeight: 3, MinTileSize: 1},
+				})
+				testResizeReference(t, dst, src, filter)
+			})
+		}
+	}
+}
+
+// testResizeReference compares the result of Resize with a naive implementation.
+func testResizeReference(t *testing.T, dst *image.RGBA64, src image.Image, filter ResizeFilter) {
+	t.Helper()
+	at := NewAtFuncFloat(src)
+	dr, sr := dst.Rect, src.Bounds()
+	xws := testResizeReferenceWeights(dr.Dx(), sr.Dx(), filter)
+	yws := testResizeReferenceWeights(dr.Dy(), sr.Dy(), filter)
+	for y := dr.Min.Y; y < dr.Max.Y; y++ {
+		for x := dr.Min.X; x < dr.Max.X; x++ {
+			var sum [4]float64
+			for sy, wy := range yws[y-dr.Min.Y] {
+				for sx, wx := range xws[x-dr.Min.X] {
+					r, g, b, a := at(sr.Min.X+sx, sr.Min.Y+sy)
+					w := wx * wy * 0xffff
+					sum[0] += float64(r) * w
+					sum[1] += float64(g) * w
+					sum[2] += float64(b) * w
+					sum[3] += float64(a) * w
+				}
+			}
+			sum[3] = math.Max(0, math.Min(sum[3], 0xffff))
+			for ch := 0; ch < 3; ch++ {
+				sum[ch] = math.Max(0, math.Min(sum[ch], sum[3]))
+			}
+			c := dst.RGBA64At(x, y)
+			got := [4]uint16{c.R, c.G, c.B, c.A}
+			for ch, v := range sum {
+				if math.Abs(float64(got[ch])-v) > 1 {
+					t.Fatalf("different value: pixel %dx%d, channel %d: got %d, want %f", x, y, ch, got[ch], v)
+				}
+			}
+		}
+	}
+}
+
+// testResizeReferenceWeights returns the weights of all the source pixels for each destination pixel.
+func testResizeReferenceWeights(dn, sn int, filter ResizeFilter) [][]float64 {
+	scale := float64(sn) / float64(dn)
+	ws := make([][]float64, dn)
+	for i := range ws {
+		ws[i] = make([]float64, sn)
+		center := (float64(i)+0.5)*scale - 0.5
+		if filter == ResizeNearest {
+			ws[i][int(math.Round(center))] = 1
+			continue
+		}
+		k := resizeKernels[filter]
+		fscale := math.Max(scale, 1)
+		var sum float64
+		for j := -sn * 4; j < sn*5; j++ {
+			v := k.at((float64(j) - center) / fscale)
+			ws[i][clampInt(j, 0, sn-1)] += v
+			sum += v
+		}
+		for j := range ws[i] {
+			ws[i][j] /= sum
+		}
+	}
+	return ws
+}
+
+func TestResizeIdentity(t *testing.T) {
+	src := testNewRandomImage(image.Rect(0, 0, 9, 7))
+	for _, filter := range testResizeFilters {
+		if filter == ResizeMitchell {
+			// It's not an interpolating filter, so it blurs the image.
+			continue
+		}
+		t.Run(fmt.Sprint(filter), func(t *testing.T) {
+			dst := image.NewNRGBA(src.Rect)
+			Resize(dst, src, filter, nil)
+			at1, at2 := NewAtFunc(src), NewAtFunc(dst)
+			for y := 0; y < 7; y++ {
+				for x := 0; x < 9; x++ {
+					r1, g1, b1, a1 := at1(x, y)
+					r2, g2, b2, a2 := at2(x, y)
+					const delta = 0x101
+					if testAbsDiff(r1, r2) > delta || testAbsDiff(g1, g2) > delta || testAbsDiff(b1, b2) > delta || a1 != a2 {
+						t.Fatalf("different color: pixel %dx%d: got {%d %d %d %d}, want {%d %d %d %d}", x, y, r2, g2, b2, a2, r1, g1, b1, a1)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestResizeTiles(t *testing.T) {
+	src := testNewRandomImage(image.Rect(-3, 2, 40, 30))
+	for _, filter := range testResizeFilters {
+		for _, linear := range []bool{false, true} {
+			for _, dr := range []image.Rectangle{image.Rect(0, 0, 17, 11), image.Rect(0, 0, 90, 70)} {
+				expected := image.NewNRGBA64(dr)
+				Resize(expected, src, filter, &ResizeOptions{Linear: linear, Parallel: &Parallel{Workers: 1}})
+				// The tiles of the horizontal pass read only the source columns that they use.
+				dst := image.NewNRGBA64(dr)
+				Resize(dst, src, filter, &ResizeOptions{Linear: linear, Parallel: &Parallel{TileWidth: 4, TileHeight: 3, MinTileSize: -1}})
+				for i, v := range dst.Pix {
+					if v != expected.Pix[i] {
+						t.Fatalf("unexpected value for filter %v, linear %t, %s at %d: got %d, want %d", filter, linear, dr, i, v, expected.Pix[i])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestResizeLinear(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 2, 1))
+	src.SetGray(1, 0, color.Gray{0xff})
+	for _, tc := range []struct {
+		linear bool
+		want   uint8
+	}{
+		{false, 0x80},
+		{true, 0xbc},
+	} {
+		dst := image.NewGray(image.Rect(0, 0, 1, 1))
+		Resize(dst, src, ResizeBilinear, &ResizeOptions{Linear: tc.linear})
+		if got := dst.GrayAt(0, 0).Y; got != tc.want {
+			t.Fatalf("unexpected value: linear %t: got %#x, want %#x", tc.linear, got, tc.want)
+		}
+	}
+}
+
+func BenchmarkResize(b *testing.B) {
+	src := testNewRandomImage(image.Rect(0, 0, 1024, 1024))
+	for _, filter := range testResizeFilters {
+		b.Run(fmt.Sprint(filter), func(b *testing.B) {
+			dst := image.NewRGBA(image.Rect(0, 0, 300, 300))
+			for i := 0; i < b.N; i++ {
+				Resize(dst, src, filter, nil)
+			}
+		})
+	}
+}
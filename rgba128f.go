@@ -0,0 +1,188 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+)
+
+// ColorRGBA128F represents an alpha-premultiplied color, having 32 bits float for each of red, green, blue and alpha.
+//
+// The nominal range of the values is [0, 1], but they can be outside of it (high dynamic range).
+type ColorRGBA128F struct {
+	R, G, B, A float32
+}
+
+// RGBA implements color.Color.
+//
+// The values are clipped to [0, 1], and the color values are clipped to alpha.
+func (c ColorRGBA128F) RGBA() (r, g, b, a uint32) {
+	a = float32ToUint16(c.A)
+	r = float32ToUint16(c.R)
+	g = float32ToUint16(c.G)
+	b = float32ToUint16(c.B)
+	if r > a {
+		r = a
+	}
+	if g > a {
+		g = a
+	}
+	if b > a {
+		b = a
+	}
+	return r, g, b, a
+}
+
+// RGBA128FModel is the color.Model for ColorRGBA128F.
+var RGBA128FModel = color.ModelFunc(rgba128FModel)
+
+func rgba128FModel(c color.Color) color.Color {
+	if c, ok := c.(ColorRGBA128F); ok {
+		return c
+	}
+	r, g, b, a := c.RGBA()
+	return ColorRGBA128F{
+		R: float32(r) / 0xffff,
+		G: float32(g) / 0xffff,
+		B: float32(b) / 0xffff,
+		A: float32(a) / 0xffff,
+	}
+}
+
+func float32ToUint16(v float32) uint32 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 0xffff
+	}
+	return uint32(v*0xffff + 0.5)
+}
+
+// RGBA128F is an in-memory image whose At method returns ColorRGBA128F values.
+type RGBA128F struct {
+	// Pix holds the image's pixels, in R, G, B, A order.
+	// The pixel at (x, y) starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4].
+	Pix []float32
+	// Stride is the Pix stride (in float32 values) between vertically adjacent pixels.
+	Stride int
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewRGBA128F returns a new RGBA128F image with the given bounds.
+func NewRGBA128F(r image.Rectangle) *RGBA128F {
+	w, h := r.Dx(), r.Dy()
+	return &RGBA128F{
+		Pix:    make([]float32, 4*w*h),
+		Stride: 4 * w,
+		Rect:   r,
+	}
+}
+
+// ColorModel implements image.Image.
+func (p *RGBA128F) ColorModel() color.Model {
+	return RGBA128FModel
+}
+
+// Bounds implements image.Image.
+func (p *RGBA128F) Bounds() image.Rectangle {
+	return p.Rect
+}
+
+// At implements image.Image.
+func (p *RGBA128F) At(x, y int) color.Color {
+	return p.RGBA128FAt(x, y)
+}
+
+// RGBA128FAt returns the color of the pixel at (x, y).
+func (p *RGBA128F) RGBA128FAt(x, y int) ColorRGBA128F {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return ColorRGBA128F{}
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4]
+	return ColorRGBA128F{s[0], s[1], s[2], s[3]}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to the pixel at (x, y).
+func (p *RGBA128F) PixOffset(x, y int) int {
+	return (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+}
+
+// Set implements draw.Image.
+func (p *RGBA128F) Set(x, y int, c color.Color) {
+	p.SetRGBA128F(x, y, RGBA128FModel.Convert(c).(ColorRGBA128F))
+}
+
+// SetRGBA128F sets the color of the pixel at (x, y).
+func (p *RGBA128F) SetRGBA128F(x, y int, c ColorRGBA128F) {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return
+	}
+	i := p.PixOffset(x, y)
+	s := p.Pix[i : i+4]
+	s[0], s[1], s[2], s[3] = c.R, c.G, c.B, c.A
+}
+
+// SubImage returns an image representing the portion of the image p visible through r.
+// The returned value shares pixels with the original image.
+func (p *RGBA128F) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(p.Rect)
+	if r.Empty() {
+		return &RGBA128F{}
+	}
+	i := p.PixOffset(r.Min.X, r.Min.Y)
+	return &RGBA128F{
+		Pix:    p.Pix[i:],
+		Stride: p.Stride,
+		Rect:   r,
+	}
+}
+
+// Opaque scans the entire image and reports whether it is fully opaque.
+func (p *RGBA128F) Opaque() bool {
+	if p.Rect.Empty() {
+		return true
+	}
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		i := p.PixOffset(p.Rect.Min.X, y)
+		for j := i + 3; j < i+p.Rect.Dx()*4; j += 4 {
+			if p.Pix[j] < 1 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// AtFuncF returns a float RGBA value of the pixel at (x, y).
+//
+// The values are alpha-premultiplied, and the nominal range is [0, 1].
+type AtFuncF func(x, y int) (r, g, b, a float32)
+
+// SetFuncF sets a float RGBA value to the pixel at (x, y).
+//
+// The values are alpha-premultiplied, and the nominal range is [0, 1].
+type SetFuncF func(x, y int, r, g, b, a float32)
+
+// NewAtFuncF returns an AtFuncF for a RGBA128F image.
+//
+// The values are not quantized nor clipped.
+func NewAtFuncF(p *RGBA128F) AtFuncF {
+	return func(x, y int) (r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		return s[0], s[1], s[2], s[3]
+	}
+}
+
+// NewSetFuncF returns a SetFuncF for a RGBA128F image.
+//
+// The values are not quantized nor clipped.
+func NewSetFuncF(p *RGBA128F) SetFuncF {
+	return func(x, y int, r, g, b, a float32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		s[0], s[1], s[2], s[3] = r, g, b, a
+	}
+}
@@ -0,0 +1,72 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestRGBA128FHighDynamicRange(t *testing.T) {
+	p := NewRGBA128F(image.Rect(-1, -1, 2, 2))
+	set := NewSetFuncF(p)
+	at := NewAtFuncF(p)
+	set(1, 0, 2.5, -0.5, 0.25, 1)
+	r, g, b, a := at(1, 0)
+	if r != 2.5 || g != -0.5 || b != 0.25 || a != 1 {
+		t.Fatalf("different value: got {%f %f %f %f}, want {2.5 -0.5 0.25 1}", r, g, b, a)
+	}
+	r1, g1, b1, a1 := p.At(1, 0).RGBA()
+	if r1 != 0xffff || g1 != 0 || b1 != 0x4000 || a1 != 0xffff {
+		t.Fatalf("different clipped color: got {%d %d %d %d}, want {65535 0 16384 65535}", r1, g1, b1, a1)
+	}
+	r2, g2, b2, a2 := NewAtFunc(p)(1, 0)
+	if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+		t.Fatalf("different color: got {%d %d %d %d}, want {%d %d %d %d}", r2, g2, b2, a2, r1, g1, b1, a1)
+	}
+}
+
+func TestRGBA128FOpaque(t *testing.T) {
+	p := NewRGBA128F(image.Rect(0, 0, 3, 3))
+	if p.Opaque() {
+		t.Fatal("transparent image is opaque")
+	}
+	for y := 0; y < 3; y++ {
+		for x := 0; x < 3; x++ {
+			p.Set(x, y, color.White)
+		}
+	}
+	if !p.Opaque() {
+		t.Fatal("opaque image is not opaque")
+	}
+	sub := p.SubImage(image.Rect(1, 1, 5, 5)).(*RGBA128F)
+	if sub.Bounds() != image.Rect(1, 1, 3, 3) {
+		t.Fatalf("unexpected sub image bounds: %s", sub.Bounds())
+	}
+	sub.Set(2, 2, color.Transparent)
+	if p.Opaque() || p.At(2, 2) != (ColorRGBA128F{}) {
+		t.Fatal("sub image doesn't share pixels")
+	}
+}
+
+func TestRGBA128FConvert(t *testing.T) {
+	bd := image.Rect(0, 0, 5, 5)
+	src := image.NewNRGBA64(bd)
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			src.Set(x, y, testColors[(y*bd.Dx()+x)%len(testColors)])
+		}
+	}
+	p := NewRGBA128F(bd)
+	Convert(p, src)
+	dst := image.NewRGBA64(bd)
+	Convert(dst, p)
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			r1, g1, b1, a1 := src.At(x, y).RGBA()
+			r2, g2, b2, a2 := dst.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				t.Fatalf("different color: pixel %dx%d: got {%d %d %d %d}, want {%d %d %d %d}", x, y, r2, g2, b2, a2, r1, g1, b1, a1)
+			}
+		}
+	}
+}
@@ -0,0 +1,558 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// RowReader reads the RGBA values of a horizontal span of pixels starting at (x, y).
+//
+// The values are stored in buf as r, g, b, a for each pixel.
+// It reads len(buf)/4 pixels.
+type RowReader func(x, y int, buf []uint32)
+
+// NewRowReader returns a RowReader for an Image.
+//
+// nolint: gocyclo
+func NewRowReader(p image.Image) RowReader {
+	switch p := p.(type) {
+	case *image.RGBA:
+		return newRowReaderRGBA(p)
+	case *image.RGBA64:
+		return newRowReaderRGBA64(p)
+	case *image.NRGBA:
+		return newRowReaderNRGBA(p)
+	case *image.NRGBA64:
+		return newRowReaderNRGBA64(p)
+	case *image.Alpha:
+		return newRowReaderAlpha(p)
+	case *image.Alpha16:
+		return newRowReaderAlpha16(p)
+	case *image.Gray:
+		return newRowReaderGray(p)
+	case *image.Gray16:
+		return newRowReaderGray16(p)
+	case *image.Paletted:
+		return newRowReaderPaletted(p)
+	case *image.YCbCr:
+		return newRowReaderYCbCr(p)
+	case *image.NYCbCrA:
+		return newRowReaderNYCbCrA(p)
+	case *image.CMYK:
+		return newRowReaderCMYK(p)
+	case *image.Uniform:
+		return newRowReaderUniform(p)
+	case *RGBA128F:
+		return newRowReaderRGBA128F(p)
+	default:
+		return newRowReaderDefault(p)
+	}
+}
+
+func newRowReaderRGBA(p *image.RGBA) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4 * 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+n]
+		for j, v := range s {
+			c := uint32(v)
+			buf[j] = c | c<<8
+		}
+	}
+}
+
+func newRowReaderRGBA64(p *image.RGBA64) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4 * 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*8
+		s := p.Pix[i : i+n*2]
+		for j := 0; j < n; j++ {
+			buf[j] = uint32(s[j*2])<<8 | uint32(s[j*2+1])
+		}
+	}
+}
+
+func newRowReaderNRGBA(p *image.NRGBA) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+n*4]
+		for j := 0; j < n; j++ {
+			ss := s[j*4 : j*4+4]
+			d := buf[j*4 : j*4+4]
+			a := uint32(ss[3])
+			a |= a << 8
+			if a == 0 {
+				d[0], d[1], d[2], d[3] = 0, 0, 0, 0
+				continue
+			}
+			r := uint32(ss[0])
+			r |= r << 8
+			g := uint32(ss[1])
+			g |= g << 8
+			b := uint32(ss[2])
+			b |= b << 8
+			if a != 0xffff {
+				r = r * a / 0xffff
+				g = g * a / 0xffff
+				b = b * a / 0xffff
+			}
+			d[0], d[1], d[2], d[3] = r, g, b, a
+		}
+	}
+}
+
+func newRowReaderNRGBA64(p *image.NRGBA64) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*8
+		s := p.Pix[i : i+n*8]
+		for j := 0; j < n; j++ {
+			ss := s[j*8 : j*8+8]
+			d := buf[j*4 : j*4+4]
+			a := uint32(ss[6])<<8 | uint32(ss[7])
+			if a == 0 {
+				d[0], d[1], d[2], d[3] = 0, 0, 0, 0
+				continue
+			}
+			r := uint32(ss[0])<<8 | uint32(ss[1])
+			g := uint32(ss[2])<<8 | uint32(ss[3])
+			b := uint32(ss[4])<<8 | uint32(ss[5])
+			if a != 0xffff {
+				r = r * a / 0xffff
+				g = g * a / 0xffff
+				b = b * a / 0xffff
+			}
+			d[0], d[1], d[2], d[3] = r, g, b, a
+		}
+	}
+}
+
+func newRowReaderAlpha(p *image.Alpha) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		s := p.Pix[i : i+n]
+		for j, v := range s {
+			a := uint32(v)
+			a |= a << 8
+			d := buf[j*4 : j*4+4]
+			d[0], d[1], d[2], d[3] = a, a, a, a
+		}
+	}
+}
+
+func newRowReaderAlpha16(p *image.Alpha16) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+		s := p.Pix[i : i+n*2]
+		for j := 0; j < n; j++ {
+			a := uint32(s[j*2])<<8 | uint32(s[j*2+1])
+			d := buf[j*4 : j*4+4]
+			d[0], d[1], d[2], d[3] = a, a, a, a
+		}
+	}
+}
+
+func newRowReaderGray(p *image.Gray) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		s := p.Pix[i : i+n]
+		for j, v := range s {
+			yy := uint32(v)
+			yy |= yy << 8
+			d := buf[j*4 : j*4+4]
+			d[0], d[1], d[2], d[3] = yy, yy, yy, 0xffff
+		}
+	}
+}
+
+func newRowReaderGray16(p *image.Gray16) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+		s := p.Pix[i : i+n*2]
+		for j := 0; j < n; j++ {
+			yy := uint32(s[j*2])<<8 | uint32(s[j*2+1])
+			d := buf[j*4 : j*4+4]
+			d[0], d[1], d[2], d[3] = yy, yy, yy, 0xffff
+		}
+	}
+}
+
+func newRowReaderPaletted(p *image.Paletted) RowReader {
+	pa := newPaletteRGBA(p.Palette)
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		s := p.Pix[i : i+n]
+		for j, v := range s {
+			c := pa[v]
+			d := buf[j*4 : j*4+4]
+			d[0], d[1], d[2], d[3] = c.r, c.g, c.b, c.a
+		}
+	}
+}
+
+func newRowReaderYCbCr(p *image.YCbCr) RowReader {
+	sx, sy := yCbCrSubsampleFactors(p.SubsampleRatio)
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		yi := (y-p.Rect.Min.Y)*p.YStride + (x - p.Rect.Min.X)
+		cy := (y/sy - p.Rect.Min.Y/sy) * p.CStride
+		ys := p.Y[yi : yi+n]
+		for j, yy := range ys {
+			ci := cy + ((x+j)/sx - p.Rect.Min.X/sx)
+			r, g, b := yCbCrToRGB(yy, p.Cb[ci], p.Cr[ci])
+			d := buf[j*4 : j*4+4]
+			d[0], d[1], d[2], d[3] = r, g, b, 0xffff
+		}
+	}
+}
+
+func newRowReaderNYCbCrA(p *image.NYCbCrA) RowReader {
+	sx, sy := yCbCrSubsampleFactors(p.SubsampleRatio)
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		ai := (y-p.Rect.Min.Y)*p.AStride + (x - p.Rect.Min.X)
+		yi := (y-p.Rect.Min.Y)*p.YStride + (x - p.Rect.Min.X)
+		cy := (y/sy - p.Rect.Min.Y/sy) * p.CStride
+		as := p.A[ai : ai+n]
+		for j, a8 := range as {
+			d := buf[j*4 : j*4+4]
+			a := uint32(a8) * 0x101
+			if a == 0 {
+				d[0], d[1], d[2], d[3] = 0, 0, 0, 0
+				continue
+			}
+			ci := cy + ((x+j)/sx - p.Rect.Min.X/sx)
+			r, g, b := yCbCrToRGB(p.Y[yi+j], p.Cb[ci], p.Cr[ci])
+			if a != 0xffff {
+				r = r * a / 0xffff
+				g = g * a / 0xffff
+				b = b * a / 0xffff
+			}
+			d[0], d[1], d[2], d[3] = r, g, b, a
+		}
+	}
+}
+
+// yCbCrSubsampleFactors returns the horizontal and vertical chroma subsampling factors for a subsample ratio.
+func yCbCrSubsampleFactors(sr image.YCbCrSubsampleRatio) (sx, sy int) {
+	switch sr {
+	case image.YCbCrSubsampleRatio422:
+		return 2, 1
+	case image.YCbCrSubsampleRatio420:
+		return 2, 2
+	case image.YCbCrSubsampleRatio440:
+		return 1, 2
+	case image.YCbCrSubsampleRatio411:
+		return 4, 1
+	case image.YCbCrSubsampleRatio410:
+		return 4, 2
+	default:
+		return 1, 1
+	}
+}
+
+// yCbCrToRGB converts a Y'CbCr triple to 16 bits RGB values.
+//
+// It uses the same formula as color.YCbCr.RGBA.
+func yCbCrToRGB(yy, cb, cr uint8) (r, g, b uint32) {
+	yy1 := int32(yy) * 0x10101
+	cb1 := int32(cb) - 128
+	cr1 := int32(cr) - 128
+	r1 := yy1 + 91881*cr1
+	if uint32(r1)&0xff000000 == 0 {
+		r1 >>= 8
+	} else {
+		r1 = ^(r1 >> 31) & 0xffff
+	}
+	g1 := yy1 - 22554*cb1 - 46802*cr1
+	if uint32(g1)&0xff000000 == 0 {
+		g1 >>= 8
+	} else {
+		g1 = ^(g1 >> 31) & 0xffff
+	}
+	b1 := yy1 + 116130*cb1
+	if uint32(b1)&0xff000000 == 0 {
+		b1 >>= 8
+	} else {
+		b1 = ^(b1 >> 31) & 0xffff
+	}
+	return uint32(r1), uint32(g1), uint32(b1)
+}
+
+func newRowReaderCMYK(p *image.CMYK) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+n*4]
+		for j := 0; j < n; j++ {
+			ss := s[j*4 : j*4+4]
+			d := buf[j*4 : j*4+4]
+			w := 0xffff - uint32(ss[3])*0x101
+			d[0] = (0xffff - uint32(ss[0])*0x101) * w / 0xffff
+			d[1] = (0xffff - uint32(ss[1])*0x101) * w / 0xffff
+			d[2] = (0xffff - uint32(ss[2])*0x101) * w / 0xffff
+			d[3] = 0xffff
+		}
+	}
+}
+
+func newRowReaderUniform(p *image.Uniform) RowReader {
+	return func(x, y int, buf []uint32) {
+		r, g, b, a := p.C.RGBA()
+		n := len(buf) / 4
+		for j := 0; j < n; j++ {
+			d := buf[j*4 : j*4+4]
+			d[0], d[1], d[2], d[3] = r, g, b, a
+		}
+	}
+}
+
+func newRowReaderRGBA128F(p *RGBA128F) RowReader {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+n*4]
+		for j := 0; j < n; j++ {
+			ss := s[j*4 : j*4+4]
+			d := buf[j*4 : j*4+4]
+			d[0], d[1], d[2], d[3] = ColorRGBA128F{ss[0], ss[1], ss[2], ss[3]}.RGBA()
+		}
+	}
+}
+
+func newRowReaderDefault(p image.Image) RowReader {
+	at := NewAtFunc(p)
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		for j := 0; j < n; j++ {
+			d := buf[j*4 : j*4+4]
+			d[0], d[1], d[2], d[3] = at(x+j, y)
+		}
+	}
+}
+
+// RowWriter writes the RGBA values of a horizontal span of pixels starting at (x, y).
+//
+// The values are read from buf as r, g, b, a for each pixel.
+// It writes len(buf)/4 pixels.
+type RowWriter func(x, y int, buf []uint32)
+
+// NewRowWriter returns a RowWriter for an Image.
+//
+// nolint: gocyclo
+func NewRowWriter(p draw.Image) RowWriter {
+	switch p := p.(type) {
+	case *image.RGBA:
+		return newRowWriterRGBA(p)
+	case *image.RGBA64:
+		return newRowWriterRGBA64(p)
+	case *image.NRGBA:
+		return newRowWriterNRGBA(p)
+	case *image.NRGBA64:
+		return newRowWriterNRGBA64(p)
+	case *image.Alpha:
+		return newRowWriterAlpha(p)
+	case *image.Alpha16:
+		return newRowWriterAlpha16(p)
+	case *image.Gray:
+		return newRowWriterGray(p)
+	case *image.Gray16:
+		return newRowWriterGray16(p)
+	case *image.Paletted:
+		return newRowWriterPaletted(p)
+	case *image.CMYK:
+		return newRowWriterCMYK(p)
+	case *RGBA128F:
+		return newRowWriterRGBA128F(p)
+	default:
+		return newRowWriterDefault(p)
+	}
+}
+
+func newRowWriterRGBA(p *image.RGBA) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4 * 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+n]
+		for j := range s {
+			s[j] = uint8(buf[j] >> 8)
+		}
+	}
+}
+
+func newRowWriterRGBA64(p *image.RGBA64) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4 * 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*8
+		s := p.Pix[i : i+n*2]
+		for j := 0; j < n; j++ {
+			v := uint16(buf[j])
+			s[j*2] = uint8(v >> 8)
+			s[j*2+1] = uint8(v)
+		}
+	}
+}
+
+func newRowWriterNRGBA(p *image.NRGBA) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+n*4]
+		for j := 0; j < n; j++ {
+			c := buf[j*4 : j*4+4]
+			r, g, b, a := RGBAToNRGBA(c[0], c[1], c[2], c[3])
+			d := s[j*4 : j*4+4]
+			d[0] = uint8(r >> 8)
+			d[1] = uint8(g >> 8)
+			d[2] = uint8(b >> 8)
+			d[3] = uint8(a >> 8)
+		}
+	}
+}
+
+func newRowWriterNRGBA64(p *image.NRGBA64) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*8
+		s := p.Pix[i : i+n*8]
+		for j := 0; j < n; j++ {
+			c := buf[j*4 : j*4+4]
+			r, g, b, a := RGBAToNRGBA(c[0], c[1], c[2], c[3])
+			d := s[j*8 : j*8+8]
+			d[0] = uint8(r >> 8)
+			d[1] = uint8(r)
+			d[2] = uint8(g >> 8)
+			d[3] = uint8(g)
+			d[4] = uint8(b >> 8)
+			d[5] = uint8(b)
+			d[6] = uint8(a >> 8)
+			d[7] = uint8(a)
+		}
+	}
+}
+
+func newRowWriterAlpha(p *image.Alpha) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		s := p.Pix[i : i+n]
+		for j := range s {
+			s[j] = uint8(buf[j*4+3] >> 8)
+		}
+	}
+}
+
+func newRowWriterAlpha16(p *image.Alpha16) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+		s := p.Pix[i : i+n*2]
+		for j := 0; j < n; j++ {
+			a16 := uint16(buf[j*4+3])
+			s[j*2] = uint8(a16 >> 8)
+			s[j*2+1] = uint8(a16)
+		}
+	}
+}
+
+func newRowWriterGray(p *image.Gray) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		s := p.Pix[i : i+n]
+		for j := range s {
+			c := buf[j*4 : j*4+4]
+			s[j] = uint8((19595*c[0] + 38470*c[1] + 7471*c[2] + 1<<15) >> 24)
+		}
+	}
+}
+
+func newRowWriterGray16(p *image.Gray16) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*2
+		s := p.Pix[i : i+n*2]
+		for j := 0; j < n; j++ {
+			c := buf[j*4 : j*4+4]
+			y16 := uint16((19595*c[0] + 38470*c[1] + 7471*c[2] + 1<<15) >> 16)
+			s[j*2] = uint8(y16 >> 8)
+			s[j*2+1] = uint8(y16)
+		}
+	}
+}
+
+func newRowWriterPaletted(p *image.Paletted) RowWriter {
+	pi := newPaletteIndex(newPaletteRGBA(p.Palette), 0)
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
+		s := p.Pix[i : i+n]
+		for j := range s {
+			c := buf[j*4 : j*4+4]
+			s[j] = uint8(pi.index(colorRGBA{c[0], c[1], c[2], c[3]}))
+		}
+	}
+}
+
+func newRowWriterCMYK(p *image.CMYK) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+n*4]
+		for j := 0; j < n; j++ {
+			c := buf[j*4 : j*4+4]
+			rr := c[0] >> 8
+			gg := c[1] >> 8
+			bb := c[2] >> 8
+			w := rr
+			if w < gg {
+				w = gg
+			}
+			if w < bb {
+				w = bb
+			}
+			d := s[j*4 : j*4+4]
+			if w == 0 {
+				d[0], d[1], d[2], d[3] = 0, 0, 0, 0xff
+				continue
+			}
+			d[0] = uint8((w - rr) * 0xff / w)
+			d[1] = uint8((w - gg) * 0xff / w)
+			d[2] = uint8((w - bb) * 0xff / w)
+			d[3] = uint8(0xff - w)
+		}
+	}
+}
+
+func newRowWriterRGBA128F(p *RGBA128F) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4 * 4
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+n]
+		for j := range s {
+			s[j] = float32(buf[j]) / 0xffff
+		}
+	}
+}
+
+func newRowWriterDefault(p draw.Image) RowWriter {
+	return func(x, y int, buf []uint32) {
+		n := len(buf) / 4
+		for j := 0; j < n; j++ {
+			c := buf[j*4 : j*4+4]
+			p.Set(x+j, y, color.RGBA64{
+				R: uint16(c[0]),
+				G: uint16(c[1]),
+				B: uint16(c[2]),
+				A: uint16(c[3]),
+			})
+		}
+	}
+}
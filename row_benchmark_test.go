@@ -0,0 +1,40 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"testing"
+)
+
+func BenchmarkNewRowReader(b *testing.B) {
+	bd := image.Rect(0, 0, 256, 1)
+	for _, newImageFunc := range testImageFuncs {
+		p := newImageFunc(bd)
+		b.Run(fmt.Sprintf("%T", p), func(b *testing.B) {
+			read := NewRowReader(p)
+			buf := make([]uint32, bd.Dx()*4)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				read(0, 0, buf)
+			}
+		})
+	}
+}
+
+func BenchmarkNewRowWriter(b *testing.B) {
+	bd := image.Rect(0, 0, 256, 1)
+	for _, newImageDrawFunc := range testDrawImageFuncs {
+		p := newImageDrawFunc(bd)
+		b.Run(fmt.Sprintf("%T", p), func(b *testing.B) {
+			write := NewRowWriter(p)
+			buf := make([]uint32, bd.Dx()*4)
+			for i := range buf {
+				buf[i] = 0xffff
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				write(0, 0, buf)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+	"testing"
+)
+
+func TestNewRowReader(t *testing.T) {
+	bd := image.Rect(-3, -1, 6, 4)
+	for _, newImageFunc := range testImageFuncs {
+		p := newImageFunc(bd)
+		t.Run(fmt.Sprintf("%T", p), func(t *testing.T) {
+			set := newSimpleSetFunc(p)
+			for y := bd.Min.Y; y < bd.Max.Y; y++ {
+				for x := bd.Min.X; x < bd.Max.X; x++ {
+					set(x, y, testColors[rand.Intn(len(testColors))])
+				}
+			}
+			at := NewAtFunc(p)
+			read := NewRowReader(p)
+			for y := bd.Min.Y; y < bd.Max.Y; y++ {
+				for x0 := bd.Min.X; x0 < bd.Max.X; x0++ {
+					buf := make([]uint32, (bd.Max.X-x0)*4)
+					read(x0, y, buf)
+					for x := x0; x < bd.Max.X; x++ {
+						c := buf[(x-x0)*4 : (x-x0)*4+4]
+						r, g, b, a := at(x, y)
+						if c[0] != r || c[1] != g || c[2] != b || c[3] != a {
+							t.Fatalf("different color: pixel %dx%d, start %d: got {%d %d %d %d}, want {%d %d %d %d}", x, y, x0, c[0], c[1], c[2], c[3], r, g, b, a)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewRowWriter(t *testing.T) {
+	bd := image.Rect(-3, -1, 6, 4)
+	for _, newImageDrawFunc := range testDrawImageFuncs {
+		p1 := newImageDrawFunc(bd)
+		p2 := newImageDrawFunc(bd)
+		t.Run(fmt.Sprintf("%T", p1), func(t *testing.T) {
+			write := NewRowWriter(p1)
+			set := NewSetFunc(p2)
+			buf := make([]uint32, bd.Dx()*4)
+			for y := bd.Min.Y; y < bd.Max.Y; y++ {
+				for x := bd.Min.X; x < bd.Max.X; x++ {
+					c := color.RGBA64Model.Convert(testColors[rand.Intn(len(testColors))]).(color.RGBA64)
+					r, g, b, a := uint32(c.R), uint32(c.G), uint32(c.B), uint32(c.A)
+					i := (x - bd.Min.X) * 4
+					buf[i], buf[i+1], buf[i+2], buf[i+3] = r, g, b, a
+					set(x, y, r, g, b, a)
+				}
+				write(bd.Min.X, y, buf)
+			}
+			for y := bd.Min.Y; y < bd.Max.Y; y++ {
+				for x := bd.Min.X; x < bd.Max.X; x++ {
+					r1, g1, b1, a1 := p1.At(x, y).RGBA()
+					r2, g2, b2, a2 := p2.At(x, y).RGBA()
+					if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+						t.Fatalf("different color: pixel %dx%d: got {%d %d %d %d}, want {%d %d %d %d}", x, y, r1, g1, b1, a1, r2, g2, b2, a2)
+					}
+				}
+			}
+		})
+	}
+}
+
+var testImageFuncs = []func(image.Rectangle) image.Image{
+	func(r image.Rectangle) image.Image {
+		return image.NewRGBA(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewRGBA64(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewNRGBA(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewNRGBA64(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewAlpha(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewAlpha16(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewGray(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewGray16(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewCMYK(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewPaletted(r, testPalette)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewYCbCr(r, image.YCbCrSubsampleRatio444)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewYCbCr(r, image.YCbCrSubsampleRatio422)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewYCbCr(r, image.YCbCrSubsampleRatio420)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewYCbCr(r, image.YCbCrSubsampleRatio440)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewYCbCr(r, image.YCbCrSubsampleRatio411)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewYCbCr(r, image.YCbCrSubsampleRatio410)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewNYCbCrA(r, image.YCbCrSubsampleRatio444)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewNYCbCrA(r, image.YCbCrSubsampleRatio422)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewNYCbCrA(r, image.YCbCrSubsampleRatio420)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewNYCbCrA(r, image.YCbCrSubsampleRatio440)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewNYCbCrA(r, image.YCbCrSubsampleRatio411)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewNYCbCrA(r, image.YCbCrSubsampleRatio410)
+	},
+	func(r image.Rectangle) image.Image {
+		return image.NewUniform(color.RGBA{})
+	},
+	func(r image.Rectangle) image.Image {
+		return NewRGBA128F(r)
+	},
+	func(r image.Rectangle) image.Image {
+		return &testImageDefault{image.NewRGBA(r)}
+	},
+}
+
+var testDrawImageFuncs = []func(image.Rectangle) draw.Image{
+	func(r image.Rectangle) draw.Image {
+		return image.NewRGBA(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return image.NewRGBA64(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return image.NewNRGBA(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return image.NewNRGBA64(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return image.NewAlpha(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return image.NewAlpha16(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return image.NewGray(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return image.NewGray16(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return image.NewCMYK(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return image.NewPaletted(r, testPalette)
+	},
+	func(r image.Rectangle) draw.Image {
+		return NewRGBA128F(r)
+	},
+	func(r image.Rectangle) draw.Image {
+		return &testImageDefault{image.NewRGBA(r)}
+	},
+}
@@ -34,6 +34,8 @@ func NewSetFunc(p draw.Image) SetFunc {
 		return newSetFuncPaletted(p)
 	case *image.CMYK:
 		return newSetFuncCMYK(p)
+	case *RGBA128F:
+		return newSetFuncRGBA128F(p)
 	default:
 		return newSetFuncDefault(p)
 	}
@@ -129,11 +131,7 @@ func newSetFuncGray16(p *image.Gray16) SetFunc {
 }
 
 func newSetFuncPaletted(p *image.Paletted) SetFunc {
-	pa := newPaletteRGBA(p.Palette)
-	return func(x, y int, r, g, b, a uint32) {
-		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*1
-		p.Pix[i] = uint8(pa.index(colorRGBA{r, g, b, a}))
-	}
+	return NewSetFuncPalettedCache(p, 0)
 }
 
 type colorRGBA struct {
@@ -205,6 +203,17 @@ func newSetFuncCMYK(p *image.CMYK) SetFunc {
 	}
 }
 
+func newSetFuncRGBA128F(p *RGBA128F) SetFunc {
+	return func(x, y int, r, g, b, a uint32) {
+		i := (y-p.Rect.Min.Y)*p.Stride + (x-p.Rect.Min.X)*4
+		s := p.Pix[i : i+4]
+		s[0] = float32(r) / 0xffff
+		s[1] = float32(g) / 0xffff
+		s[2] = float32(b) / 0xffff
+		s[3] = float32(a) / 0xffff
+	}
+}
+
 func newSetFuncDefault(p draw.Image) SetFunc {
 	return func(x, y int, r, g, b, a uint32) {
 		p.Set(x, y, color.RGBA64{
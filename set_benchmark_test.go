@@ -104,6 +104,12 @@ func BenchmarkNewSetFunc(b *testing.B) {
 				return image.NewCMYK(r)
 			},
 		},
+		{
+			name: "RGBA128F",
+			newImage: func(r image.Rectangle) draw.Image {
+				return NewRGBA128F(r)
+			},
+		},
 		{
 			name: "Default",
 			newImage: func(r image.Rectangle) draw.Image {
@@ -0,0 +1,363 @@
+package imageutil
+
+import (
+	"image"
+	"image/draw"
+	"math"
+	"sort"
+)
+
+// ToneCurve is a tone curve, compiled to a 16-bit lookup table.
+//
+// It maps a non-premultiplied 16-bit value to another value.
+type ToneCurve [1 << 16]uint16
+
+// NewIdentityCurve returns a ToneCurve that doesn't change the values.
+func NewIdentityCurve() *ToneCurve {
+	c := new(ToneCurve)
+	for i := range c {
+		c[i] = uint16(i)
+	}
+	return c
+}
+
+// NewLevelsCurve returns a levels ToneCurve.
+//
+// The values lower than black become 0, the values greater than white become 1, and the values in between are stretched to [0, 1] then raised to the power 1/gamma.
+// black and white are in [0, 1].
+// If gamma is lower than or equal to 0, 1 is used.
+func NewLevelsCurve(black, white, gamma float64) *ToneCurve {
+	if gamma <= 0 {
+		gamma = 1
+	}
+	return newToneCurveFunc(func(v float64) float64 {
+		if v <= black {
+			return 0
+		}
+		if v >= white {
+			return 1
+		}
+		return math.Pow((v-black)/(white-black), 1/gamma)
+	})
+}
+
+// CurvePoint is a control point of a curve.
+//
+// The values are in [0, 1].
+type CurvePoint struct {
+	X, Y float64
+}
+
+// NewCurve returns a ToneCurve that passes through control points.
+//
+// The points are interpolated with a monotone cubic spline (Fritsch-Carlson), so the curve doesn't overshoot.
+// The curve is constant before the first point and after the last point.
+// The points are sorted by X, and the points with the same X are ignored, except the first one.
+// If there is no point, it returns the identity curve.
+func NewCurve(points []CurvePoint) *ToneCurve {
+	ps := append([]CurvePoint(nil), points...)
+	sort.SliceStable(ps, func(i, j int) bool {
+		return ps[i].X < ps[j].X
+	})
+	n := 0
+	for _, p := range ps {
+		if n > 0 && p.X == ps[n-1].X {
+			continue
+		}
+		ps[n] = p
+		n++
+	}
+	ps = ps[:n]
+	switch n {
+	case 0:
+		return NewIdentityCurve()
+	case 1:
+		return newToneCurveFunc(func(v float64) float64 {
+			return ps[0].Y
+		})
+	}
+	// The secants and the tangents.
+	ds := make([]float64, n-1)
+	for i := range ds {
+		ds[i] = (ps[i+1].Y - ps[i].Y) / (ps[i+1].X - ps[i].X)
+	}
+	ms := make([]float64, n)
+	ms[0], ms[n-1] = ds[0], ds[n-2]
+	for i := 1; i < n-1; i++ {
+		if ds[i-1]*ds[i] > 0 {
+			ms[i] = (ds[i-1] + ds[i]) / 2
+		}
+	}
+	for i, d := range ds {
+		if d == 0 {
+			ms[i], ms[i+1] = 0, 0
+			continue
+		}
+		a, b := ms[i]/d, ms[i+1]/d
+		if s := a*a + b*b; s > 9 {
+			t := 3 / math.Sqrt(s)
+			ms[i], ms[i+1] = t*a*d, t*b*d
+		}
+	}
+	return newToneCurveFunc(func(v float64) float64 {
+		if v <= ps[0].X {
+			return ps[0].Y
+		}
+		if v >= ps[n-1].X {
+			return ps[n-1].Y
+		}
+		i := sort.Search(n, func(i int) bool {
+			return ps[i].X > v
+		}) - 1
+		h := ps[i+1].X - ps[i].X
+		t := (v - ps[i].X) / h
+		t2, t3 := t*t, t*t*t
+		return (2*t3-3*t2+1)*ps[i].Y + (t3-2*t2+t)*h*ms[i] + (-2*t3+3*t2)*ps[i+1].Y + (t3-t2)*h*ms[i+1]
+	})
+}
+
+// newToneCurveFunc returns a ToneCurve for a function with values in [0, 1].
+//
+// The results of the function are clipped.
+func newToneCurveFunc(f func(v float64) float64) *ToneCurve {
+	c := new(ToneCurve)
+	for i := range c {
+		c[i] = uint16(floatToUint16(f(float64(i) / 0xffff)))
+	}
+	return c
+}
+
+// ToneOptions are the options of the tone functions.
+type ToneOptions struct {
+	// Parallel is used to process the image concurrently.
+	Parallel *Parallel
+}
+
+// ApplyCurves applies a ToneCurve to each color channel of src, and writes the result to dst.
+//
+// It processes the intersection of the bounds of dst and src.
+// dst and src can be the same image.
+// A nil ToneCurve doesn't change the channel.
+// The curves are applied to the non-premultiplied values (see RGBAToNRGBA and NRGBAToRGBA), and the alpha is not changed.
+// The values are read with NewAtFunc and written with NewSetFunc.
+// If opts is nil, the default options are used.
+func ApplyCurves(dst draw.Image, src image.Image, r, g, b *ToneCurve, opts *ToneOptions) {
+	cs := [3]*ToneCurve{r, g, b}
+	for i, c := range cs {
+		if c == nil {
+			cs[i] = NewIdentityCurve()
+		}
+	}
+	toneApply(dst, src, opts, func(x, y int, r, g, b, a uint32) (uint32, uint32, uint32) {
+		return uint32(cs[0][r]), uint32(cs[1][g]), uint32(cs[2][b])
+	})
+}
+
+// Levels applies a levels ToneCurve to the color channels of src, and writes the result to dst.
+//
+// See NewLevelsCurve and ApplyCurves.
+func Levels(dst draw.Image, src image.Image, black, white, gamma float64, opts *ToneOptions) {
+	c := NewLevelsCurve(black, white, gamma)
+	ApplyCurves(dst, src, c, c, c, opts)
+}
+
+// AutoContrast stretches the luminance range of src to [0, 1], and writes the result to dst.
+//
+// The black and white points are the percentiles clip and 100-clip of the luminance (see Histogram.Percentile), with the transparent pixels ignored.
+// The same levels are applied to all the color channels, so the hue is kept.
+//
+// See Levels.
+func AutoContrast(dst draw.Image, src image.Image, clip float64, opts *ToneOptions) {
+	if opts == nil {
+		opts = new(ToneOptions)
+	}
+	h := NewHistogram(src, &HistogramOptions{
+		Bins16:   true,
+		Mode:     HistogramIgnoreTransparent,
+		Parallel: opts.Parallel,
+	})
+	black := h.Percentile(HistogramLuminance, clip)
+	white := h.Percentile(HistogramLuminance, 100-clip)
+	if white <= black {
+		Levels(dst, src, 0, 1, 1, opts)
+		return
+	}
+	Levels(dst, src, black, white, 1, opts)
+}
+
+// Equalize equalizes the histogram of the luminance of src, and writes the result to dst.
+//
+// The transparent pixels are ignored.
+// The same offset is added to all the color channels of a pixel, so the color differences are kept.
+// If all the pixels have the same luminance, the image is not changed.
+//
+// See ApplyCurves.
+func Equalize(dst draw.Image, src image.Image, opts *ToneOptions) {
+	if opts == nil {
+		opts = new(ToneOptions)
+	}
+	h := NewHistogram(src, &HistogramOptions{
+		Bins16:   true,
+		Mode:     HistogramIgnoreTransparent,
+		Parallel: opts.Parallel,
+	})
+	bins := h.Bins[HistogramLuminance]
+	// min is the weight of the first non-empty bin, which is mapped to 0.
+	var total, min float64
+	for _, v := range bins {
+		if min == 0 {
+			min = v
+		}
+		total += v
+	}
+	// If there is no contrast to stretch, the identity curve keeps the image.
+	c := NewIdentityCurve()
+	if total > min {
+		var sum float64
+		for i, v := range bins {
+			sum += v
+			c[i] = uint16(floatToUint16((sum - min) / (total - min)))
+		}
+	}
+	toneApplyLuminance(dst, src, opts, func(x, y int, l uint32) uint32 {
+		return uint32(c[l])
+	})
+}
+
+// CLAHE applies a contrast limited adaptive histogram equalization to the luminance of src, and writes the result to dst.
+//
+// The image is split in a grid of tiles x tiles tiles, and the luminance histogram of each tile is equalized.
+// The bins of the histograms (256 per tile) are clipped to clipLimit times the mean bin value, and the excess is redistributed uniformly.
+// A lower clipLimit gives less contrast (1 doesn't change the image much), and 0 disables the clipping.
+// The mappings of the 4 nearest tiles are interpolated bilinearly for each pixel.
+// The histograms are computed before writing to dst, so dst and src can be the same image.
+//
+// See Equalize.
+func CLAHE(dst draw.Image, src image.Image, tiles int, clipLimit float64, opts *ToneOptions) {
+	if opts == nil {
+		opts = new(ToneOptions)
+	}
+	bd := src.Bounds()
+	if bd.Empty() {
+		return
+	}
+	if tiles < 1 {
+		tiles = 1
+	}
+	tw, th := ceilDiv(bd.Dx(), tiles), ceilDiv(bd.Dy(), tiles)
+	nx, ny := ceilDiv(bd.Dx(), tw), ceilDiv(bd.Dy(), th)
+	maps := make([][256]float64, nx*ny)
+	at := NewAtFunc(src)
+	gp := *opts.Parallel.orDefault()
+	gp.TileWidth, gp.TileHeight, gp.MinTileSize = 0, 1, -1
+	gp.Run(image.Rect(0, 0, nx, ny), func(r image.Rectangle) {
+		for ty := r.Min.Y; ty < r.Max.Y; ty++ {
+			for tx := r.Min.X; tx < r.Max.X; tx++ {
+				tr := image.Rect(tx*tw, ty*th, (tx+1)*tw, (ty+1)*th).Add(bd.Min).Intersect(bd)
+				var h [256]float64
+				for y := tr.Min.Y; y < tr.Max.Y; y++ {
+					for x := tr.Min.X; x < tr.Max.X; x++ {
+						cr, cg, cb, ca := RGBAToNRGBA(at(x, y))
+						if ca != 0 {
+							h[histogramLuminance(cr, cg, cb)>>8]++
+						}
+					}
+				}
+				maps[ty*nx+tx] = claheMap(h, clipLimit)
+			}
+		}
+	})
+	toneApplyLuminance(dst, src, opts, func(x, y int, l uint32) uint32 {
+		tx0, tx1, wx := claheNeighbors(x-bd.Min.X, tw, nx)
+		ty0, ty1, wy := claheNeighbors(y-bd.Min.Y, th, ny)
+		i := l >> 8
+		v := (1-wy)*((1-wx)*maps[ty0*nx+tx0][i]+wx*maps[ty0*nx+tx1][i]) +
+			wy*((1-wx)*maps[ty1*nx+tx0][i]+wx*maps[ty1*nx+tx1][i])
+		return floatToUint16(v)
+	})
+}
+
+// claheMap returns the equalization mapping of a clipped histogram.
+func claheMap(h [256]float64, clipLimit float64) [256]float64 {
+	var total float64
+	for _, v := range h {
+		total += v
+	}
+	var m [256]float64
+	if total == 0 {
+		for i := range m {
+			m[i] = float64(i) / 0xff
+		}
+		return m
+	}
+	if clipLimit > 0 {
+		limit := math.Max(clipLimit*total/256, 1)
+		var excess float64
+		for i, v := range h {
+			if v > limit {
+				excess += v - limit
+				h[i] = limit
+			}
+		}
+		for i := range h {
+			h[i] += excess / 256
+		}
+	}
+	var sum float64
+	for i, v := range h {
+		sum += v
+		m[i] = sum / total
+	}
+	return m
+}
+
+// claheNeighbors returns the indices of the 2 tiles around the position v, and the weight of the second tile.
+func claheNeighbors(v, size, n int) (i0, i1 int, w float64) {
+	f := (float64(v)+0.5)/float64(size) - 0.5
+	if f <= 0 {
+		return 0, 0, 0
+	}
+	i0 = int(f)
+	if i0 >= n-1 {
+		return n - 1, n - 1, 0
+	}
+	return i0, i0 + 1, f - float64(i0)
+}
+
+// toneApply applies a function to the non-premultiplied color values of the pixels of src, and writes the result to dst.
+func toneApply(dst draw.Image, src image.Image, opts *ToneOptions, f func(x, y int, r, g, b, a uint32) (uint32, uint32, uint32)) {
+	if opts == nil {
+		opts = new(ToneOptions)
+	}
+	r := dst.Bounds().Intersect(src.Bounds())
+	if r.Empty() {
+		return
+	}
+	at := NewAtFunc(src)
+	set := NewSetFunc(dst)
+	opts.Parallel.Run(r, func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				cr, cg, cb, ca := RGBAToNRGBA(at(x, y))
+				if ca == 0 {
+					set(x, y, 0, 0, 0, 0)
+					continue
+				}
+				cr, cg, cb = f(x, y, cr, cg, cb, ca)
+				cr, cg, cb, ca = NRGBAToRGBA(cr, cg, cb, ca)
+				set(x, y, cr, cg, cb, ca)
+			}
+		}
+	})
+}
+
+// toneApplyLuminance maps the luminance of the pixels of src, and writes the result to dst.
+//
+// The difference between the new and the old luminance is added to the color values.
+func toneApplyLuminance(dst draw.Image, src image.Image, opts *ToneOptions, f func(x, y int, l uint32) uint32) {
+	toneApply(dst, src, opts, func(x, y int, r, g, b, a uint32) (uint32, uint32, uint32) {
+		l := histogramLuminance(r, g, b)
+		d := int32(f(x, y, l)) - int32(l)
+		return uint32(clampInt32(int32(r)+d, 0, 0xffff)), uint32(clampInt32(int32(g)+d, 0, 0xffff)), uint32(clampInt32(int32(b)+d, 0, 0xffff))
+	})
+}
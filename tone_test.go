@@ -0,0 +1,194 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestNewLevelsCurve(t *testing.T) {
+	for _, tc := range []struct {
+		black, white, gamma float64
+		v, expected         float64
+	}{
+		{0.2, 0.6, 1, 0.4, 0.5},
+		{0.2, 0.6, 1, 0.1, 0},
+		{0.2, 0.6, 1, 0.7, 1},
+		{0.2, 0.6, 2, 0.4, math.Sqrt(0.5)},
+		{0, 1, 0, 0.3, 0.3},
+	} {
+		c := NewLevelsCurve(tc.black, tc.white, tc.gamma)
+		v := float64(c[uint32(tc.v*0xffff+0.5)]) / 0xffff
+		if math.Abs(v-tc.expected) > 0.001 {
+			t.Fatalf("unexpected value for %+v: got %f, want %f", tc, v, tc.expected)
+		}
+	}
+}
+
+func TestNewCurve(t *testing.T) {
+	points := []CurvePoint{
+		{0.5, 0.7},
+		{0, 0.1},
+		{1, 0.9},
+		{0.25, 0.2},
+		{0.5, 0.3},
+	}
+	c := NewCurve(points)
+	for _, p := range points[:4] {
+		v := float64(c[uint32(p.X*0xffff+0.5)]) / 0xffff
+		if math.Abs(v-p.Y) > 0.001 {
+			t.Fatalf("unexpected value at %f: got %f, want %f", p.X, v, p.Y)
+		}
+	}
+	for i := 1; i < len(c); i++ {
+		if c[i] < c[i-1] {
+			t.Fatalf("curve is not monotone at %d: %d < %d", i, c[i], c[i-1])
+		}
+	}
+}
+
+func TestNewCurveIdentity(t *testing.T) {
+	for _, c := range []*ToneCurve{
+		NewCurve(nil),
+		NewCurve([]CurvePoint{{0, 0}, {1, 1}}),
+		NewCurve([]CurvePoint{{0, 0}, {0.3, 0.3}, {1, 1}}),
+	} {
+		for i, v := range c {
+			if testAbsDiff(uint32(v), uint32(i)) > 1 {
+				t.Fatalf("unexpected value at %d: got %d", i, v)
+			}
+		}
+	}
+}
+
+func TestApplyCurves(t *testing.T) {
+	p := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	p.SetNRGBA(0, 0, color.NRGBA{0x20, 0x40, 0x60, 0x80})
+	p.SetNRGBA(1, 0, color.NRGBA{0xff, 0xff, 0xff, 0xff})
+	p.SetNRGBA(2, 0, color.NRGBA{0, 0, 0, 0})
+	invert := NewCurve([]CurvePoint{{0, 1}, {1, 0}})
+	dst := image.NewNRGBA(p.Bounds())
+	ApplyCurves(dst, p, invert, nil, invert, nil)
+	for i, expected := range []color.NRGBA{
+		{0xdf, 0x40, 0x9f, 0x80},
+		{0, 0xff, 0, 0xff},
+		{0, 0, 0, 0},
+	} {
+		c := dst.NRGBAAt(i, 0)
+		if testAbsDiff(uint32(c.R), uint32(expected.R)) > 1 || testAbsDiff(uint32(c.G), uint32(expected.G)) > 1 || testAbsDiff(uint32(c.B), uint32(expected.B)) > 1 || c.A != expected.A {
+			t.Fatalf("unexpected color at %d: got %v, want %v", i, c, expected)
+		}
+	}
+}
+
+func TestLevelsInPlace(t *testing.T) {
+	p := testNewRandomImage(image.Rect(-3, 2, 20, 15))
+	dst := image.NewNRGBA(p.Bounds())
+	Levels(dst, p, 0.1, 0.8, 1.5, nil)
+	Levels(p, p, 0.1, 0.8, 1.5, &ToneOptions{Parallel: &Parallel{TileHeight: 3, MinTileSize: 1}})
+	for i, v := range p.Pix {
+		if v != dst.Pix[i] {
+			t.Fatalf("unexpected value at %d: got %d, want %d", i, v, dst.Pix[i])
+		}
+	}
+}
+
+func TestAutoContrast(t *testing.T) {
+	p := testNewLowContrastImage()
+	dst := image.NewGray(p.Bounds())
+	AutoContrast(dst, p, 0, nil)
+	st := NewHistogram(dst, nil).Stats(HistogramLuminance)
+	if st.Min != 0 || st.Max != 1 {
+		t.Fatalf("unexpected range: got [%f, %f], want [0, 1]", st.Min, st.Max)
+	}
+}
+
+func TestEqualize(t *testing.T) {
+	p := testNewLowContrastImage()
+	dst := image.NewGray(p.Bounds())
+	Equalize(dst, p, nil)
+	h := NewHistogram(dst, nil)
+	st := h.Stats(HistogramLuminance)
+	if st.Min != 0 || st.Max != 1 {
+		t.Fatalf("unexpected range: got [%f, %f], want [0, 1]", st.Min, st.Max)
+	}
+	// The histogram of the result is approximately uniform.
+	if m := h.Percentile(HistogramLuminance, 50); math.Abs(m-0.5) > 0.05 {
+		t.Fatalf("unexpected median: got %f, want 0.5", m)
+	}
+}
+
+func TestEqualizeUniform(t *testing.T) {
+	for _, v := range []uint8{0, 0x80, 0xff} {
+		p := image.NewGray(image.Rect(0, 0, 8, 8))
+		for i := range p.Pix {
+			p.Pix[i] = v
+		}
+		dst := image.NewGray(p.Bounds())
+		Equalize(dst, p, nil)
+		for i, got := range dst.Pix {
+			if got != v {
+				t.Fatalf("unexpected value at %d: got %#x, want %#x", i, got, v)
+			}
+		}
+	}
+}
+
+func TestCLAHE(t *testing.T) {
+	p := testNewLowContrastImage()
+	st0 := NewHistogram(p, nil).Stats(HistogramLuminance)
+	var prev float64
+	for _, clipLimit := range []float64{1.5, 4, 0} {
+		dst := image.NewGray(p.Bounds())
+		CLAHE(dst, p, 4, clipLimit, &ToneOptions{Parallel: &Parallel{TileHeight: 5, MinTileSize: 1}})
+		st := NewHistogram(dst, nil).Stats(HistogramLuminance)
+		if st.StdDev <= st0.StdDev || st.StdDev < prev {
+			t.Fatalf("unexpected standard deviation for clip limit %f: got %f, want > %f and >= %f", clipLimit, st.StdDev, st0.StdDev, prev)
+		}
+		prev = st.StdDev
+		// In place.
+		q := image.NewGray(p.Bounds())
+		copy(q.Pix, p.Pix)
+		CLAHE(q, q, 4, clipLimit, nil)
+		for i, v := range q.Pix {
+			if v != dst.Pix[i] {
+				t.Fatalf("unexpected in place value at %d: got %d, want %d", i, v, dst.Pix[i])
+			}
+		}
+	}
+}
+
+func TestCLAHESmall(t *testing.T) {
+	p := testNewRandomImage(image.Rect(0, 0, 3, 2))
+	CLAHE(image.NewRGBA(p.Bounds()), p, 8, 2, nil)
+	CLAHE(image.NewRGBA(image.Rectangle{}), image.NewRGBA(image.Rectangle{}), 8, 2, nil)
+}
+
+// testNewLowContrastImage returns a gray image with values in [0x60, 0xa0[.
+func testNewLowContrastImage() *image.Gray {
+	p := image.NewGray(image.Rect(0, 0, 64, 48))
+	for y := p.Rect.Min.Y; y < p.Rect.Max.Y; y++ {
+		for x := p.Rect.Min.X; x < p.Rect.Max.X; x++ {
+			p.SetGray(x, y, color.Gray{uint8(0x60 + (x*7+y*13)%0x40)})
+		}
+	}
+	return p
+}
+
+func BenchmarkApplyCurves(b *testing.B) {
+	p := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	dst := image.NewNRGBA(p.Bounds())
+	c := NewLevelsCurve(0.1, 0.9, 1.2)
+	for i := 0; i < b.N; i++ {
+		ApplyCurves(dst, p, c, c, c, nil)
+	}
+}
+
+func BenchmarkCLAHE(b *testing.B) {
+	p := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	dst := image.NewNRGBA(p.Bounds())
+	for i := 0; i < b.N; i++ {
+		CLAHE(dst, p, 8, 2, nil)
+	}
+}
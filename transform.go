@@ -0,0 +1,465 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// Affine is an affine transformation matrix.
+//
+// It maps (x, y) to (m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]).
+// The y axis points down, like in images.
+type Affine [6]float64
+
+// AffineIdentity is the identity transformation.
+var AffineIdentity = Affine{1, 0, 0, 0, 1, 0}
+
+// AffineTranslate returns a translation.
+func AffineTranslate(tx, ty float64) Affine {
+	return Affine{1, 0, tx, 0, 1, ty}
+}
+
+// AffineScale returns a scaling.
+func AffineScale(sx, sy float64) Affine {
+	return Affine{sx, 0, 0, 0, sy, 0}
+}
+
+// AffineRotate returns a clockwise rotation around the origin, in degrees.
+//
+// The multiples of 90 degrees are exact.
+func AffineRotate(angle float64) Affine {
+	sin, cos := sinCosDegrees(angle)
+	return Affine{cos, -sin, 0, sin, cos, 0}
+}
+
+// AffineSkew returns a skew transformation.
+//
+// It maps (x, y) to (x + kx*y, y + ky*x).
+func AffineSkew(kx, ky float64) Affine {
+	return Affine{1, kx, 0, ky, 1, 0}
+}
+
+// Mul returns the transformation that applies n, then m.
+func (m Affine) Mul(n Affine) Affine {
+	return Affine{
+		m[0]*n[0] + m[1]*n[3],
+		m[0]*n[1] + m[1]*n[4],
+		m[0]*n[2] + m[1]*n[5] + m[2],
+		m[3]*n[0] + m[4]*n[3],
+		m[3]*n[1] + m[4]*n[4],
+		m[3]*n[2] + m[4]*n[5] + m[5],
+	}
+}
+
+// Invert returns the inverse transformation.
+//
+// It returns false if the transformation is not invertible.
+func (m Affine) Invert() (Affine, bool) {
+	det := m[0]*m[4] - m[1]*m[3]
+	if det == 0 || math.IsNaN(det) || math.IsInf(det, 0) {
+		return Affine{}, false
+	}
+	return Affine{
+		m[4] / det,
+		-m[1] / det,
+		(m[1]*m[5] - m[4]*m[2]) / det,
+		-m[3] / det,
+		m[0] / det,
+		(m[3]*m[2] - m[0]*m[5]) / det,
+	}, true
+}
+
+// Apply returns the transformed point.
+func (m Affine) Apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[1]*y + m[2], m[3]*x + m[4]*y + m[5]
+}
+
+// isExact reports whether the transformation maps the pixels of the integer grid to pixels of the integer grid, without interpolation.
+//
+// It is true for the integer translations combined with the rotations of multiples of 90 degrees and the flips.
+func (m Affine) isExact() bool {
+	isUnit := func(v float64) bool {
+		return v == 0 || v == 1 || v == -1
+	}
+	for _, v := range m {
+		if v != math.Trunc(v) {
+			return false
+		}
+	}
+	return isUnit(m[0]) && isUnit(m[1]) && isUnit(m[3]) && isUnit(m[4]) && math.Abs(m[0]*m[4]-m[1]*m[3]) == 1
+}
+
+// sinCosDegrees returns the sine and cosine of an angle in degrees, exactly for the multiples of 90 degrees.
+func sinCosDegrees(angle float64) (sin, cos float64) {
+	a := math.Mod(angle, 360)
+	if a < 0 {
+		a += 360
+	}
+	switch a {
+	case 0:
+		return 0, 1
+	case 90:
+		return 1, 0
+	case 180:
+		return 0, -1
+	case 270:
+		return -1, 0
+	}
+	return math.Sincos(a * math.Pi / 180)
+}
+
+// TransformBounds returns the smallest Rectangle containing the transformed pixels of r.
+func TransformBounds(r image.Rectangle, m Affine) image.Rectangle {
+	if r.Empty() {
+		return image.Rectangle{}
+	}
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for _, p := range [4]image.Point{r.Min, {r.Max.X, r.Min.Y}, {r.Min.X, r.Max.Y}, r.Max} {
+		x, y := m.Apply(float64(p.X), float64(p.Y))
+		minX, minY = math.Min(minX, x), math.Min(minY, y)
+		maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+	}
+	// The small errors of the floating point computation must not add a pixel.
+	const eps = 1e-9
+	return image.Rect(
+		int(math.Floor(minX+eps)),
+		int(math.Floor(minY+eps)),
+		int(math.Ceil(maxX-eps)),
+		int(math.Ceil(maxY-eps)),
+	)
+}
+
+// TransformOptions are the options of Transform.
+type TransformOptions struct {
+	// Filter is the interpolation filter.
+	// The filter is not stretched for downscaling, use Resize instead.
+	Filter ResizeFilter
+	// Background is the color of the pixels outside of the source image.
+	// If it's nil, the background is transparent.
+	Background color.Color
+	// Parallel is used to process the image concurrently.
+	Parallel *Parallel
+}
+
+// Transform writes src transformed by m to dst.
+//
+// m maps the coordinates of src to the coordinates of dst, the center of the pixel (x, y) being at (x+0.5, y+0.5).
+// All the pixels of dst are written.
+// The values are read with NewAtFunc and written with NewSetFunc.
+// If m maps the pixels of src exactly to the pixels of dst (e.g. rotations of multiples of 90 degrees, flips and integer translations),
+// the pixels are copied without interpolation.
+// If m is not invertible, dst is filled with the background.
+// If opts is nil, the default options are used.
+func Transform(dst draw.Image, src image.Image, m Affine, opts *TransformOptions) {
+	if opts == nil {
+		opts = new(TransformOptions)
+	}
+	var bg colorRGBA
+	if opts.Background != nil {
+		bg.r, bg.g, bg.b, bg.a = opts.Background.RGBA()
+	}
+	set := NewSetFunc(dst)
+	inv, ok := m.Invert()
+	if !ok {
+		opts.Parallel.Run(dst.Bounds(), func(r image.Rectangle) {
+			for y := r.Min.Y; y < r.Max.Y; y++ {
+				for x := r.Min.X; x < r.Max.X; x++ {
+					set(x, y, bg.r, bg.g, bg.b, bg.a)
+				}
+			}
+		})
+		return
+	}
+	var sample func(fx, fy float64) colorRGBA
+	if m.isExact() || opts.Filter == ResizeNearest {
+		sample = newTransformSampleNearest(src, bg)
+	} else {
+		k, ok := resizeKernels[opts.Filter]
+		if !ok {
+			k = resizeKernels[ResizeBilinear]
+		}
+		sample = newTransformSampleKernel(src, bg, k)
+	}
+	opts.Parallel.Run(dst.Bounds(), func(r image.Rectangle) {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := sample(inv.Apply(float64(x)+0.5, float64(y)+0.5))
+				set(x, y, c.r, c.g, c.b, c.a)
+			}
+		}
+	})
+}
+
+// newTransformSampleNearest returns a function that returns the color of the pixel containing the point (fx, fy).
+func newTransformSampleNearest(p image.Image, bg colorRGBA) func(fx, fy float64) colorRGBA {
+	at := NewAtFunc(p)
+	bd := p.Bounds()
+	return func(fx, fy float64) colorRGBA {
+		x, y := int(math.Floor(fx)), int(math.Floor(fy))
+		if !(image.Point{x, y}.In(bd)) {
+			return bg
+		}
+		var c colorRGBA
+		c.r, c.g, c.b, c.a = at(x, y)
+		return c
+	}
+}
+
+// newTransformSampleKernel returns a function that interpolates the color at the point (fx, fy) with a separable kernel.
+//
+// The pixels outside of the image have the background color, so the edges are smooth.
+func newTransformSampleKernel(p image.Image, bg colorRGBA, k resizeKernel) func(fx, fy float64) colorRGBA {
+	at := NewAtFunc(p)
+	bd := p.Bounds()
+	bgv := [4]float64{float64(bg.r), float64(bg.g), float64(bg.b), float64(bg.a)}
+	return func(fx, fy float64) colorRGBA {
+		// The pixel coordinates are at the center of the pixels.
+		fx -= 0.5
+		fy -= 0.5
+		x0, x1 := int(math.Ceil(fx-k.support)), int(math.Floor(fx+k.support))
+		y0, y1 := int(math.Ceil(fy-k.support)), int(math.Floor(fy+k.support))
+		if x1 < bd.Min.X || x0 >= bd.Max.X || y1 < bd.Min.Y || y0 >= bd.Max.Y {
+			return bg
+		}
+		var sum [4]float64
+		var wsum float64
+		for y := y0; y <= y1; y++ {
+			wy := k.at(float64(y) - fy)
+			if wy == 0 {
+				continue
+			}
+			for x := x0; x <= x1; x++ {
+				w := k.at(float64(x)-fx) * wy
+				if w == 0 {
+					continue
+				}
+				wsum += w
+				if !(image.Point{x, y}.In(bd)) {
+					for ch, v := range bgv {
+						sum[ch] += v * w
+					}
+					continue
+				}
+				r, g, b, a := at(x, y)
+				sum[0] += float64(r) * w
+				sum[1] += float64(g) * w
+				sum[2] += float64(b) * w
+				sum[3] += float64(a) * w
+			}
+		}
+		if wsum == 0 {
+			return bg
+		}
+		a := floatToUint16(sum[3] / wsum / 0xffff)
+		return colorRGBA{
+			r: minUint32(floatToUint16(sum[0]/wsum/0xffff), a),
+			g: minUint32(floatToUint16(sum[1]/wsum/0xffff), a),
+			b: minUint32(floatToUint16(sum[2]/wsum/0xffff), a),
+			a: a,
+		}
+	}
+}
+
+func minUint32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Rotate returns src rotated clockwise by an angle in degrees.
+//
+// The bounds of the returned image contain all the rotated pixels, and their minimum is (0, 0).
+// The rotations of multiples of 90 degrees are lossless: the returned image has the same type as src if possible (see Flip),
+// and the pixels are copied without interpolation.
+// Otherwise the returned image is a *image.RGBA64, or a *RGBA128F if src is a *RGBA128F,
+// and the uncovered pixels have the background color.
+// If opts is nil, the default options are used.
+func Rotate(src image.Image, angle float64, opts *TransformOptions) draw.Image {
+	m := AffineRotate(angle)
+	r := TransformBounds(src.Bounds(), m)
+	m = AffineTranslate(float64(-r.Min.X), float64(-r.Min.Y)).Mul(m)
+	return transformNew(src, m, opts)
+}
+
+// Flip returns src flipped horizontally and/or vertically.
+//
+// The bounds of the returned image are the same as src.
+// The returned image has the same type as src if it's a draw.Image of the standard library or a *RGBA128F,
+// and the pixels are copied exactly.
+// Otherwise the returned image is a *image.RGBA64.
+func Flip(src image.Image, horizontal, vertical bool) draw.Image {
+	bd := src.Bounds()
+	m := AffineIdentity
+	if horizontal {
+		m[0], m[2] = -1, float64(bd.Min.X+bd.Max.X)
+	}
+	if vertical {
+		m[4], m[5] = -1, float64(bd.Min.Y+bd.Max.Y)
+	}
+	return transformNew(src, m, nil)
+}
+
+// transformNew returns a new image containing src transformed by m.
+func transformNew(src image.Image, m Affine, opts *TransformOptions) draw.Image {
+	if opts == nil {
+		opts = new(TransformOptions)
+	}
+	r := TransformBounds(src.Bounds(), m)
+	if !m.isExact() {
+		var dst draw.Image
+		if _, ok := src.(*RGBA128F); ok {
+			dst = NewRGBA128F(r)
+		} else {
+			dst = image.NewRGBA64(r)
+		}
+		Transform(dst, src, m, opts)
+		return dst
+	}
+	dst := newImageLike(src, r)
+	if r.Empty() {
+		return dst
+	}
+	// The source pixel is an affine function of the destination pixel, with integer coefficients.
+	inv, _ := m.Invert()
+	sx0, sy0 := inv.Apply(float64(r.Min.X)+0.5, float64(r.Min.Y)+0.5)
+	ox, oy := int(math.Floor(sx0)), int(math.Floor(sy0))
+	xx, xy, yx, yy := int(inv[0]), int(inv[1]), int(inv[3]), int(inv[4])
+	copyPixel := newCopyPixelFunc(dst, src)
+	opts.Parallel.Run(r, func(rr image.Rectangle) {
+		for y := rr.Min.Y; y < rr.Max.Y; y++ {
+			for x := rr.Min.X; x < rr.Max.X; x++ {
+				dx, dy := x-r.Min.X, y-r.Min.Y
+				copyPixel(x, y, ox+dx*xx+dy*xy, oy+dx*yx+dy*yy)
+			}
+		}
+	})
+	return dst
+}
+
+// newImageLike returns a new image with the same type as p, or a *image.RGBA64 if it's not possible.
+//
+// nolint: gocyclo
+func newImageLike(p image.Image, r image.Rectangle) draw.Image {
+	switch p := p.(type) {
+	case *image.RGBA:
+		return image.NewRGBA(r)
+	case *image.RGBA64:
+		return image.NewRGBA64(r)
+	case *image.NRGBA:
+		return image.NewNRGBA(r)
+	case *image.NRGBA64:
+		return image.NewNRGBA64(r)
+	case *image.Alpha:
+		return image.NewAlpha(r)
+	case *image.Alpha16:
+		return image.NewAlpha16(r)
+	case *image.Gray:
+		return image.NewGray(r)
+	case *image.Gray16:
+		return image.NewGray16(r)
+	case *image.Paletted:
+		return image.NewPaletted(r, p.Palette)
+	case *image.CMYK:
+		return image.NewCMYK(r)
+	case *RGBA128F:
+		return NewRGBA128F(r)
+	default:
+		return image.NewRGBA64(r)
+	}
+}
+
+// pixImage returns the pixels buffer of an image storing its pixels in a []uint8 with a fixed number of bytes per pixel.
+//
+// nolint: gocyclo
+func pixImage(p image.Image) (pix []uint8, stride int, r image.Rectangle, bpp int, ok bool) {
+	switch p := p.(type) {
+	case *image.RGBA:
+		return p.Pix, p.Stride, p.Rect, 4, true
+	case *image.RGBA64:
+		return p.Pix, p.Stride, p.Rect, 8, true
+	case *image.NRGBA:
+		return p.Pix, p.Stride, p.Rect, 4, true
+	case *image.NRGBA64:
+		return p.Pix, p.Stride, p.Rect, 8, true
+	case *image.Alpha:
+		return p.Pix, p.Stride, p.Rect, 1, true
+	case *image.Alpha16:
+		return p.Pix, p.Stride, p.Rect, 2, true
+	case *image.Gray:
+		return p.Pix, p.Stride, p.Rect, 1, true
+	case *image.Gray16:
+		return p.Pix, p.Stride, p.Rect, 2, true
+	case *image.Paletted:
+		return p.Pix, p.Stride, p.Rect, 1, true
+	case *image.CMYK:
+		return p.Pix, p.Stride, p.Rect, 4, true
+	default:
+		return nil, 0, image.Rectangle{}, 0, false
+	}
+}
+
+// sameImageType returns true if a and b have the same concrete type.
+//
+// It only handles the types supported by pixImage.
+//
+// nolint: gocyclo
+func sameImageType(a, b image.Image) bool {
+	var ok bool
+	switch a.(type) {
+	case *image.RGBA:
+		_, ok = b.(*image.RGBA)
+	case *image.RGBA64:
+		_, ok = b.(*image.RGBA64)
+	case *image.NRGBA:
+		_, ok = b.(*image.NRGBA)
+	case *image.NRGBA64:
+		_, ok = b.(*image.NRGBA64)
+	case *image.Alpha:
+		_, ok = b.(*image.Alpha)
+	case *image.Alpha16:
+		_, ok = b.(*image.Alpha16)
+	case *image.Gray:
+		_, ok = b.(*image.Gray)
+	case *image.Gray16:
+		_, ok = b.(*image.Gray16)
+	case *image.Paletted:
+		_, ok = b.(*image.Paletted)
+	case *image.CMYK:
+		_, ok = b.(*image.CMYK)
+	}
+	return ok
+}
+
+// newCopyPixelFunc returns a function that copies the pixel (sx, sy) of src to the pixel (dx, dy) of dst.
+//
+// If dst has been created by newImageLike, the pixels are copied exactly.
+func newCopyPixelFunc(dst draw.Image, src image.Image) func(dx, dy, sx, sy int) {
+	if d, ok := dst.(*RGBA128F); ok {
+		if s, ok := src.(*RGBA128F); ok {
+			return func(dx, dy, sx, sy int) {
+				di, si := d.PixOffset(dx, dy), s.PixOffset(sx, sy)
+				copy(d.Pix[di:di+4], s.Pix[si:si+4])
+			}
+		}
+	}
+	dPix, dStride, dRect, dBpp, dOK := pixImage(dst)
+	sPix, sStride, sRect, _, sOK := pixImage(src)
+	if dOK && sOK && sameImageType(dst, src) {
+		bpp := dBpp
+		return func(dx, dy, sx, sy int) {
+			di := (dy-dRect.Min.Y)*dStride + (dx-dRect.Min.X)*bpp
+			si := (sy-sRect.Min.Y)*sStride + (sx-sRect.Min.X)*bpp
+			copy(dPix[di:di+bpp], sPix[si:si+bpp])
+		}
+	}
+	at := NewAtFunc(src)
+	set := NewSetFunc(dst)
+	return func(dx, dy, sx, sy int) {
+		r, g, b, a := at(sx, sy)
+		set(dx, dy, r, g, b, a)
+	}
+}
@@ -0,0 +1,199 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestAffine(t *testing.T) {
+	m := AffineTranslate(3, -2).Mul(AffineRotate(30)).Mul(AffineScale(2, 0.5)).Mul(AffineSkew(0.2, 0.1))
+	inv, ok := m.Invert()
+	if !ok {
+		t.Fatal("not invertible")
+	}
+	x, y := inv.Apply(m.Apply(1.5, -4))
+	if math.Abs(x-1.5) > 1e-9 || math.Abs(y+4) > 1e-9 {
+		t.Fatalf("unexpected point: got (%f, %f), want (1.5, -4)", x, y)
+	}
+	if _, ok := AffineScale(0, 1).Invert(); ok {
+		t.Fatal("invertible")
+	}
+	for _, tc := range []struct {
+		angle float64
+		want  Affine
+	}{
+		{0, Affine{1, 0, 0, 0, 1, 0}},
+		{90, Affine{0, -1, 0, 1, 0, 0}},
+		{-180, Affine{-1, 0, 0, 0, -1, 0}},
+		{630, Affine{0, 1, 0, -1, 0, 0}},
+	} {
+		if got := AffineRotate(tc.angle); got != tc.want {
+			t.Fatalf("unexpected rotation: angle %f: got %v, want %v", tc.angle, got, tc.want)
+		}
+	}
+}
+
+func TestTransformBounds(t *testing.T) {
+	r := image.Rect(-2, 3, 5, 7)
+	for _, tc := range []struct {
+		m    Affine
+		want image.Rectangle
+	}{
+		{AffineIdentity, r},
+		{AffineRotate(90), image.Rect(-7, -2, -3, 5)},
+		{AffineRotate(45), image.Rect(-7, 0, 2, 9)},
+		{AffineTranslate(0.5, 0), image.Rect(-2, 3, 6, 7)},
+	} {
+		if got := TransformBounds(r, tc.m); got != tc.want {
+			t.Fatalf("unexpected bounds: %v: got %v, want %v", tc.m, got, tc.want)
+		}
+	}
+}
+
+func TestRotateLossless(t *testing.T) {
+	bd := image.Rect(-2, 3, 5, 7)
+	for _, newImageFunc := range testImageFuncs {
+		src := newImageFunc(bd)
+		if _, ok := src.(*image.Uniform); ok {
+			continue
+		}
+		t.Run(fmt.Sprintf("%T", src), func(t *testing.T) {
+			set := newSimpleSetFunc(src)
+			for y := bd.Min.Y; y < bd.Max.Y; y++ {
+				for x := bd.Min.X; x < bd.Max.X; x++ {
+					set(x, y, testRandomColor())
+				}
+			}
+			w, h := bd.Dx(), bd.Dy()
+			for _, tc := range []struct {
+				angle  float64
+				bounds image.Rectangle
+				// srcPoint returns the source pixel of a destination pixel.
+				srcPoint func(x, y int) image.Point
+			}{
+				{0, image.Rect(0, 0, w, h), func(x, y int) image.Point {
+					return image.Pt(bd.Min.X+x, bd.Min.Y+y)
+				}},
+				{90, image.Rect(0, 0, h, w), func(x, y int) image.Point {
+					return image.Pt(bd.Min.X+y, bd.Max.Y-1-x)
+				}},
+				{180, image.Rect(0, 0, w, h), func(x, y int) image.Point {
+					return image.Pt(bd.Max.X-1-x, bd.Max.Y-1-y)
+				}},
+				{270, image.Rect(0, 0, h, w), func(x, y int) image.Point {
+					return image.Pt(bd.Max.X-1-y, bd.Min.Y+x)
+				}},
+			} {
+				dst := Rotate(src, tc.angle, nil)
+				if dst.Bounds() != tc.bounds {
+					t.Fatalf("unexpected bounds: angle %f: got %v, want %v", tc.angle, dst.Bounds(), tc.bounds)
+				}
+				testTransformExact(t, dst, src, tc.srcPoint)
+			}
+			dst := Flip(src, true, false)
+			testTransformExact(t, dst, src, func(x, y int) image.Point {
+				return image.Pt(bd.Min.X+bd.Max.X-1-x, y)
+			})
+			dst = Flip(src, false, true)
+			testTransformExact(t, dst, src, func(x, y int) image.Point {
+				return image.Pt(x, bd.Min.Y+bd.Max.Y-1-y)
+			})
+		})
+	}
+}
+
+// testTransformExact checks that the pixels of dst are exact copies of the pixels of src.
+func testTransformExact(t *testing.T, dst image.Image, src image.Image, srcPoint func(x, y int) image.Point) {
+	t.Helper()
+	sameType := fmt.Sprintf("%T", dst) == fmt.Sprintf("%T", src)
+	bd := dst.Bounds()
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			p := srcPoint(x, y)
+			c1, c2 := dst.At(x, y), src.At(p.X, p.Y)
+			if sameType && c1 != c2 {
+				t.Fatalf("different color: pixel %dx%d: got %v, want %v", x, y, c1, c2)
+			}
+			r1, g1, b1, a1 := c1.RGBA()
+			r2, g2, b2, a2 := c2.RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				t.Fatalf("different color: pixel %dx%d: got {%d %d %d %d}, want {%d %d %d %d}", x, y, r1, g1, b1, a1, r2, g2, b2, a2)
+			}
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	src := image.NewUniform(color.RGBA{0x20, 0x40, 0x60, 0xff})
+	p := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	Convert(p, src)
+	for _, filter := range testResizeFilters {
+		t.Run(fmt.Sprint(filter), func(t *testing.T) {
+			dst := Rotate(p, 30, &TransformOptions{
+				Filter:     filter,
+				Background: color.Black,
+			})
+			if want := image.Rect(0, 0, 23, 19); dst.Bounds() != want {
+				t.Fatalf("unexpected bounds: got %v, want %v", dst.Bounds(), want)
+			}
+			// The center is inside the source image, the corners are outside.
+			r, g, b, a := dst.At(11, 9).RGBA()
+			if r != 0x2020 || g != 0x4040 || b != 0x6060 || a != 0xffff {
+				t.Fatalf("unexpected center color: got {%d %d %d %d}", r, g, b, a)
+			}
+			r, g, b, a = dst.At(0, 0).RGBA()
+			if r != 0 || g != 0 || b != 0 || a != 0xffff {
+				t.Fatalf("unexpected corner color: got {%d %d %d %d}", r, g, b, a)
+			}
+		})
+	}
+}
+
+func TestTransformTranslate(t *testing.T) {
+	src := testNewRandomImage(image.Rect(0, 0, 6, 5))
+	dst := image.NewNRGBA(image.Rect(0, 0, 6, 5))
+	Transform(dst, src, AffineTranslate(2, -1), &TransformOptions{Filter: ResizeLanczos3})
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 6; x++ {
+			want := color.NRGBA{}
+			if p := image.Pt(x-2, y+1); p.In(src.Rect) {
+				want = src.NRGBAAt(p.X, p.Y)
+				if want.A == 0 {
+					want = color.NRGBA{}
+				}
+			}
+			got := dst.NRGBAAt(x, y)
+			r1, g1, b1, a1 := got.RGBA()
+			r2, g2, b2, a2 := want.RGBA()
+			const delta = 0x101
+			if testAbsDiff(r1, r2) > delta || testAbsDiff(g1, g2) > delta || testAbsDiff(b1, b2) > delta || a1 != a2 {
+				t.Fatalf("different color: pixel %dx%d: got %v, want %v", x, y, got, want)
+			}
+		}
+	}
+}
+
+func TestTransformNotInvertible(t *testing.T) {
+	src := testNewRandomImage(image.Rect(0, 0, 3, 3))
+	dst := image.NewRGBA(src.Rect)
+	Transform(dst, src, AffineScale(0, 1), &TransformOptions{Background: color.White})
+	for i, v := range dst.Pix {
+		if v != 0xff {
+			t.Fatalf("unexpected value at %d: got %d, want 255", i, v)
+		}
+	}
+}
+
+func BenchmarkRotate(b *testing.B) {
+	src := testNewRandomImage(image.Rect(0, 0, 512, 512))
+	for _, angle := range []float64{90, 30} {
+		b.Run(fmt.Sprint(angle), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				Rotate(src, angle, &TransformOptions{Filter: ResizeBilinear})
+			}
+		})
+	}
+}
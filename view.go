@@ -0,0 +1,334 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// FlipH is a view of an image flipped horizontally.
+//
+// It has the same bounds as the image.
+type FlipH struct {
+	Image image.Image
+}
+
+// ColorModel implements image.Image.
+func (p *FlipH) ColorModel() color.Model {
+	return p.Image.ColorModel()
+}
+
+// Bounds implements image.Image.
+func (p *FlipH) Bounds() image.Rectangle {
+	return p.Image.Bounds()
+}
+
+// At implements image.Image.
+func (p *FlipH) At(x, y int) color.Color {
+	bd := p.Image.Bounds()
+	return p.Image.At(bd.Min.X+bd.Max.X-1-x, y)
+}
+
+// Opaque reports whether the image is fully opaque.
+func (p *FlipH) Opaque() bool {
+	return isOpaque(p.Image)
+}
+
+// FlipV is a view of an image flipped vertically.
+//
+// It has the same bounds as the image.
+type FlipV struct {
+	Image image.Image
+}
+
+// ColorModel implements image.Image.
+func (p *FlipV) ColorModel() color.Model {
+	return p.Image.ColorModel()
+}
+
+// Bounds implements image.Image.
+func (p *FlipV) Bounds() image.Rectangle {
+	return p.Image.Bounds()
+}
+
+// At implements image.Image.
+func (p *FlipV) At(x, y int) color.Color {
+	bd := p.Image.Bounds()
+	return p.Image.At(x, bd.Min.Y+bd.Max.Y-1-y)
+}
+
+// Opaque reports whether the image is fully opaque.
+func (p *FlipV) Opaque() bool {
+	return isOpaque(p.Image)
+}
+
+// Transpose is a view of an image transposed (flipped over its top left to bottom right diagonal).
+//
+// The pixel (x, y) is the pixel (y, x) of the image, and the bounds are transposed.
+type Transpose struct {
+	Image image.Image
+}
+
+// ColorModel implements image.Image.
+func (p *Transpose) ColorModel() color.Model {
+	return p.Image.ColorModel()
+}
+
+// Bounds implements image.Image.
+func (p *Transpose) Bounds() image.Rectangle {
+	return transposeRect(p.Image.Bounds())
+}
+
+// At implements image.Image.
+func (p *Transpose) At(x, y int) color.Color {
+	return p.Image.At(y, x)
+}
+
+// Opaque reports whether the image is fully opaque.
+func (p *Transpose) Opaque() bool {
+	return isOpaque(p.Image)
+}
+
+// Rotate90 is a view of an image rotated by 90 degrees clockwise.
+//
+// The bounds are transposed, like Transpose.
+type Rotate90 struct {
+	Image image.Image
+}
+
+// ColorModel implements image.Image.
+func (p *Rotate90) ColorModel() color.Model {
+	return p.Image.ColorModel()
+}
+
+// Bounds implements image.Image.
+func (p *Rotate90) Bounds() image.Rectangle {
+	return transposeRect(p.Image.Bounds())
+}
+
+// At implements image.Image.
+func (p *Rotate90) At(x, y int) color.Color {
+	bd := p.Image.Bounds()
+	return p.Image.At(y, bd.Min.Y+bd.Max.Y-1-x)
+}
+
+// Opaque reports whether the image is fully opaque.
+func (p *Rotate90) Opaque() bool {
+	return isOpaque(p.Image)
+}
+
+// Rotate180 is a view of an image rotated by 180 degrees.
+//
+// It has the same bounds as the image.
+type Rotate180 struct {
+	Image image.Image
+}
+
+// ColorModel implements image.Image.
+func (p *Rotate180) ColorModel() color.Model {
+	return p.Image.ColorModel()
+}
+
+// Bounds implements image.Image.
+func (p *Rotate180) Bounds() image.Rectangle {
+	return p.Image.Bounds()
+}
+
+// At implements image.Image.
+func (p *Rotate180) At(x, y int) color.Color {
+	bd := p.Image.Bounds()
+	return p.Image.At(bd.Min.X+bd.Max.X-1-x, bd.Min.Y+bd.Max.Y-1-y)
+}
+
+// Opaque reports whether the image is fully opaque.
+func (p *Rotate180) Opaque() bool {
+	return isOpaque(p.Image)
+}
+
+// Rotate270 is a view of an image rotated by 270 degrees clockwise (90 degrees counterclockwise).
+//
+// The bounds are transposed, like Transpose.
+type Rotate270 struct {
+	Image image.Image
+}
+
+// ColorModel implements image.Image.
+func (p *Rotate270) ColorModel() color.Model {
+	return p.Image.ColorModel()
+}
+
+// Bounds implements image.Image.
+func (p *Rotate270) Bounds() image.Rectangle {
+	return transposeRect(p.Image.Bounds())
+}
+
+// At implements image.Image.
+func (p *Rotate270) At(x, y int) color.Color {
+	bd := p.Image.Bounds()
+	return p.Image.At(bd.Min.X+bd.Max.X-1-y, x)
+}
+
+// Opaque reports whether the image is fully opaque.
+func (p *Rotate270) Opaque() bool {
+	return isOpaque(p.Image)
+}
+
+// Crop is a view of a part of an image.
+//
+// Its bounds are the intersection of Rect and the bounds of the image.
+// It is useful for the images that don't have a SubImage method.
+type Crop struct {
+	Image image.Image
+	Rect  image.Rectangle
+}
+
+// ColorModel implements image.Image.
+func (p *Crop) ColorModel() color.Model {
+	return p.Image.ColorModel()
+}
+
+// Bounds implements image.Image.
+func (p *Crop) Bounds() image.Rectangle {
+	return p.Rect.Intersect(p.Image.Bounds())
+}
+
+// At implements image.Image.
+func (p *Crop) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(p.Rect)) {
+		return color.Transparent
+	}
+	return p.Image.At(x, y)
+}
+
+// Swizzle is a view of an image with reordered channels.
+//
+// The channel i of a pixel is the channel Channels[i] of the pixel of the image,
+// the channels being 0 (red), 1 (green), 2 (blue) and 3 (alpha).
+// The alpha-premultiplied values are reordered, and the color values are clipped to the alpha value.
+// Reading a pixel panics if a channel is not in [0, 3].
+type Swizzle struct {
+	Image    image.Image
+	Channels [4]int
+}
+
+// ColorModel implements image.Image.
+func (p *Swizzle) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+// Bounds implements image.Image.
+func (p *Swizzle) Bounds() image.Rectangle {
+	return p.Image.Bounds()
+}
+
+// At implements image.Image.
+func (p *Swizzle) At(x, y int) color.Color {
+	checkSwizzleChannels(p.Channels)
+	r, g, b, a := p.Image.At(x, y).RGBA()
+	r, g, b, a = swizzleRGBA(p.Channels, r, g, b, a)
+	return color.RGBA64{uint16(r), uint16(g), uint16(b), uint16(a)}
+}
+
+// swizzleRGBA reorders the channels of a color.
+//
+// The channels must be valid (see checkSwizzleChannels).
+func swizzleRGBA(chs [4]int, r, g, b, a uint32) (uint32, uint32, uint32, uint32) {
+	vs := [4]uint32{r, g, b, a}
+	a = vs[chs[3]]
+	return minUint32(vs[chs[0]], a), minUint32(vs[chs[1]], a), minUint32(vs[chs[2]], a), a
+}
+
+// checkSwizzleChannels panics if a channel is not in [0, 3].
+func checkSwizzleChannels(chs [4]int) {
+	for _, ch := range chs {
+		if ch < 0 || ch > 3 {
+			panic(fmt.Sprintf("imageutil: invalid swizzle channel %d, want 0 to 3", ch))
+		}
+	}
+}
+
+func transposeRect(r image.Rectangle) image.Rectangle {
+	return image.Rect(r.Min.Y, r.Min.X, r.Max.Y, r.Max.X)
+}
+
+// isOpaque reports whether an image is fully opaque, if it has an Opaque method.
+func isOpaque(p image.Image) bool {
+	o, ok := p.(interface {
+		Opaque() bool
+	})
+	return ok && o.Opaque()
+}
+
+func newAtFuncFlipH(p *FlipH) AtFunc {
+	at := NewAtFunc(p.Image)
+	bd := p.Image.Bounds()
+	ox := bd.Min.X + bd.Max.X - 1
+	return func(x, y int) (r, g, b, a uint32) {
+		return at(ox-x, y)
+	}
+}
+
+func newAtFuncFlipV(p *FlipV) AtFunc {
+	at := NewAtFunc(p.Image)
+	bd := p.Image.Bounds()
+	oy := bd.Min.Y + bd.Max.Y - 1
+	return func(x, y int) (r, g, b, a uint32) {
+		return at(x, oy-y)
+	}
+}
+
+func newAtFuncTranspose(p *Transpose) AtFunc {
+	at := NewAtFunc(p.Image)
+	return func(x, y int) (r, g, b, a uint32) {
+		return at(y, x)
+	}
+}
+
+func newAtFuncRotate90(p *Rotate90) AtFunc {
+	at := NewAtFunc(p.Image)
+	bd := p.Image.Bounds()
+	oy := bd.Min.Y + bd.Max.Y - 1
+	return func(x, y int) (r, g, b, a uint32) {
+		return at(y, oy-x)
+	}
+}
+
+func newAtFuncRotate180(p *Rotate180) AtFunc {
+	at := NewAtFunc(p.Image)
+	bd := p.Image.Bounds()
+	ox, oy := bd.Min.X+bd.Max.X-1, bd.Min.Y+bd.Max.Y-1
+	return func(x, y int) (r, g, b, a uint32) {
+		return at(ox-x, oy-y)
+	}
+}
+
+func newAtFuncRotate270(p *Rotate270) AtFunc {
+	at := NewAtFunc(p.Image)
+	bd := p.Image.Bounds()
+	ox := bd.Min.X + bd.Max.X - 1
+	return func(x, y int) (r, g, b, a uint32) {
+		return at(ox-y, x)
+	}
+}
+
+func newAtFuncCrop(p *Crop) AtFunc {
+	at := NewAtFunc(p.Image)
+	rect := p.Rect
+	return func(x, y int) (r, g, b, a uint32) {
+		// Like At, the pixels outside of Rect are transparent.
+		if !(image.Point{x, y}.In(rect)) {
+			return 0, 0, 0, 0
+		}
+		return at(x, y)
+	}
+}
+
+func newAtFuncSwizzle(p *Swizzle) AtFunc {
+	chs := p.Channels
+	checkSwizzleChannels(chs)
+	at := NewAtFunc(p.Image)
+	return func(x, y int) (r, g, b, a uint32) {
+		r, g, b, a = at(x, y)
+		return swizzleRGBA(chs, r, g, b, a)
+	}
+}
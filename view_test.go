@@ -0,0 +1,161 @@
+package imageutil
+
+import (
+	"fmt"
+	"image"
+	"testing"
+)
+
+func TestViews(t *testing.T) {
+	bd := image.Rect(-2, 3, 5, 7)
+	src := testNewRandomImage(bd)
+	for _, tc := range []struct {
+		view   image.Image
+		bounds image.Rectangle
+		// srcPoint returns the source pixel of a view pixel.
+		srcPoint func(x, y int) image.Point
+	}{
+		{&FlipH{src}, bd, func(x, y int) image.Point {
+			return image.Pt(bd.Min.X+bd.Max.X-1-x, y)
+		}},
+		{&FlipV{src}, bd, func(x, y int) image.Point {
+			return image.Pt(x, bd.Min.Y+bd.Max.Y-1-y)
+		}},
+		{&Transpose{src}, image.Rect(3, -2, 7, 5), func(x, y int) image.Point {
+			return image.Pt(y, x)
+		}},
+		{&Rotate90{src}, image.Rect(3, -2, 7, 5), func(x, y int) image.Point {
+			return image.Pt(y, bd.Min.Y+bd.Max.Y-1-x)
+		}},
+		{&Rotate180{src}, bd, func(x, y int) image.Point {
+			return image.Pt(bd.Min.X+bd.Max.X-1-x, bd.Min.Y+bd.Max.Y-1-y)
+		}},
+		{&Rotate270{src}, image.Rect(3, -2, 7, 5), func(x, y int) image.Point {
+			return image.Pt(bd.Min.X+bd.Max.X-1-y, x)
+		}},
+		{&Crop{src, image.Rect(0, 0, 3, 5)}, image.Rect(0, 3, 3, 5), func(x, y int) image.Point {
+			return image.Pt(x, y)
+		}},
+	} {
+		t.Run(fmt.Sprintf("%T", tc.view), func(t *testing.T) {
+			if tc.view.Bounds() != tc.bounds {
+				t.Fatalf("unexpected bounds: got %v, want %v", tc.view.Bounds(), tc.bounds)
+			}
+			testTransformExact(t, tc.view, src, tc.srcPoint)
+			testViewAtFunc(t, tc.view)
+		})
+	}
+}
+
+func TestViewsRotate(t *testing.T) {
+	src := testNewRandomImage(image.Rect(-2, 3, 5, 7))
+	for _, tc := range []struct {
+		view  image.Image
+		angle float64
+	}{
+		{&Rotate90{src}, 90},
+		{&Rotate180{src}, 180},
+		{&Rotate270{src}, 270},
+	} {
+		t.Run(fmt.Sprintf("%T", tc.view), func(t *testing.T) {
+			p := Rotate(src, tc.angle, nil)
+			min := tc.view.Bounds().Min
+			testTransformExact(t, p, tc.view, func(x, y int) image.Point {
+				return image.Pt(x, y).Add(min)
+			})
+		})
+	}
+}
+
+func TestSwizzle(t *testing.T) {
+	src := testNewRandomImage(image.Rect(0, 0, 5, 5))
+	for _, chs := range [][4]int{
+		{0, 1, 2, 3},
+		{2, 1, 0, 3},
+		{3, 3, 3, 3},
+		{0, 0, 0, 3},
+	} {
+		t.Run(fmt.Sprint(chs), func(t *testing.T) {
+			p := &Swizzle{src, chs}
+			for y := 0; y < 5; y++ {
+				for x := 0; x < 5; x++ {
+					r1, g1, b1, a1 := src.At(x, y).RGBA()
+					vs := [4]uint32{r1, g1, b1, a1}
+					r2, g2, b2, a2 := p.At(x, y).RGBA()
+					if r2 != vs[chs[0]] || g2 != vs[chs[1]] || b2 != vs[chs[2]] || a2 != vs[chs[3]] {
+						t.Fatalf("unexpected color: pixel %dx%d: got {%d %d %d %d}, source {%d %d %d %d}", x, y, r2, g2, b2, a2, r1, g1, b1, a1)
+					}
+				}
+			}
+			testViewAtFunc(t, p)
+		})
+	}
+}
+
+func TestSwizzleInvalidChannel(t *testing.T) {
+	src := testNewRandomImage(image.Rect(0, 0, 5, 5))
+	for _, chs := range [][4]int{
+		{4, 1, 2, 3},
+		{0, 1, 2, -1},
+	} {
+		for _, f := range []func(){
+			func() { (&Swizzle{src, chs}).At(0, 0) },
+			func() { NewAtFunc(&Swizzle{src, chs}) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatalf("no panic for %v", chs)
+					}
+				}()
+				f()
+			}()
+		}
+	}
+}
+
+func TestCropAtFuncOutside(t *testing.T) {
+	src := testNewOpaqueRandomImage(image.Rect(0, 0, 5, 5))
+	at := NewAtFunc(&Crop{src, image.Rect(1, 1, 3, 3)})
+	// The pixels of the image outside of Rect are transparent, like At.
+	for _, pt := range []image.Point{{0, 0}, {3, 1}, {1, 4}} {
+		if r, g, b, a := at(pt.X, pt.Y); r != 0 || g != 0 || b != 0 || a != 0 {
+			t.Fatalf("unexpected color at %v: got {%d %d %d %d}, want transparent", pt, r, g, b, a)
+		}
+	}
+	if _, _, _, a := at(1, 1); a != 0xffff {
+		t.Fatalf("unexpected alpha: got %d, want %d", a, 0xffff)
+	}
+}
+
+func TestViewsComposed(t *testing.T) {
+	src := testNewRandomImage(image.Rect(-2, 3, 5, 7))
+	p := &FlipH{&Rotate90{&Crop{&Swizzle{src, [4]int{2, 1, 0, 3}}, image.Rect(0, 0, 4, 6)}}}
+	testViewAtFunc(t, p)
+}
+
+func TestViewsOpaque(t *testing.T) {
+	p := image.NewGray(image.Rect(0, 0, 2, 2))
+	if !(&Rotate90{p}).Opaque() {
+		t.Fatal("not opaque")
+	}
+	if (&FlipH{image.NewNRGBA(p.Rect)}).Opaque() {
+		t.Fatal("opaque")
+	}
+}
+
+// testViewAtFunc checks that the AtFunc of a view returns the same values as its At method.
+func testViewAtFunc(t *testing.T, p image.Image) {
+	t.Helper()
+	at := NewAtFunc(p)
+	bd := p.Bounds()
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			r1, g1, b1, a1 := at(x, y)
+			r2, g2, b2, a2 := p.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				t.Fatalf("different color: pixel %dx%d: got {%d %d %d %d}, want {%d %d %d %d}", x, y, r1, g1, b1, a1, r2, g2, b2, a2)
+			}
+		}
+	}
+}
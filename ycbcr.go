@@ -0,0 +1,128 @@
+package imageutil
+
+import (
+	"image"
+	"image/color"
+)
+
+// NewSetFuncYCbCr returns a SetFunc for a YCbCr image.
+//
+// The chroma sample shared by subsampled pixels is overwritten by each call ("last write wins").
+func NewSetFuncYCbCr(p *image.YCbCr) SetFunc {
+	return func(x, y int, r, g, b, a uint32) {
+		yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		p.Y[p.YOffset(x, y)] = yy
+		ci := p.COffset(x, y)
+		p.Cb[ci] = cb
+		p.Cr[ci] = cr
+	}
+}
+
+// NewSetFuncNYCbCrA returns a SetFunc for a NYCbCrA image.
+//
+// The chroma sample shared by subsampled pixels is overwritten by each call ("last write wins").
+func NewSetFuncNYCbCrA(p *image.NYCbCrA) SetFunc {
+	return func(x, y int, r, g, b, a uint32) {
+		r, g, b, a = RGBAToNRGBA(r, g, b, a)
+		yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+		p.Y[p.YOffset(x, y)] = yy
+		p.A[p.AOffset(x, y)] = uint8(a >> 8)
+		ci := p.COffset(x, y)
+		p.Cb[ci] = cb
+		p.Cr[ci] = cr
+	}
+}
+
+// BlockSetFunc sets the pixels of a Rectangle with the RGBA values returned by an AtFunc.
+//
+// The pixels sharing a chroma sample (a chroma block) are processed at once:
+// the luma is set for each pixel, and the chroma sample is the average of the chroma of the pixels.
+// A chroma block that is only partially included in the Rectangle is averaged on the included pixels,
+// so the Rectangle should be aligned on chroma blocks.
+type BlockSetFunc func(r image.Rectangle, at AtFunc)
+
+// NewBlockSetFuncYCbCr returns a BlockSetFunc for a YCbCr image.
+func NewBlockSetFuncYCbCr(p *image.YCbCr) BlockSetFunc {
+	sx, sy := yCbCrSubsampleFactors(p.SubsampleRatio)
+	return func(r image.Rectangle, at AtFunc) {
+		forEachChromaBlock(r.Intersect(p.Rect), sx, sy, func(br image.Rectangle) {
+			var sumCb, sumCr, n uint32
+			for y := br.Min.Y; y < br.Max.Y; y++ {
+				yi := p.YOffset(br.Min.X, y)
+				for x := br.Min.X; x < br.Max.X; x++ {
+					r, g, b, _ := at(x, y)
+					yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+					p.Y[yi] = yy
+					yi++
+					sumCb += uint32(cb)
+					sumCr += uint32(cr)
+					n++
+				}
+			}
+			ci := p.COffset(br.Min.X, br.Min.Y)
+			p.Cb[ci] = uint8((sumCb + n/2) / n)
+			p.Cr[ci] = uint8((sumCr + n/2) / n)
+		})
+	}
+}
+
+// NewBlockSetFuncNYCbCrA returns a BlockSetFunc for a NYCbCrA image.
+//
+// The chroma average is weighted by the alpha of the pixels.
+// If all the pixels of a block are transparent, the average is not weighted.
+func NewBlockSetFuncNYCbCrA(p *image.NYCbCrA) BlockSetFunc {
+	sx, sy := yCbCrSubsampleFactors(p.SubsampleRatio)
+	return func(r image.Rectangle, at AtFunc) {
+		forEachChromaBlock(r.Intersect(p.Rect), sx, sy, func(br image.Rectangle) {
+			var sumCb, sumCr, n, sumCbA, sumCrA, sumA uint32
+			for y := br.Min.Y; y < br.Max.Y; y++ {
+				yi := p.YOffset(br.Min.X, y)
+				ai := p.AOffset(br.Min.X, y)
+				for x := br.Min.X; x < br.Max.X; x++ {
+					r, g, b, a := RGBAToNRGBA(at(x, y))
+					yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+					a8 := uint32(a >> 8)
+					p.Y[yi] = yy
+					p.A[ai] = uint8(a8)
+					yi++
+					ai++
+					sumCb += uint32(cb)
+					sumCr += uint32(cr)
+					n++
+					sumCbA += uint32(cb) * a8
+					sumCrA += uint32(cr) * a8
+					sumA += a8
+				}
+			}
+			ci := p.COffset(br.Min.X, br.Min.Y)
+			if sumA == 0 {
+				p.Cb[ci] = uint8((sumCb + n/2) / n)
+				p.Cr[ci] = uint8((sumCr + n/2) / n)
+				return
+			}
+			p.Cb[ci] = uint8((sumCbA + sumA/2) / sumA)
+			p.Cr[ci] = uint8((sumCrA + sumA/2) / sumA)
+		})
+	}
+}
+
+// forEachChromaBlock calls f for each chroma block of a Rectangle.
+//
+// The blocks are computed with the same truncated division as image.YCbCr.COffset.
+func forEachChromaBlock(r image.Rectangle, sx, sy int, f func(image.Rectangle)) {
+	for y0 := r.Min.Y; y0 < r.Max.Y; {
+		y1 := y0 + 1
+		for y1 < r.Max.Y && y1/sy == y0/sy {
+			y1++
+		}
+		for x0 := r.Min.X; x0 < r.Max.X; {
+			x1 := x0 + 1
+			for x1 < r.Max.X && x1/sx == x0/sx {
+				x1++
+			}
+			f(image.Rect(x0, y0, x1, y1))
+			x0 = x1
+		}
+		y0 = y1
+	}
+}
@@ -0,0 +1,126 @@
+package imageutil
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+)
+
+var testSubsampleRatios = []image.YCbCrSubsampleRatio{
+	image.YCbCrSubsampleRatio444,
+	image.YCbCrSubsampleRatio422,
+	image.YCbCrSubsampleRatio420,
+	image.YCbCrSubsampleRatio440,
+	image.YCbCrSubsampleRatio411,
+	image.YCbCrSubsampleRatio410,
+}
+
+func TestNewSetFuncYCbCr(t *testing.T) {
+	bd := image.Rect(-3, -1, 6, 4)
+	for _, sr := range testSubsampleRatios {
+		t.Run(sr.String(), func(t *testing.T) {
+			p1 := image.NewYCbCr(bd, sr)
+			p2 := image.NewYCbCr(bd, sr)
+			set1 := NewSetFuncYCbCr(p1)
+			set2 := newSimpleSetFunc(p2)
+			testSetFuncYCbCr(t, bd, set1, set2)
+			if !bytes.Equal(p1.Y, p2.Y) || !bytes.Equal(p1.Cb, p2.Cb) || !bytes.Equal(p1.Cr, p2.Cr) {
+				t.Fatal("different images")
+			}
+		})
+	}
+}
+
+func TestNewSetFuncNYCbCrA(t *testing.T) {
+	bd := image.Rect(-3, -1, 6, 4)
+	for _, sr := range testSubsampleRatios {
+		t.Run(sr.String(), func(t *testing.T) {
+			p1 := image.NewNYCbCrA(bd, sr)
+			p2 := image.NewNYCbCrA(bd, sr)
+			set1 := NewSetFuncNYCbCrA(p1)
+			set2 := newSimpleSetFunc(p2)
+			testSetFuncYCbCr(t, bd, set1, set2)
+			if !bytes.Equal(p1.Y, p2.Y) || !bytes.Equal(p1.Cb, p2.Cb) || !bytes.Equal(p1.Cr, p2.Cr) || !bytes.Equal(p1.A, p2.A) {
+				t.Fatal("different images")
+			}
+		})
+	}
+}
+
+func testSetFuncYCbCr(t *testing.T, bd image.Rectangle, set SetFunc, simpleSet func(x, y int, c color.Color)) {
+	t.Helper()
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			c := testColors[rand.Intn(len(testColors))]
+			r, g, b, a := c.RGBA()
+			set(x, y, r, g, b, a)
+			simpleSet(x, y, c)
+		}
+	}
+}
+
+func TestNewBlockSetFuncYCbCr(t *testing.T) {
+	bd := image.Rect(-3, -1, 6, 4)
+	src := image.NewRGBA(bd)
+	for y := bd.Min.Y; y < bd.Max.Y; y++ {
+		for x := bd.Min.X; x < bd.Max.X; x++ {
+			src.Set(x, y, testColors[rand.Intn(len(testColors))])
+		}
+	}
+	for _, sr := range testSubsampleRatios {
+		t.Run(sr.String(), func(t *testing.T) {
+			p := image.NewYCbCr(bd, sr)
+			NewBlockSetFuncYCbCr(p)(bd, NewAtFunc(src))
+			sums := make(map[int][3]int)
+			for y := bd.Min.Y; y < bd.Max.Y; y++ {
+				for x := bd.Min.X; x < bd.Max.X; x++ {
+					c := color.YCbCrModel.Convert(src.At(x, y)).(color.YCbCr)
+					if p.Y[p.YOffset(x, y)] != c.Y {
+						t.Fatalf("different luma: pixel %dx%d: got %d, want %d", x, y, p.Y[p.YOffset(x, y)], c.Y)
+					}
+					ci := p.COffset(x, y)
+					s := sums[ci]
+					s[0] += int(c.Cb)
+					s[1] += int(c.Cr)
+					s[2]++
+					sums[ci] = s
+				}
+			}
+			for ci, s := range sums {
+				cb := uint8((s[0] + s[2]/2) / s[2])
+				cr := uint8((s[1] + s[2]/2) / s[2])
+				if p.Cb[ci] != cb || p.Cr[ci] != cr {
+					t.Fatalf("different chroma: offset %d: got {%d %d}, want {%d %d}", ci, p.Cb[ci], p.Cr[ci], cb, cr)
+				}
+			}
+		})
+	}
+}
+
+func TestNewBlockSetFuncNYCbCrA(t *testing.T) {
+	bd := image.Rect(-3, -1, 6, 4)
+	for _, sr := range testSubsampleRatios {
+		for _, c := range []color.Color{
+			color.NRGBA{0x40, 0x80, 0xc0, 0xff},
+			color.NRGBA{0x40, 0x80, 0xc0, 0x80},
+			color.NRGBA{0x00, 0x00, 0x00, 0x00},
+		} {
+			t.Run(fmt.Sprintf("%s/%v", sr, c), func(t *testing.T) {
+				p := image.NewNYCbCrA(bd, sr)
+				NewBlockSetFuncNYCbCrA(p)(bd, NewAtFunc(image.NewUniform(c)))
+				want := color.NYCbCrAModel.Convert(c).(color.NYCbCrA)
+				for y := bd.Min.Y; y < bd.Max.Y; y++ {
+					for x := bd.Min.X; x < bd.Max.X; x++ {
+						got := p.NYCbCrAAt(x, y)
+						if got != want {
+							t.Fatalf("different color: pixel %dx%d: got %v, want %v", x, y, got, want)
+						}
+					}
+				}
+			})
+		}
+	}
+}